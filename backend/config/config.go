@@ -6,31 +6,64 @@ import (
 )
 
 type Config struct {
-	TempDir      string
-	OutputDir    string
-	DatabaseURL  string
-	JWTSecret    string
-	RedisURL     string
-	AWSRegion    string
-	AWSAccessKey string
-	AWSSecretKey string
-	S3Bucket     string
+	TempDir       string
+	OutputDir     string
+	DatabaseURL   string
+	JWTSecret     string
+	RedisURL      string
+	AWSRegion     string
+	AWSAccessKey  string
+	AWSSecretKey  string
+	S3Bucket      string
+	S3Endpoint    string // S3-compatible service such as MinIO; empty for AWS
+	S3PathStyle   bool
+	S3SSE         string // server-side encryption: AES256 or aws:kms
+	S3KMSKeyID    string
+	StorageDriver string // local, s3 or minio
+	StorageDir    string // root of the local driver
+	EncryptionKey string // base64 master key; when set, stored files are envelope-encrypted per user
+	FontDir       string
+	WatermarkDir  string
+	ICCProfileDir string
+	AdminEmails   []string // users allowed on the admin endpoints
 }
 
 func Load() *Config {
 	return &Config{
-		TempDir:      os.Getenv("TEMP_DIR"),
-		OutputDir:    os.Getenv("OUTPUT_DIR"),
-		DatabaseURL:  os.Getenv("DATABASE_URL"),
-		JWTSecret:    os.Getenv("JWT_SECRET"),
-		RedisURL:     parseRedisURL(os.Getenv("REDIS_URL")),
-		AWSRegion:    os.Getenv("AWS_REGION"),
-		AWSAccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
-		AWSSecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
-		S3Bucket:     os.Getenv("AWS_S3_BUCKET"),
+		TempDir:       os.Getenv("TEMP_DIR"),
+		OutputDir:     os.Getenv("OUTPUT_DIR"),
+		DatabaseURL:   os.Getenv("DATABASE_URL"),
+		JWTSecret:     os.Getenv("JWT_SECRET"),
+		RedisURL:      parseRedisURL(os.Getenv("REDIS_URL")),
+		AWSRegion:     os.Getenv("AWS_REGION"),
+		AWSAccessKey:  os.Getenv("AWS_ACCESS_KEY_ID"),
+		AWSSecretKey:  os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		S3Bucket:      os.Getenv("AWS_S3_BUCKET"),
+		S3Endpoint:    os.Getenv("S3_ENDPOINT"),
+		S3PathStyle:   os.Getenv("S3_FORCE_PATH_STYLE") == "true",
+		S3SSE:         os.Getenv("S3_SSE"),
+		S3KMSKeyID:    os.Getenv("S3_SSE_KMS_KEY_ID"),
+		StorageDriver: os.Getenv("STORAGE_DRIVER"),
+		StorageDir:    os.Getenv("STORAGE_DIR"),
+		EncryptionKey: os.Getenv("ENCRYPTION_MASTER_KEY"),
+		FontDir:       os.Getenv("FONT_DIR"),
+		WatermarkDir:  os.Getenv("WATERMARK_DIR"),
+		ICCProfileDir: os.Getenv("ICC_PROFILE_DIR"),
+		AdminEmails:   parseList(os.Getenv("ADMIN_EMAILS")),
 	}
 }
 
+// parseList splits a comma-separated setting, dropping blanks
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // parseRedisURL converts redis:// URL to host:port format
 func parseRedisURL(redisURL string) string {
 	if redisURL == "" {
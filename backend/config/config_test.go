@@ -0,0 +1,25 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"ops@example.com", []string{"ops@example.com"}},
+		{" ops@example.com , root@example.com ", []string{"ops@example.com", "root@example.com"}},
+		{"ops@example.com,,  ,", []string{"ops@example.com"}},
+		{" , ", nil},
+	}
+
+	for _, tt := range tests {
+		if got := parseList(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseList(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
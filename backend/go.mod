@@ -31,6 +31,12 @@ require (
 	// Multi-format archive support: ZIP, TAR, 7Z, RAR, GZ, BZ2, XZ, LZ4
 	github.com/mholt/archiver/v3 v3.5.1
 
+	// XZ streams with a tunable dictionary, for tar.xz compression levels
+	github.com/ulikunitz/xz v0.5.9
+
+	// The archive/zip fork archiver reads zips with; its headers carry the full entry paths
+	github.com/klauspost/compress v1.11.4
+
 	// ===========================================
 	// DOCUMENT CONVERSION LIBRARIES
 	// ===========================================
@@ -79,15 +85,6 @@ require (
 	// MIME type detection
 	github.com/gabriel-vasile/mimetype v1.4.3 // indirect
 	github.com/gin-contrib/sse v0.1.0 // indirect
-
-	// Audio buffer management and PCM data handling
-	github.com/go-audio/audio v1.0.0 // indirect
-
-	// RIFF file format support (used by WAV)
-	github.com/go-audio/riff v1.0.0 // indirect
-
-	// WAV audio format encoding/decoding
-	github.com/go-audio/wav v1.1.0
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/go-playground/validator/v10 v10.20.0 // indirect
@@ -95,12 +92,6 @@ require (
 
 	// Snappy compression algorithm
 	github.com/golang/snappy v0.0.2 // indirect
-	// ===========================================
-	// AUDIO CONVERSION LIBRARIES
-	// ===========================================
-
-	// MP3 decoding library for audio processing
-	github.com/hajimehoshi/go-mp3 v0.3.4
 
 	// Database drivers
 	github.com/jackc/pgpassfile v1.0.0 // indirect
@@ -111,7 +102,6 @@ require (
 	github.com/json-iterator/go v1.1.12 // indirect
 
 	// Compression libraries
-	github.com/klauspost/compress v1.11.4 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.7 // indirect
 	github.com/klauspost/pgzip v1.2.5 // indirect
 	github.com/kr/text v0.2.0 // indirect
@@ -139,9 +129,6 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.12 // indirect
 
-	// XZ compression algorithm
-	github.com/ulikunitz/xz v0.5.9 // indirect
-
 	// Additional XZ compression support
 	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
 
@@ -149,7 +136,7 @@ require (
 	golang.org/x/arch v0.8.0 // indirect
 	golang.org/x/net v0.27.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
-	golang.org/x/text v0.30.0 // indirect
+	golang.org/x/text v0.30.0
 
 	// Protocol buffer support
 	google.golang.org/protobuf v1.34.1 // indirect
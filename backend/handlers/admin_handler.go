@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/qoal/file-processor/services"
+)
+
+// AdminHandler serves the operator endpoints; routes using it sit behind middleware.RequireAdmin
+type AdminHandler struct {
+	jobService *services.JobService
+}
+
+func NewAdminHandler(jobService *services.JobService) *AdminHandler {
+	return &AdminHandler{
+		jobService: jobService,
+	}
+}
+
+// ListDeadLetters returns the jobs that failed on every attempt, newest first
+func (h *AdminHandler) ListDeadLetters(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	entries, total, err := h.jobService.ListDeadLetters(context.Background(), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dead_letters": entries,
+		"total":        total,
+		"page":         page,
+		"limit":        limit,
+		"pages":        (total + int64(limit) - 1) / int64(limit),
+	})
+}
+
+// ReplayDeadLetter queues a dead-lettered job again with a fresh set of attempts
+func (h *AdminHandler) ReplayDeadLetter(c *gin.Context) {
+	entry, err := h.jobService.ReplayDeadLetter(context.Background(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":  entry.Task.JobID,
+		"status":  "job_requeued",
+		"message": "Job queued for processing",
+	})
+}
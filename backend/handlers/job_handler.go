@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"net/http"
+	"strings"
 
 	"github.com/qoal/file-processor/models"
 	"github.com/qoal/file-processor/services"
@@ -43,7 +44,9 @@ func (h *JobHandler) CreateJobHandler(c *gin.Context) {
 
 	// Parse request body
 	var req struct {
-		InputPath    string                 `json:"input_path" binding:"required"`
+		JobType      string                 `json:"job_type"`
+		InputPath    string                 `json:"input_path"`
+		InputPaths   []string               `json:"input_paths"`
 		OutputPath   string                 `json:"output_path"`
 		SourceFormat string                 `json:"source_format" binding:"required"`
 		TargetFormat string                 `json:"target_format" binding:"required"`
@@ -57,6 +60,79 @@ func (h *JobHandler) CreateJobHandler(c *gin.Context) {
 		return
 	}
 
+	// Merge jobs reference an ordered list of inputs; everything else needs one
+	switch req.JobType {
+	case models.JobTypeResponsive:
+		if req.InputPath == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request: input_path is required",
+			})
+			return
+		}
+		if !strings.EqualFold(req.TargetFormat, "zip") {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request: responsive jobs produce a zip; set target_format to zip",
+			})
+			return
+		}
+	case models.JobTypeList, models.JobTypeExtract:
+		if req.InputPath == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request: input_path is required",
+			})
+			return
+		}
+		// A list job writes its tree as JSON; an extract job writes a zip unless a single entry matches
+		want := "json"
+		if req.JobType == models.JobTypeExtract {
+			want = "zip"
+		}
+		if !strings.EqualFold(req.TargetFormat, want) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request: " + req.JobType + " jobs produce " + want + "; set target_format to " + want,
+			})
+			return
+		}
+	case "", models.JobTypeConvert:
+		if req.InputPath == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request: input_path is required",
+			})
+			return
+		}
+	case models.JobTypeMerge:
+		if len(req.InputPaths) < 2 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request: merge needs at least 2 input_paths",
+			})
+			return
+		}
+		req.InputPath = req.InputPaths[0]
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request: unknown job_type " + req.JobType,
+		})
+		return
+	}
+
+	// Inputs must be the caller's own files; any other key would have someone else's file converted for them
+	ctx := context.Background()
+	for _, key := range append([]string{req.InputPath}, req.InputPaths...) {
+		owned, err := h.jobService.OwnsFile(ctx, userIDStr, key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to create job: " + err.Error(),
+			})
+			return
+		}
+		if !owned {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Input file not found: " + key,
+			})
+			return
+		}
+	}
+
 	// Generate job ID
 	jobID := uuid.New().String()
 
@@ -64,7 +140,9 @@ func (h *JobHandler) CreateJobHandler(c *gin.Context) {
 	job := models.Job{
 		JobID:        jobID,
 		UserID:       userIDStr,
+		JobType:      req.JobType,
 		InputPath:    req.InputPath,
+		InputPaths:   req.InputPaths,
 		OutputPath:   req.OutputPath,
 		SourceFormat: req.SourceFormat,
 		TargetFormat: req.TargetFormat,
@@ -72,7 +150,6 @@ func (h *JobHandler) CreateJobHandler(c *gin.Context) {
 	}
 
 	// Add job to processing queue
-	ctx := context.Background()
 	if err := h.jobService.CreateJob(ctx, &job, req.Settings); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create job: " + err.Error(),
@@ -80,6 +157,17 @@ func (h *JobHandler) CreateJobHandler(c *gin.Context) {
 		return
 	}
 
+	// An identical job that already ran answers this one straight away
+	if job.Status == string(models.StatusCompleted) {
+		c.JSON(http.StatusOK, gin.H{
+			"job_id":     jobID,
+			"status":     job.Status,
+			"message":    "Job completed from an earlier identical job",
+			"created_at": job.CreatedAt,
+		})
+		return
+	}
+
 	c.JSON(http.StatusAccepted, gin.H{
 		"job_id":     jobID,
 		"status":     "job_created",
@@ -122,6 +210,7 @@ func (h *JobHandler) GetJobStatusHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"job_id":            job.JobID,
 		"status":            job.Status,
+		"job_type":          job.JobType,
 		"original_filename": job.OriginalFilename,
 		"file_size":         job.FileSize,
 		"source_format":     job.SourceFormat,
@@ -129,6 +218,10 @@ func (h *JobHandler) GetJobStatusHandler(c *gin.Context) {
 		"input_path":        job.InputPath,
 		"output_path":       job.OutputPath,
 		"error":             job.Error,
+		"attempts":          job.Attempts,
+		"next_retry_at":     job.NextRetryAt,
+		"manifest":          job.Manifest,
+		"listing":           job.Listing,
 		"created_at":        job.CreatedAt,
 		"updated_at":        job.UpdatedAt,
 	})
@@ -3,8 +3,8 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -23,19 +23,24 @@ const (
 )
 
 type UploadHandler struct {
-	db           *gorm.DB
-	localStorage *storage.LocalStorage
-	jobService   *services.JobService
+	db         *gorm.DB
+	storage    storage.Backend
+	jobService *services.JobService
 }
 
-func NewUploadHandler(db *gorm.DB, localStorage *storage.LocalStorage, jobService *services.JobService) *UploadHandler {
+func NewUploadHandler(db *gorm.DB, backend storage.Backend, jobService *services.JobService) *UploadHandler {
 	return &UploadHandler{
-		db:           db,
-		localStorage: localStorage,
-		jobService:   jobService,
+		db:         db,
+		storage:    backend,
+		jobService: jobService,
 	}
 }
 
+// storageFor returns the storage view for a user's files, which the encrypting backend needs to pick their key
+func (h *UploadHandler) storageFor(userID string) storage.Backend {
+	return storage.ForUser(h.storage, userID)
+}
+
 type UploadRequest struct {
 	TargetFormat  string `form:"target_format" binding:"required"`
 	QualityPreset string `form:"quality_preset"`
@@ -110,7 +115,7 @@ func (h *UploadHandler) UploadFile(c *gin.Context) {
 
 	// Validate file type
 	originalFilename := header.Filename
-	sourceFormat := strings.ToLower(filepath.Ext(originalFilename))
+	sourceFormat := storage.FileExtension(originalFilename)
 	if sourceFormat == "" {
 		c.JSON(http.StatusBadRequest, UploadResponse{
 			Success: false,
@@ -138,8 +143,19 @@ func (h *UploadHandler) UploadFile(c *gin.Context) {
 		return
 	}
 
-	// Save file locally
-	inputPath, err := h.localStorage.SaveFile(file, originalFilename, header.Size)
+	// Save file to storage; with the job service, by content, so a file uploaded again isn't stored again
+	ctx := context.Background()
+	var inputPath, inputDigest string
+	if h.jobService != nil {
+		inputPath, inputDigest, err = h.jobService.StoreUpload(ctx, userID, originalFilename, file, header.Size)
+		if err == nil {
+			// The job takes its own reference to the file
+			defer h.jobService.ReleaseBlob(ctx, userID, inputPath)
+		}
+	} else {
+		inputPath = storage.NewUploadKey(originalFilename)
+		err = h.storageFor(userID).Save(inputPath, file, header.Size, storage.GetMimeType(originalFilename))
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, UploadResponse{
 			Success: false,
@@ -161,19 +177,17 @@ func (h *UploadHandler) UploadFile(c *gin.Context) {
 		TargetFormat:     targetFormat,
 		Status:           string(models.StatusPending),
 		InputPath:        inputPath,
+		InputDigest:      inputDigest,
 		OutputPath:       "", // Will be set when processing completes
 		Error:            "",
 	}
 
-	// Use job service to create job (adds to database AND Redis queue)
+	// Use job service to create job (adds to database AND Redis queue, unless an identical job already ran)
 	if h.jobService != nil {
-		ctx := context.Background()
 		settings := map[string]interface{}{
 			"quality_preset": uploadReq.QualityPreset,
 		}
 		if err := h.jobService.CreateJob(ctx, &job, settings); err != nil {
-			// Clean up uploaded file on error
-			h.localStorage.DeleteFile(inputPath)
 			c.JSON(http.StatusInternalServerError, UploadResponse{
 				Success: false,
 				Message: "Failed to create job: " + err.Error(),
@@ -184,7 +198,7 @@ func (h *UploadHandler) UploadFile(c *gin.Context) {
 		// Fallback: create job directly in database (no Redis queue)
 		if err := h.db.Create(&job).Error; err != nil {
 			// Clean up uploaded file on error
-			h.localStorage.DeleteFile(inputPath)
+			h.storage.Delete(inputPath)
 			c.JSON(http.StatusInternalServerError, UploadResponse{
 				Success: false,
 				Message: "Failed to create job: " + err.Error(),
@@ -198,11 +212,11 @@ func (h *UploadHandler) UploadFile(c *gin.Context) {
 		Success:      true,
 		Message:      fmt.Sprintf("%s conversion job created successfully", category),
 		JobID:        jobID,
-		Status:       string(models.StatusPending),
+		Status:       job.Status,
 		OriginalName: originalFilename,
 		FileSize:     header.Size,
 		SourceFormat: sourceFormat,
-		TargetFormat: targetFormat,
+		TargetFormat: job.TargetFormat,
 		CreatedAt:    job.CreatedAt,
 		FileInfo: map[string]interface{}{
 			"category":       category,
@@ -328,67 +342,65 @@ func (h *UploadHandler) GetJobStatus(c *gin.Context) {
 		"input_path":        job.InputPath,
 		"output_path":       job.OutputPath,
 		"error":             job.Error,
+		"attempts":          job.Attempts,
+		"next_retry_at":     job.NextRetryAt,
+		"manifest":          job.Manifest,
+		"listing":           job.Listing,
 		"created_at":        job.CreatedAt,
 		"updated_at":        job.UpdatedAt,
 		"download_url":      downloadURL,
 	})
 }
 
-// DownloadFile serves the processed file for download
+// DownloadFile streams the processed file from storage
 func (h *UploadHandler) DownloadFile(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "User not authenticated",
-		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
 
-	// Extract user ID from user object
 	userModel, ok := user.(*models.User)
 	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Invalid user data",
-		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user data"})
 		return
 	}
 
-	userID := userModel.ID
-
 	jobID := c.Param("id")
-
 	var job models.Job
-	result := h.db.Where("job_id = ? AND user_id = ?", jobID, userID).First(&job)
+	result := h.db.Where("job_id = ? AND user_id = ?", jobID, userModel.ID).First(&job)
 
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "Job not found",
-			})
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch job",
-		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
 		return
 	}
 
-	// Check if job is completed
 	if job.Status != string(models.StatusCompleted) {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Job not completed",
-		})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Job not completed"})
 		return
 	}
 
-	// Check if output file exists
 	if job.OutputPath == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Output file path not set",
-		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Output file path not set"})
 		return
 	}
 
-	// Serve the file
-	c.FileAttachment(job.OutputPath, job.OriginalFilename)
+	fileReader, err := h.storageFor(userModel.ID).Open(job.OutputPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file"})
+		return
+	}
+	defer fileReader.Close()
+
+	// Trim compound extensions whole so "backup.tar.gz" downloads as "backup.zip", not "backup.tar.zip"
+	baseName := job.OriginalFilename[:len(job.OriginalFilename)-len(storage.FileExtension(job.OriginalFilename))]
+	outputFilename := fmt.Sprintf("%s.%s", baseName, job.TargetFormat)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", outputFilename))
+	c.Header("Content-Type", "application/octet-stream")
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, fileReader)
 }
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/qoal/file-processor/models"
+)
+
+// MergeFiles uploads several PDFs in order and queues a merge job combining them
+func (h *UploadHandler) MergeFiles(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, UploadResponse{Success: false, Message: "User not authenticated"})
+		return
+	}
+
+	userModel, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, UploadResponse{Success: false, Message: "Invalid user data"})
+		return
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, UploadResponse{Success: false, Message: "Invalid form data: " + err.Error()})
+		return
+	}
+
+	headers := form.File["files"]
+	if len(headers) < 2 {
+		c.JSON(http.StatusBadRequest, UploadResponse{Success: false, Message: "At least 2 files are required to merge"})
+		return
+	}
+
+	pageRanges := form.Value["page_ranges"]
+	if len(pageRanges) > len(headers) {
+		c.JSON(http.StatusBadRequest, UploadResponse{Success: false, Message: "More page ranges than files"})
+		return
+	}
+
+	var totalSize int64
+	titles := make([]string, len(headers))
+	for i, header := range headers {
+		if header.Size > MaxFileSize {
+			c.JSON(http.StatusBadRequest, UploadResponse{Success: false, Message: fmt.Sprintf("File too large: %s. Maximum size: 30MB", header.Filename)})
+			return
+		}
+		if strings.ToLower(filepath.Ext(header.Filename)) != ".pdf" {
+			c.JSON(http.StatusBadRequest, UploadResponse{Success: false, Message: fmt.Sprintf("Only PDF files can be merged: %s", header.Filename)})
+			return
+		}
+		totalSize += header.Size
+		titles[i] = strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
+	}
+
+	if h.jobService == nil {
+		c.JSON(http.StatusServiceUnavailable, UploadResponse{Success: false, Message: "Job processing is unavailable"})
+		return
+	}
+
+	// Inputs are stored by content; the job takes its own reference to each before these are released
+	ctx := context.Background()
+	inputPaths := make([]string, 0, len(headers))
+	defer func() {
+		for _, path := range inputPaths {
+			h.jobService.ReleaseBlob(ctx, userModel.ID, path)
+		}
+	}()
+
+	for _, header := range headers {
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, UploadResponse{Success: false, Message: "Failed to read file: " + header.Filename})
+			return
+		}
+
+		inputPath, _, err := h.jobService.StoreUpload(ctx, userModel.ID, header.Filename, file, header.Size)
+		file.Close()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, UploadResponse{Success: false, Message: "Failed to save file: " + err.Error()})
+			return
+		}
+		inputPaths = append(inputPaths, inputPath)
+	}
+
+	jobID := uuid.New().String()
+	job := models.Job{
+		JobID:            jobID,
+		UserID:           userModel.ID,
+		OriginalFilename: "merged.pdf",
+		FileSize:         totalSize,
+		SourceFormat:     "pdf",
+		TargetFormat:     "pdf",
+		Status:           string(models.StatusPending),
+		JobType:          models.JobTypeMerge,
+		InputPath:        inputPaths[0],
+		InputPaths:       inputPaths,
+	}
+
+	settings := map[string]interface{}{
+		"page_ranges":     pageRanges,
+		"bookmarks":       c.PostForm("bookmarks") == "true",
+		"bookmark_titles": titles,
+	}
+	if err := h.jobService.CreateJob(ctx, &job, settings); err != nil {
+		c.JSON(http.StatusInternalServerError, UploadResponse{Success: false, Message: "Failed to create job: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, UploadResponse{
+		Success:      true,
+		Message:      fmt.Sprintf("merge job created for %d files", len(inputPaths)),
+		JobID:        jobID,
+		Status:       string(models.StatusPending),
+		OriginalName: job.OriginalFilename,
+		FileSize:     totalSize,
+		SourceFormat: job.SourceFormat,
+		TargetFormat: job.TargetFormat,
+		CreatedAt:    job.CreatedAt,
+		FileInfo:     map[string]interface{}{"category": "document", "job_type": models.JobTypeMerge, "file_count": len(inputPaths)},
+	})
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/qoal/file-processor/models"
+	"github.com/qoal/file-processor/services"
+	"github.com/qoal/file-processor/storage"
+)
+
+// Direct uploads bypass the API, so they aren't held to MaxFileSize
+const (
+	MaxDirectUploadSize = 5 << 30 // 5 GiB, whether sent in one PUT or in parts
+
+	// Files above multipartThreshold are sent in parts of uploadPartSize
+	multipartThreshold = 100 << 20 // 100 MiB
+	uploadPartSize     = 64 << 20  // 64 MiB
+
+	presignExpiry = time.Hour
+	// completeGrace keeps a session open past its URLs, so an upload that finishes as they expire can be completed
+	completeGrace = 15 * time.Minute
+)
+
+type PresignUploadRequest struct {
+	Filename      string `json:"filename" binding:"required"`
+	Size          int64  `json:"size" binding:"required"`
+	TargetFormat  string `json:"target_format" binding:"required"`
+	QualityPreset string `json:"quality_preset"`
+	MD5           string `json:"md5"` // hex digest of the whole file; optional
+}
+
+type PresignedPart struct {
+	PartNumber int64  `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+type PresignUploadResponse struct {
+	Success   bool              `json:"success"`
+	Message   string            `json:"message"`
+	UploadID  string            `json:"upload_id,omitempty"`
+	Method    string            `json:"method,omitempty"`
+	URL       string            `json:"url,omitempty"`     // single PUT
+	Headers   map[string]string `json:"headers,omitempty"` // must accompany the single PUT
+	PartSize  int64             `json:"part_size,omitempty"`
+	Parts     []PresignedPart   `json:"parts,omitempty"` // multipart; every part but the last is part_size bytes
+	ExpiresAt time.Time         `json:"expires_at,omitempty"`
+}
+
+type CompleteUploadRequest struct {
+	UploadID string                  `json:"upload_id" binding:"required"`
+	Parts    []storage.CompletedPart `json:"parts"` // the ETag S3 returned for each part of a multipart upload
+}
+
+// PresignUpload clears a client to upload a file straight to S3 and returns the URLs to send it to.
+// Small files get one PUT URL; larger ones a multipart upload with a URL per part.
+func (h *UploadHandler) PresignUpload(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, PresignUploadResponse{Success: false, Message: "User not authenticated"})
+		return
+	}
+
+	userModel, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, PresignUploadResponse{Success: false, Message: "Invalid user data"})
+		return
+	}
+
+	if h.jobService == nil {
+		c.JSON(http.StatusServiceUnavailable, PresignUploadResponse{Success: false, Message: "Job processing is unavailable"})
+		return
+	}
+
+	uploader, ok := h.storage.(storage.DirectUploader)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, PresignUploadResponse{Success: false, Message: "Direct uploads are not supported by this storage backend"})
+		return
+	}
+
+	var req PresignUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, PresignUploadResponse{Success: false, Message: "Invalid request: " + err.Error()})
+		return
+	}
+
+	if req.Size < 1 || req.Size > MaxDirectUploadSize {
+		c.JSON(http.StatusBadRequest, PresignUploadResponse{Success: false, Message: fmt.Sprintf("File size must be between 1 byte and %dGB", MaxDirectUploadSize>>30)})
+		return
+	}
+
+	var contentMD5 string
+	if req.MD5 != "" {
+		digest, err := hex.DecodeString(req.MD5)
+		if err != nil || len(digest) != 16 {
+			c.JSON(http.StatusBadRequest, PresignUploadResponse{Success: false, Message: "md5 must be a hex MD5 digest"})
+			return
+		}
+		req.MD5 = strings.ToLower(req.MD5)
+		contentMD5 = base64.StdEncoding.EncodeToString(digest)
+	}
+
+	sourceFormat := strings.TrimPrefix(storage.FileExtension(req.Filename), ".")
+	if sourceFormat == "" {
+		c.JSON(http.StatusBadRequest, PresignUploadResponse{Success: false, Message: "Cannot determine file type from filename"})
+		return
+	}
+	if _, err := storage.ValidateFileType(req.Filename); err != nil {
+		c.JSON(http.StatusBadRequest, PresignUploadResponse{Success: false, Message: err.Error()})
+		return
+	}
+
+	session := &services.UploadSession{
+		ID:               uuid.New().String(),
+		UserID:           userModel.ID,
+		Key:              storage.NewUploadKey(req.Filename),
+		OriginalFilename: req.Filename,
+		Size:             req.Size,
+		MD5:              req.MD5,
+		SourceFormat:     sourceFormat,
+		TargetFormat:     req.TargetFormat,
+		Settings:         map[string]interface{}{"quality_preset": req.QualityPreset},
+		ExpiresAt:        time.Now().Add(presignExpiry + completeGrace),
+	}
+	contentType := storage.GetMimeType(req.Filename)
+
+	resp := PresignUploadResponse{
+		Success:   true,
+		Message:   "Upload the file, then complete the upload to queue its job",
+		UploadID:  session.ID,
+		Method:    http.MethodPut,
+		ExpiresAt: session.ExpiresAt.Add(-completeGrace), // when the URLs do
+	}
+
+	if req.Size <= multipartThreshold {
+		url, headers, err := uploader.PresignPut(session.Key, contentType, contentMD5, presignExpiry)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, PresignUploadResponse{Success: false, Message: err.Error()})
+			return
+		}
+		resp.URL = url
+		resp.Headers = headers
+	} else {
+		multipartID, err := uploader.CreateMultipartUpload(session.Key, contentType)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, PresignUploadResponse{Success: false, Message: err.Error()})
+			return
+		}
+		session.MultipartID = multipartID
+
+		partCount := (req.Size + uploadPartSize - 1) / uploadPartSize
+		resp.PartSize = uploadPartSize
+		resp.Parts = make([]PresignedPart, partCount)
+		for i := range resp.Parts {
+			partNumber := int64(i + 1)
+			url, err := uploader.PresignUploadPart(session.Key, multipartID, partNumber, presignExpiry)
+			if err != nil {
+				uploader.AbortMultipartUpload(session.Key, multipartID)
+				c.JSON(http.StatusInternalServerError, PresignUploadResponse{Success: false, Message: err.Error()})
+				return
+			}
+			resp.Parts[i] = PresignedPart{PartNumber: partNumber, URL: url}
+		}
+	}
+
+	if err := h.jobService.SaveUploadSession(context.Background(), session); err != nil {
+		if session.MultipartID != "" {
+			uploader.AbortMultipartUpload(session.Key, session.MultipartID)
+		}
+		c.JSON(http.StatusInternalServerError, PresignUploadResponse{Success: false, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// CompleteUpload checks that a direct upload arrived whole, then creates and queues its job
+func (h *UploadHandler) CompleteUpload(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, UploadResponse{Success: false, Message: "User not authenticated"})
+		return
+	}
+
+	userModel, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, UploadResponse{Success: false, Message: "Invalid user data"})
+		return
+	}
+
+	if h.jobService == nil {
+		c.JSON(http.StatusServiceUnavailable, UploadResponse{Success: false, Message: "Job processing is unavailable"})
+		return
+	}
+
+	uploader, ok := h.storage.(storage.DirectUploader)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, UploadResponse{Success: false, Message: "Direct uploads are not supported by this storage backend"})
+		return
+	}
+
+	var req CompleteUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, UploadResponse{Success: false, Message: "Invalid request: " + err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	session, err := h.jobService.GetUploadSession(ctx, req.UploadID, userModel.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, UploadResponse{Success: false, Message: err.Error()})
+		return
+	}
+
+	if session.MultipartID != "" {
+		if len(req.Parts) == 0 {
+			c.JSON(http.StatusBadRequest, UploadResponse{Success: false, Message: "parts are required to complete a multipart upload"})
+			return
+		}
+		// A failed completion leaves the parts in place, so the client can retry with corrected ETags
+		if err := uploader.CompleteMultipartUpload(session.Key, session.MultipartID, req.Parts); err != nil {
+			c.JSON(http.StatusBadRequest, UploadResponse{Success: false, Message: err.Error()})
+			return
+		}
+	}
+
+	// Completing before the PUT has landed is retryable; a wrong object is discarded with its session
+	info, err := h.storage.Stat(session.Key)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, UploadResponse{Success: false, Message: "Uploaded file not found"})
+		return
+	}
+	if err := verifyUploadedObject(info, session); err != nil {
+		h.jobService.ClaimUploadSession(ctx, session)
+		h.storage.Delete(session.Key)
+		c.JSON(http.StatusBadRequest, UploadResponse{Success: false, Message: err.Error()})
+		return
+	}
+
+	// Only one request gets past the claim, so a job is created once per upload
+	if err := h.jobService.ClaimUploadSession(ctx, session); err != nil {
+		c.JSON(http.StatusConflict, UploadResponse{Success: false, Message: err.Error()})
+		return
+	}
+
+	category, _ := storage.ValidateFileType(session.OriginalFilename)
+
+	jobID := uuid.New().String()
+	job := models.Job{
+		JobID:            jobID,
+		UserID:           userModel.ID,
+		OriginalFilename: session.OriginalFilename,
+		FileSize:         session.Size,
+		SourceFormat:     session.SourceFormat,
+		TargetFormat:     session.TargetFormat,
+		Status:           string(models.StatusPending),
+		InputPath:        session.Key,
+	}
+
+	if err := h.jobService.CreateJob(ctx, &job, session.Settings); err != nil {
+		h.storage.Delete(session.Key)
+		c.JSON(http.StatusInternalServerError, UploadResponse{Success: false, Message: "Failed to create job: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, UploadResponse{
+		Success:      true,
+		Message:      fmt.Sprintf("%s conversion job created successfully", category),
+		JobID:        jobID,
+		Status:       string(models.StatusPending),
+		OriginalName: session.OriginalFilename,
+		FileSize:     session.Size,
+		SourceFormat: session.SourceFormat,
+		TargetFormat: session.TargetFormat,
+		CreatedAt:    job.CreatedAt,
+		FileInfo:     map[string]interface{}{"category": category, "quality_preset": session.Settings["quality_preset"]},
+	})
+}
+
+// verifyUploadedObject checks the stored object against the size and checksum the upload was cleared for.
+// S3 has already checked the body against the Content-MD5 signed into a single PUT's URL, and each part of a
+// multipart upload against its ETag. A single PUT's ETag is compared as well, being the MD5 of the content,
+// except under SSE-KMS, where it isn't; Stat reports the encryption S3 applied, including a bucket default.
+func verifyUploadedObject(info *storage.ObjectInfo, session *services.UploadSession) error {
+	if info.Size != session.Size {
+		return fmt.Errorf("uploaded file is %d bytes, expected %d", info.Size, session.Size)
+	}
+	if session.MD5 == "" || session.MultipartID != "" || strings.HasPrefix(info.Encryption, "aws:kms") {
+		return nil
+	}
+	if info.ETag != session.MD5 {
+		return fmt.Errorf("uploaded file checksum does not match")
+	}
+	return nil
+}
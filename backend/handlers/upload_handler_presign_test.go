@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/qoal/file-processor/services"
+	"github.com/qoal/file-processor/storage"
+)
+
+func TestVerifyUploadedObject(t *testing.T) {
+	const md5 = "9e107d9d372bb6826bd81d3542a419d6"
+
+	tests := []struct {
+		name    string
+		info    storage.ObjectInfo
+		session services.UploadSession
+		wantErr bool
+	}{
+		{"no checksum", storage.ObjectInfo{Size: 10, ETag: "anything"}, services.UploadSession{Size: 10}, false},
+		{"size mismatch", storage.ObjectInfo{Size: 9, ETag: md5}, services.UploadSession{Size: 10, MD5: md5}, true},
+		{"matching ETag", storage.ObjectInfo{Size: 10, ETag: md5}, services.UploadSession{Size: 10, MD5: md5}, false},
+		{"mismatched ETag", storage.ObjectInfo{Size: 10, ETag: "0cc175b9c0f1b6a831c399e269772661"}, services.UploadSession{Size: 10, MD5: md5}, true},
+		{"SSE-S3 ETag is still the MD5", storage.ObjectInfo{Size: 10, ETag: "0cc175b9c0f1b6a831c399e269772661", Encryption: "AES256"}, services.UploadSession{Size: 10, MD5: md5}, true},
+		{"SSE-KMS ETag isn't the MD5", storage.ObjectInfo{Size: 10, ETag: "5d41402abc4b2a76b9719d911017c592", Encryption: "aws:kms"}, services.UploadSession{Size: 10, MD5: md5}, false},
+		{"DSSE-KMS ETag isn't the MD5", storage.ObjectInfo{Size: 10, ETag: "5d41402abc4b2a76b9719d911017c592", Encryption: "aws:kms:dsse"}, services.UploadSession{Size: 10, MD5: md5}, false},
+		{"multipart ETag", storage.ObjectInfo{Size: 10, ETag: "5d41402abc4b2a76b9719d911017c592-2"}, services.UploadSession{Size: 10, MD5: md5, MultipartID: "mp"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyUploadedObject(&tt.info, &tt.session)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyUploadedObject() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
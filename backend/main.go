@@ -63,27 +63,39 @@ func main() {
 	// Initialize services
 	authService := services.NewAuthService(db)
 
-	// Initialize S3 storage
-	s3Storage, err := storage.NewS3Storage(cfg.AWSRegion, cfg.S3Bucket, cfg.AWSAccessKey, cfg.AWSSecretKey)
+	// Initialize storage backend
+	backend, err := storage.New(cfg)
 	if err != nil {
-		log.Fatal("Failed to initialize S3 storage:", err)
+		log.Fatal("Failed to initialize storage:", err)
+	}
+	log.Printf("Using %T storage", backend)
+
+	// Envelope-encrypt stored files per user when a master key is configured
+	if cfg.EncryptionKey != "" {
+		keyService, err := services.NewKeyService(db, cfg.EncryptionKey)
+		if err != nil {
+			log.Fatal("Failed to initialize encryption:", err)
+		}
+		backend = storage.NewEncryptedBackend(backend, keyService)
+		log.Println("Encrypting stored files per user")
 	}
-	log.Println("Using S3 storage")
 
 	var jobService *services.JobService
 	if redisClient != nil {
-		jobService = services.NewJobService(db, redisClient)
+		jobService = services.NewJobService(db, redisClient, backend)
 	}
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(authService)
 	var jobHandler *handlers.JobHandler
+	var adminHandler *handlers.AdminHandler
 	if jobService != nil {
 		jobHandler = handlers.NewJobHandler(jobService)
+		adminHandler = handlers.NewAdminHandler(jobService)
 	}
 
 	// Initialize upload handler
-	uploadHandler := handlers.NewUploadHandlerS3(db, s3Storage, jobService)
+	uploadHandler := handlers.NewUploadHandler(db, backend, jobService)
 
 	// Initialize Gin router
 	router := gin.Default()
@@ -119,22 +131,35 @@ func main() {
 			protected.GET("/status/:id", jobHandler.GetJobStatusHandler)
 		}
 		
-		protected.POST("/upload", func(c *gin.Context) {
-			uploadHandler.UploadFileS3(c, s3Storage)
-		})
-		protected.GET("/download/:id", func(c *gin.Context) {
-			uploadHandler.DownloadFileS3(c, s3Storage)
-		})
+		protected.POST("/upload", uploadHandler.UploadFile)
+		protected.POST("/upload/presign", uploadHandler.PresignUpload)
+		protected.POST("/upload/complete", uploadHandler.CompleteUpload)
+		protected.POST("/upload/merge", uploadHandler.MergeFiles)
+		protected.GET("/download/:id", uploadHandler.DownloadFile)
 		
 		protected.GET("/jobs", uploadHandler.GetUserJobs)
 		protected.GET("/jobs/:id", uploadHandler.GetJobStatus)
 	}
 
-	// Start S3 worker in background (only if Redis is available)
+	// Admin routes, for the users listed in ADMIN_EMAILS
+	if adminHandler != nil {
+		admin := router.Group("/api/admin")
+		admin.Use(middleware.JWTAuth(authService), middleware.RequireAdmin(cfg.AdminEmails))
+		{
+			admin.GET("/dlq", adminHandler.ListDeadLetters)
+			admin.POST("/dlq/:id/replay", adminHandler.ReplayDeadLetter)
+		}
+	}
+
+	// Start worker in background (only if Redis is available); unencrypted local files are processed in place,
+	// anything else is copied (and decrypted) into TempDir first
 	if jobService != nil {
 		go func() {
-			processor := worker.NewProcessorS3(jobService, cfg, redisClient, s3Storage)
-			processor.Start(ctx)
+			if _, local := backend.(storage.LocalFiles); local {
+				worker.NewProcessor(jobService, cfg, redisClient, backend).Start(ctx)
+			} else {
+				worker.NewProcessorS3(jobService, cfg, redisClient, backend).Start(ctx)
+			}
 		}()
 	}
 
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qoal/file-processor/models"
+)
+
+// RequireAdmin lets through only authenticated users whose email is in adminEmails; it must follow JWTAuth
+func RequireAdmin(adminEmails []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, _ := c.Get("user")
+		userModel, ok := user.(*models.User)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			c.Abort()
+			return
+		}
+
+		for _, email := range adminEmails {
+			if strings.EqualFold(email, userModel.Email) {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		c.Abort()
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qoal/file-processor/models"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	admins := []string{"ops@example.com", "Root@Example.com"}
+
+	tests := []struct {
+		name string
+		user interface{}
+		want int
+	}{
+		{"admin", &models.User{Email: "ops@example.com"}, http.StatusOK},
+		{"admin in another case", &models.User{Email: "root@example.COM"}, http.StatusOK},
+		{"other user", &models.User{Email: "someone@example.com"}, http.StatusForbidden},
+		{"lookalike", &models.User{Email: "ops@example.com.evil"}, http.StatusForbidden},
+		{"no user", nil, http.StatusUnauthorized},
+		{"wrong user type", "ops@example.com", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/admin", func(c *gin.Context) {
+				if tt.user != nil {
+					c.Set("user", tt.user)
+				}
+				c.Next()
+			}, RequireAdmin(admins), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireAdminWithNoAdmins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/admin", func(c *gin.Context) {
+		c.Set("user", &models.User{Email: ""})
+		c.Next()
+	}, RequireAdmin(nil), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
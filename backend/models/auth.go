@@ -34,3 +34,16 @@ type AuthResponse struct {
 	User      User      `json:"user"`
 	ExpiresAt time.Time `json:"expires_at"`
 }
+
+// UserKey holds a user's data key, wrapped by the server's master key. The data key in turn wraps the key
+// of each file stored for the user.
+type UserKey struct {
+	UserID     string    `json:"-" gorm:"primaryKey;type:uuid"`
+	WrappedKey []byte    `json:"-" gorm:"not null"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TableName specifies the custom table name for UserKey model
+func (UserKey) TableName() string {
+	return "qoal_user_key"
+}
@@ -7,29 +7,125 @@ type JobStatus string
 const (
 	StatusPending    JobStatus = "pending"
 	StatusProcessing JobStatus = "processing"
+	StatusRetrying   JobStatus = "retrying" // Failed for a reason that may pass; queued again at NextRetryAt
 	StatusCompleted  JobStatus = "completed"
 	StatusFailed     JobStatus = "failed"
 )
 
+// Job types select how the worker treats a job's inputs
+const (
+	JobTypeConvert    = "convert"    // One input converted to TargetFormat
+	JobTypeMerge      = "merge"      // Ordered InputPaths combined into one output
+	JobTypeResponsive = "responsive" // One image rendered at several widths and formats, zipped with a manifest
+	JobTypeList       = "list"       // An archive's entry tree, stored on the job and written as JSON
+	JobTypeExtract    = "extract"    // The archive entries matching glob patterns, zipped or as the single match
+)
+
 // Job represents a file conversion job in the database
 type Job struct {
-	ID               uint       `gorm:"primaryKey" json:"id"`
-	JobID            string     `gorm:"unique;not null" json:"job_id"`     // UUID string for external reference
-	UserID           string     `gorm:"not null" json:"user_id"`           // Foreign key to User (UUID string)
-	OriginalFilename string     `gorm:"not null" json:"original_filename"` // Original file name
-	FileSize         int64      `gorm:"not null" json:"file_size"`         // File size in bytes
-	SourceFormat     string     `gorm:"not null" json:"source_format"`     // Source file extension
-	TargetFormat     string     `gorm:"not null" json:"target_format"`     // Target file extension
-	Status           string     `gorm:"default:'pending'" json:"status"`   // Job status
-	InputPath        string     `gorm:"not null" json:"input_path"`        // Local input file path
-	OutputPath       string     `json:"output_path"`                       // Local output file path (empty until completed)
-	Error            string     `json:"error,omitempty"`                   // Error message if failed
-	CompletedAt      *time.Time `json:"completed_at,omitempty"`            // Completion timestamp (null until completed)
-	CreatedAt        time.Time  `json:"created_at"`
-	UpdatedAt        time.Time  `json:"updated_at"`
+	ID               uint            `gorm:"primaryKey" json:"id"`
+	JobID            string          `gorm:"unique;not null" json:"job_id"`                // UUID string for external reference
+	UserID           string          `gorm:"not null" json:"user_id"`                      // Foreign key to User (UUID string)
+	OriginalFilename string          `gorm:"not null" json:"original_filename"`            // Original file name
+	FileSize         int64           `gorm:"not null" json:"file_size"`                    // File size in bytes
+	SourceFormat     string          `gorm:"not null" json:"source_format"`                // Source file extension
+	TargetFormat     string          `gorm:"not null" json:"target_format"`                // Target file extension
+	Status           string          `gorm:"default:'pending'" json:"status"`              // Job status
+	JobType          string          `gorm:"default:'convert'" json:"job_type"`            // Job type (convert, merge)
+	InputPath        string          `gorm:"not null" json:"input_path"`                   // Local input file path
+	InputPaths       []string        `gorm:"serializer:json" json:"input_paths,omitempty"` // Ordered inputs for merge jobs
+	InputDigest      string          `json:"input_digest,omitempty"`                       // SHA-256 of a stored upload input; keys the result cache
+	HeldBlobs        []string        `gorm:"serializer:json" json:"-"`                     // Stored inputs the job holds a reference to
+	OutputPath       string          `json:"output_path"`                                  // Local output file path (empty until completed)
+	Error            string          `json:"error,omitempty"`                              // Error message if failed
+	Attempts         int             `gorm:"default:0" json:"attempts"`                    // Times a worker has started the job
+	NextRetryAt      *time.Time      `json:"next_retry_at,omitempty"`                      // When a retrying job runs again
+	Manifest         *Manifest       `gorm:"serializer:json" json:"manifest,omitempty"`    // Variant listing for responsive jobs
+	Listing          *ArchiveListing `gorm:"serializer:json" json:"listing,omitempty"`     // Entry tree for list jobs
+	CompletedAt      *time.Time      `json:"completed_at,omitempty"`                       // Completion timestamp (null until completed)
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
 }
 
 // TableName specifies the custom table name for Job model
 func (Job) TableName() string {
 	return "qoal_job"
 }
+
+// Blob is a stored file that jobs share: an upload, stored once per user and content, or a job's output,
+// which later identical jobs reuse. Each job holds a reference to the blobs it uses, and a blob's file is
+// deleted with its last reference.
+type Blob struct {
+	StorageKey string    `gorm:"primaryKey" json:"storage_key"`
+	UserID     string    `gorm:"not null" json:"user_id"`
+	Digest     string    `json:"digest,omitempty"` // SHA-256 of an upload; empty for outputs
+	Size       int64     `json:"size"`
+	RefCount   int       `gorm:"not null" json:"ref_count"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TableName specifies the custom table name for Blob model
+func (Blob) TableName() string {
+	return "qoal_blob"
+}
+
+// CachedResult is what a job produced from an input, kept so an identical job can complete without running
+type CachedResult struct {
+	CacheKey     string          `gorm:"primaryKey" json:"cache_key"` // hash of the user, job type, input digest, target format and settings
+	UserID       string          `gorm:"not null" json:"user_id"`
+	InputDigest  string          `gorm:"not null" json:"input_digest"`
+	TargetFormat string          `gorm:"not null" json:"target_format"` // the format produced, which extract jobs may change
+	OutputPath   string          `gorm:"not null" json:"output_path"`
+	Manifest     *Manifest       `gorm:"serializer:json" json:"manifest,omitempty"`
+	Listing      *ArchiveListing `gorm:"serializer:json" json:"listing,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// TableName specifies the custom table name for CachedResult model
+func (CachedResult) TableName() string {
+	return "qoal_conversion_cache"
+}
+
+// Manifest describes the variants a responsive job packed into its zip
+type Manifest struct {
+	Source        ManifestImage     `json:"source"`
+	Variants      []ManifestVariant `json:"variants"`
+	Srcset        map[string]string `json:"srcset"`                   // Ready-made srcset attribute per format
+	SkippedWidths []int             `json:"skipped_widths,omitempty"` // Requested widths above the source width
+}
+
+// ManifestImage is the source image a responsive set was rendered from
+type ManifestImage struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"`
+}
+
+// ManifestVariant is one rendered file inside a responsive set's zip
+type ManifestVariant struct {
+	File   string `json:"file"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// ArchiveListing is the entry tree of an archive, as produced by a list job
+type ArchiveListing struct {
+	Format    string          `json:"format"`
+	Files     int             `json:"files"`
+	Dirs      int             `json:"dirs"`
+	TotalSize int64           `json:"total_size"` // Uncompressed bytes across all files
+	Tree      []*ArchiveEntry `json:"tree"`
+}
+
+// ArchiveEntry is a file or directory in an ArchiveListing; directories carry their contents as children
+type ArchiveEntry struct {
+	Name     string          `json:"name"`
+	Path     string          `json:"path"`
+	Dir      bool            `json:"dir"`
+	Size     int64           `json:"size"`
+	Modified *time.Time      `json:"modified,omitempty"`
+	CRC32    string          `json:"crc32,omitempty"` // Hex, as zip and 7z print it
+	Children []*ArchiveEntry `json:"children,omitempty"`
+}
@@ -4,6 +4,7 @@ type ProcessingJob struct {
 	JobID        string                 `json:"job_id"`
 	UserID       string                 `json:"user_id"`
 	InputPath    string                 `json:"input_path"`
+	InputPaths   []string               `json:"input_paths,omitempty"`
 	OutputPath   string                 `json:"output_path"`
 	SourceFormat string                 `json:"source_format"`
 	TargetFormat string                 `json:"target_format"`
@@ -11,4 +12,6 @@ type ProcessingJob struct {
 	Status       JobStatus              `json:"status"`
 	Progress     int                    `json:"progress"`
 	Settings     map[string]interface{} `json:"settings"`
+	Manifest     *Manifest              `json:"manifest,omitempty"`
+	Listing      *ArchiveListing        `json:"listing,omitempty"`
 }
@@ -2,83 +2,233 @@ package services
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
 
 	"github.com/mholt/archiver/v3"
 	"github.com/qoal/file-processor/models"
+	"github.com/ulikunitz/xz"
 )
 
-func (p *ArchiveProcessor) convertRarToZip(input, output string, job *models.ProcessingJob) (string, error) {
-	// Create temporary directory for extraction
-	tempDir, err := os.MkdirTemp("", "archive_conv_")
+// ArchiveOptions carries the settings that shape an archive conversion's output and unlock its input
+type ArchiveOptions struct {
+	Level         int    // 0 (store) to 9, from compression_level
+	Password      string // protects zip and 7z output with AES-256
+	InputPassword string // opens protected zip, 7z and rar inputs
+	Limits        ExtractLimits
+}
+
+// passwordArchives are the formats that can carry a password; tarballs have no encryption of their own
+var passwordArchives = map[string]bool{"zip": true, "7z": true, "rar": true}
+
+// xzDictCaps follows the dictionary sizes of the xz presets, which is what most of their level difference comes from
+var xzDictCaps = map[int]int{0: 256 << 10, 1: 1 << 20, 2: 2 << 20, 3: 4 << 20, 4: 4 << 20, 5: 8 << 20, 6: 8 << 20, 7: 16 << 20, 8: 32 << 20, 9: 64 << 20}
+
+// readableArchives and writableArchives list the canonical formats a conversion can start from and end in;
+// RAR is proprietary to write, so it is source only
+var (
+	readableArchives = map[string]bool{"zip": true, "7z": true, "rar": true, "tar": true, "tar.gz": true, "tar.bz2": true, "tar.xz": true}
+	writableArchives = map[string]bool{"zip": true, "7z": true, "tar": true, "tar.gz": true, "tar.bz2": true, "tar.xz": true}
+)
+
+// newArchiver returns the archiver/v3 format for a canonical archive format at a compression level;
+// 7z has none and goes through the binary, tar.xz is compressed by createTarXz
+func newArchiver(format string, level int) interface{} {
+	switch format {
+	case "zip":
+		zip := archiver.NewZip()
+		zip.CompressionLevel = level
+		if level == 0 {
+			zip.FileMethod = archiver.Store
+		}
+		return zip
+	case "rar":
+		return archiver.NewRar()
+	case "tar":
+		return archiver.NewTar()
+	case "tar.gz":
+		tarGz := archiver.NewTarGz()
+		tarGz.CompressionLevel = level
+		return tarGz
+	case "tar.bz2":
+		tarBz2 := archiver.NewTarBz2()
+		// bzip2 has no store mode; 1 is its fastest
+		tarBz2.CompressionLevel = max(level, 1)
+		return tarBz2
+	case "tar.xz":
+		return archiver.NewTarXz()
+	default:
+		return nil
+	}
+}
+
+// convertArchive unpacks the input into a scratch directory and packs its contents into the target format
+func (p *ArchiveProcessor) convertArchive(input, output, source, target string, opts ArchiveOptions, job *models.ProcessingJob) (string, error) {
+	if opts.Password != "" && !passwordArchives[target] {
+		return "", fmt.Errorf("%s output can't be password protected; use zip or 7z", target)
+	}
+	if opts.InputPassword != "" && !passwordArchives[source] {
+		return "", fmt.Errorf("%s archives have no password protection", source)
+	}
+
+	tempDir, err := os.MkdirTemp(p.config.TempDir, "archive_conv_")
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp directory: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
 
-	// Extract RAR file
-	rar := archiver.NewRar()
-	if err := rar.Unarchive(input, tempDir); err != nil {
-		return "", fmt.Errorf("failed to extract rar file: %w", err)
+	if err := p.extractArchive(source, input, tempDir, opts.InputPassword, opts.Limits, nil); err != nil {
+		return "", err
 	}
-
-	// Create ZIP file
-	zip := archiver.NewZip()
-	if err := zip.Archive([]string{tempDir}, output); err != nil {
-		return "", fmt.Errorf("failed to create zip file: %w", err)
+	if err := p.createArchive(target, tempDir, output, opts); err != nil {
+		return "", err
 	}
 
 	return output, nil
 }
 
-func (p *ArchiveProcessor) convertTarGzToZip(input, output string, job *models.ProcessingJob) (string, error) {
-	// Create temporary directory for extraction
-	tempDir, err := os.MkdirTemp("", "archive_conv_")
+// extractArchive unpacks an archive of the given canonical format into dest within the extraction limits.
+// archiver/v3 can't decrypt, so protected inputs are opened by the 7z binary. match, when set, skips
+// unwanted files.
+func (p *ArchiveProcessor) extractArchive(format, input, dest, password string, limits ExtractLimits, match func(string) bool) error {
+	guard, err := newExtractGuard(input, limits)
 	if err != nil {
-		return "", fmt.Errorf("failed to create temp directory: %w", err)
+		return err
 	}
-	defer os.RemoveAll(tempDir)
 
-	// Extract tar.gz file
-	tarGz := archiver.NewTarGz()
-	if err := tarGz.Unarchive(input, tempDir); err != nil {
-		return "", fmt.Errorf("failed to extract tar.gz file: %w", err)
+	if format == "7z" || password != "" {
+		// The listing vets every entry up front; the sizes it declares are then held to what is really written
+		items, err := p.listWith7z(input, password, guard)
+		if err != nil {
+			return err
+		}
+		writeGuard := &extractGuard{limits: limits, archiveSize: guard.archiveSize}
+		return p.extractWith7z(format, input, dest, password, items, writeGuard, match)
 	}
 
-	// Create ZIP file
-	zip := archiver.NewZip()
-	if err := zip.Archive([]string{tempDir}, output); err != nil {
-		return "", fmt.Errorf("failed to create zip file: %w", err)
+	walker, ok := newArchiver(format, 0).(archiver.Walker)
+	if !ok {
+		return fmt.Errorf("cannot extract %s archives", format)
 	}
+	if err := safeUnarchive(walker, input, dest, guard, match); err != nil {
+		return fmt.Errorf("failed to extract %s file: %w", format, err)
+	}
+	return nil
+}
 
-	return output, nil
+// createArchive packs the contents of sourceDir, not the directory itself, into output
+func (p *ArchiveProcessor) createArchive(format, sourceDir, output string, opts ArchiveOptions) error {
+	entries, err := os.ReadDir(sourceDir)
+	if err != nil {
+		return fmt.Errorf("failed to read extracted files: %w", err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("archive is empty")
+	}
+	names := make([]string, len(entries))
+	sources := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+		sources[i] = filepath.Join(sourceDir, entry.Name())
+	}
+
+	// archiver/v3 can't encrypt, so protected zips are written by the 7z binary as well
+	if format == "7z" || opts.Password != "" {
+		return p.createWith7z(format, sourceDir, names, output, opts)
+	}
+	if format == "tar.xz" {
+		return p.createTarXz(sources, output, opts.Level)
+	}
+
+	arch, ok := newArchiver(format, opts.Level).(archiver.Archiver)
+	if !ok {
+		return fmt.Errorf("cannot create %s archives", format)
+	}
+	if err := arch.Archive(sources, output); err != nil {
+		return fmt.Errorf("failed to create %s file: %w", format, err)
+	}
+	return nil
 }
 
-func (p *ArchiveProcessor) convertZipToTarGz(input, output string, job *models.ProcessingJob) (string, error) {
-	// Create temporary directory for extraction
-	tempDir, err := os.MkdirTemp("", "archive_conv_")
+// createWith7z writes the named entries of sourceDir into a 7z or zip archive through the 7z binary, AES-256
+// encrypted when a password is set
+func (p *ArchiveProcessor) createWith7z(format, sourceDir string, names []string, output string, opts ArchiveOptions) error {
+	// 7z runs in sourceDir, so the output needs a path that doesn't depend on the working directory
+	output, err := filepath.Abs(output)
 	if err != nil {
-		return "", fmt.Errorf("failed to create temp directory: %w", err)
+		return fmt.Errorf("failed to resolve output path: %w", err)
 	}
-	defer os.RemoveAll(tempDir)
 
-	// Extract ZIP file
-	zip := archiver.NewZip()
-	if err := zip.Unarchive(input, tempDir); err != nil {
-		return "", fmt.Errorf("failed to extract zip file: %w", err)
+	args := []string{"a", "-t" + format, "-y", "-mx=" + strconv.Itoa(opts.Level)}
+	if opts.Password != "" {
+		args = append(args, "-p"+opts.Password)
+		switch format {
+		case "7z":
+			// Encrypt the headers too, so file names aren't readable without the password
+			args = append(args, "-mhe=on")
+		case "zip":
+			// 7z defaults to the weak ZipCrypto cipher for zip
+			args = append(args, "-mem=AES256")
+		}
 	}
+	// -spd takes the names literally rather than as wildcards, and after "--" a name starting with "-" isn't
+	// read as a switch
+	args = append(args, "-spd", "--", output)
+	args = append(args, names...)
+
+	// The names come from the user's archive, so they go to 7z unsanitized: sanitizing would drop any of
+	// ; & | $ ` and have 7z look for a file that isn't there
+	if err := p.executor.ExecuteCommandIn(sourceDir, "7z", args); err != nil {
+		return fmt.Errorf("failed to create %s file: %w", format, err)
+	}
+	return nil
+}
+
+// createTarXz tars the sources and compresses the tarball with a dictionary sized for the level;
+// archiver/v3's TarXz has no level of its own
+func (p *ArchiveProcessor) createTarXz(sources []string, output string, level int) error {
+	tarFile, err := os.CreateTemp(p.config.TempDir, "archive_*.tar")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tarFile.Close()
+	// archiver refuses to overwrite, so hand it a path that doesn't exist yet
+	os.Remove(tarFile.Name())
+	defer os.Remove(tarFile.Name())
 
-	// Create tar.gz file
-	tarGz := archiver.NewTarGz()
-	if err := tarGz.Archive([]string{tempDir}, output); err != nil {
-		return "", fmt.Errorf("failed to create tar.gz file: %w", err)
+	if err := archiver.NewTar().Archive(sources, tarFile.Name()); err != nil {
+		return fmt.Errorf("failed to create tar.xz file: %w", err)
 	}
 
-	return output, nil
+	in, err := os.Open(tarFile.Name())
+	if err != nil {
+		return fmt.Errorf("failed to create tar.xz file: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer out.Close()
+
+	xzw, err := xz.WriterConfig{DictCap: xzDictCaps[level]}.NewWriter(out)
+	if err != nil {
+		return fmt.Errorf("failed to create tar.xz file: %w", err)
+	}
+	if _, err := io.Copy(xzw, in); err != nil {
+		return fmt.Errorf("failed to create tar.xz file: %w", err)
+	}
+	if err := xzw.Close(); err != nil {
+		return fmt.Errorf("failed to create tar.xz file: %w", err)
+	}
+	return out.Close()
 }
 
-func (p *ArchiveProcessor) convertZipToZip(input, output string, job *models.ProcessingJob) (string, error) {
-	// Simple copy for same format
+// copyArchive passes a same-format conversion through unchanged
+func (p *ArchiveProcessor) copyArchive(input, output string, job *models.ProcessingJob) (string, error) {
 	inputData, err := os.ReadFile(input)
 	if err != nil {
 		return "", fmt.Errorf("failed to read input archive file: %w", err)
@@ -90,9 +240,3 @@ func (p *ArchiveProcessor) convertZipToZip(input, output string, job *models.Pro
 
 	return output, nil
 }
-
-func (p *ArchiveProcessor) createZipFromDirectory(sourceDir, zipPath string) error {
-	// Use archiver library to create ZIP from directory
-	zip := archiver.NewZip()
-	return zip.Archive([]string{sourceDir}, zipPath)
-}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/qoal/file-processor/config"
+)
+
+func TestCreateWith7zKeepsNames(t *testing.T) {
+	if _, err := exec.LookPath("7z"); err != nil {
+		t.Skip("7z not installed")
+	}
+	p := NewArchiveProcessor(&config.Config{TempDir: t.TempDir()})
+
+	source := t.TempDir()
+	names := []string{"R&D.xlsx", "-notes.txt", "a*b;c|d$e`f.txt"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(source, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(t.TempDir(), "out.7z")
+
+	if err := p.createArchive("7z", source, output, ArchiveOptions{Level: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	listing, err := p.executor.ExecuteCommandOutput("7z", []string{"l", "-slt", output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored []string
+	for _, line := range strings.Split(string(listing), "\n") {
+		if path, ok := strings.CutPrefix(strings.TrimSpace(line), "Path = "); ok && path != output {
+			stored = append(stored, path)
+		}
+	}
+	slices.Sort(stored)
+	slices.Sort(names)
+	if !slices.Equal(stored, names) {
+		t.Errorf("archive holds %q, want %q", stored, names)
+	}
+}
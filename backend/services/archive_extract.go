@@ -0,0 +1,345 @@
+package services
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archiver/v3"
+	"github.com/qoal/file-processor/utils"
+)
+
+// Extraction limit defaults; settings can tighten them but not loosen them
+const (
+	defaultMaxUncompressedSize = 2 << 30 // 2 GiB
+	defaultMaxEntries          = 10000
+	defaultMaxCompressionRatio = 100
+	defaultMaxDepth            = 32
+
+	// ratioFloor is how much an archive must expand before its ratio counts; small archives can't be bombs
+	ratioFloor = 1 << 20
+)
+
+// windowsAbsPath matches drive-letter paths, which filepath.IsAbs doesn't recognise on Linux
+var windowsAbsPath = regexp.MustCompile(`^[A-Za-z]:`)
+
+// ExtractLimits bounds what unpacking an untrusted archive may produce
+type ExtractLimits struct {
+	MaxUncompressedSize int64   // total bytes written
+	MaxEntries          int     // files and directories
+	MaxCompressionRatio float64 // uncompressed bytes per archive byte
+	MaxDepth            int     // directory levels above an entry
+}
+
+func getExtractLimits(settings map[string]interface{}) (ExtractLimits, error) {
+	limits := ExtractLimits{}
+
+	size, err := utils.GetIntSetting(settings, "max_uncompressed_size", defaultMaxUncompressedSize)
+	if err != nil {
+		return limits, err
+	}
+	if size < 1 || size > defaultMaxUncompressedSize {
+		return limits, fmt.Errorf("max_uncompressed_size must be between 1 and %d bytes, got %d", defaultMaxUncompressedSize, size)
+	}
+	limits.MaxUncompressedSize = int64(size)
+
+	if limits.MaxEntries, err = utils.GetIntSetting(settings, "max_entries", defaultMaxEntries); err != nil {
+		return limits, err
+	}
+	if limits.MaxEntries < 1 || limits.MaxEntries > defaultMaxEntries {
+		return limits, fmt.Errorf("max_entries must be between 1 and %d, got %d", defaultMaxEntries, limits.MaxEntries)
+	}
+
+	if limits.MaxCompressionRatio, err = utils.GetFloatSetting(settings, "max_compression_ratio", defaultMaxCompressionRatio); err != nil {
+		return limits, err
+	}
+	if limits.MaxCompressionRatio < 1 || limits.MaxCompressionRatio > defaultMaxCompressionRatio {
+		return limits, fmt.Errorf("max_compression_ratio must be between 1 and %d, got %g", defaultMaxCompressionRatio, limits.MaxCompressionRatio)
+	}
+
+	if limits.MaxDepth, err = utils.GetIntSetting(settings, "max_depth", defaultMaxDepth); err != nil {
+		return limits, err
+	}
+	if limits.MaxDepth < 0 || limits.MaxDepth > defaultMaxDepth {
+		return limits, fmt.Errorf("max_depth must be between 0 and %d, got %d", defaultMaxDepth, limits.MaxDepth)
+	}
+
+	return limits, nil
+}
+
+// extractGuard applies ExtractLimits entry by entry while an archive is unpacked
+type extractGuard struct {
+	limits      ExtractLimits
+	archiveSize int64
+	entries     int
+	total       int64
+}
+
+func newExtractGuard(input string, limits ExtractLimits) (*extractGuard, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat archive: %w", err)
+	}
+	return &extractGuard{limits: limits, archiveSize: max(info.Size(), 1)}, nil
+}
+
+// entry vets an entry's name and type and returns its cleaned relative path; "" means nothing to write
+func (g *extractGuard) entry(name string, mode fs.FileMode) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return "", fmt.Errorf("archive entry %q is a symlink; links are not extracted", name)
+	case mode&(fs.ModeDevice|fs.ModeCharDevice|fs.ModeNamedPipe|fs.ModeSocket) != 0:
+		return "", fmt.Errorf("archive entry %q is a special file", name)
+	case strings.HasPrefix(name, "/") || windowsAbsPath.MatchString(name):
+		return "", fmt.Errorf("archive entry %q has an absolute path", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("archive entry %q points outside the archive", name)
+		}
+	}
+
+	clean := path.Clean(name)
+	if clean == "." {
+		return "", nil
+	}
+	if depth := strings.Count(clean, "/"); depth > g.limits.MaxDepth {
+		return "", fmt.Errorf("archive entry %q is nested %d directories deep (limit %d)", name, depth, g.limits.MaxDepth)
+	}
+
+	g.entries++
+	if g.entries > g.limits.MaxEntries {
+		return "", fmt.Errorf("archive has more than %d entries", g.limits.MaxEntries)
+	}
+
+	return filepath.FromSlash(clean), nil
+}
+
+// remaining is how many more bytes may be written before the size limit trips
+func (g *extractGuard) remaining() int64 {
+	return g.limits.MaxUncompressedSize - g.total
+}
+
+// written accounts for n extracted bytes and checks the size and ratio limits
+func (g *extractGuard) written(n int64) error {
+	g.total += n
+	if g.total > g.limits.MaxUncompressedSize {
+		return fmt.Errorf("archive expands beyond %d bytes", g.limits.MaxUncompressedSize)
+	}
+	if g.total > ratioFloor {
+		if ratio := float64(g.total) / float64(g.archiveSize); ratio > g.limits.MaxCompressionRatio {
+			return fmt.Errorf("archive expands %.0f times its size (limit %g); possible archive bomb", ratio, g.limits.MaxCompressionRatio)
+		}
+	}
+	return nil
+}
+
+// safeUnarchive walks an archive and writes its entries under dest itself, so every entry passes the guard
+// before anything touches the disk and no write can grow past the size limit. A non-nil match limits the
+// files written to those whose slash-separated path it accepts.
+func safeUnarchive(walker archiver.Walker, input, dest string, guard *extractGuard, match func(string) bool) error {
+	var violation error
+	err := walker.Walk(input, func(f archiver.File) error {
+		name, mode := archiveEntry(f)
+		rel, err := guard.entry(name, mode)
+		if err != nil {
+			violation = err
+			return err
+		}
+		if rel == "" || (match != nil && (f.IsDir() || !match(filepath.ToSlash(rel)))) {
+			return nil
+		}
+
+		target := filepath.Join(dest, rel)
+		if f.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if err := writeEntry(target, f, guard); err != nil {
+			violation = err
+			return err
+		}
+		return nil
+	})
+
+	// archiver wraps walk errors in text; hand back the limit violation as is
+	if violation != nil {
+		return violation
+	}
+	return err
+}
+
+// writeEntry writes one file of an archive to target, reading one byte past what the size limit has left so an
+// oversized entry is noticed rather than truncated
+func writeEntry(target string, r io.Reader, guard *extractGuard) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(r, guard.remaining()+1))
+	out.Close()
+	if err != nil {
+		return err
+	}
+	return guard.written(n)
+}
+
+// archiveEntry returns an entry's full path and mode; archiver's FileInfo only carries the base name for zip and tar.
+// archiver reads zips with klauspost's archive/zip fork, so its header type is the one to match.
+func archiveEntry(f archiver.File) (string, fs.FileMode) {
+	mode := f.Mode()
+	switch header := f.Header.(type) {
+	case zip.FileHeader:
+		return header.Name, mode
+	case *tar.Header:
+		if header.Typeflag == tar.TypeLink {
+			// Hard links alias another entry's data; treat them like symlinks
+			mode |= fs.ModeSymlink
+		}
+		return header.Name, mode
+	default:
+		// rar reports the full path as its name
+		return f.Name(), mode
+	}
+}
+
+// listWith7z reads entries from `7z l -slt` for archives the Go readers can't open, vetting each against the guard
+func (p *ArchiveProcessor) listWith7z(input, password string, guard *extractGuard) ([]archiveItem, error) {
+	args := []string{"l", "-slt"}
+	if password != "" {
+		args = append(args, "-p"+password)
+	}
+	args = append(args, input)
+
+	output, err := p.executor.ExecuteCommandOutput("7z", args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive (wrong or missing password?): %w", err)
+	}
+	return parse7zListing(output, guard)
+}
+
+// parse7zListing reads the entries of `7z l -slt` output, vetting each against the guard
+func parse7zListing(output []byte, guard *extractGuard) ([]archiveItem, error) {
+	// Entries follow the "----------" line as blank-line separated "Key = Value" blocks
+	var items []archiveItem
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	inEntries := false
+	fields := make(map[string]string)
+	flush := func() error {
+		defer clear(fields)
+		name, exists := fields["Path"]
+		if !exists {
+			return nil
+		}
+
+		var mode fs.FileMode
+		if fields["Folder"] == "+" {
+			mode |= fs.ModeDir
+		}
+		if fields["Symbolic Link"] != "" || hasUnixSymlinkAttr(fields["Attributes"]) {
+			mode |= fs.ModeSymlink
+		}
+		rel, err := guard.entry(name, mode)
+		if err != nil || rel == "" {
+			return err
+		}
+
+		item := archiveItem{Path: filepath.ToSlash(rel), Name: name, Dir: mode.IsDir()}
+		item.Size, _ = strconv.ParseInt(fields["Size"], 10, 64)
+		// The fraction is optional when parsing, and 7z only prints it for some formats
+		item.Modified, _ = time.Parse("2006-01-02 15:04:05.999999999", fields["Modified"])
+		if crc, err := strconv.ParseUint(fields["CRC"], 16, 32); err == nil {
+			item.CRC32, item.HasCRC = uint32(crc), true
+		}
+		items = append(items, item)
+
+		return guard.written(item.Size)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !inEntries {
+			inEntries = strings.HasPrefix(line, "----------")
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if key, value, ok := strings.Cut(line, " = "); ok {
+			fields[key] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read archive listing: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// hasUnixSymlinkAttr spots the "lrwxrwxrwx" mode string 7z prints among an entry's attributes
+func hasUnixSymlinkAttr(attributes string) bool {
+	for _, field := range strings.Fields(attributes) {
+		if len(field) == 10 && field[0] == 'l' {
+			return true
+		}
+	}
+	return false
+}
+
+// extractWith7z streams the listed entries out of the 7z binary one at a time and writes them under dest
+// itself, so each lands at its vetted path and none can grow past the size limit, whatever the listing
+// claimed. A non-nil match limits the files written as in safeUnarchive.
+func (p *ArchiveProcessor) extractWith7z(format, input, dest, password string, items []archiveItem, guard *extractGuard, match func(string) bool) error {
+	for _, item := range items {
+		if match != nil && (item.Dir || !match(item.Path)) {
+			continue
+		}
+		target := filepath.Join(dest, filepath.FromSlash(item.Path))
+		if item.Dir {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// -so writes the entry to stdout, and -spd matches its name literally rather than as a wildcard
+		args := []string{"e", "-so", "-spd"}
+		if password != "" {
+			args = append(args, "-p"+password)
+		}
+		args = append(args, "--", input, item.Name)
+
+		var violation error
+		err := p.executor.StreamCommandOutput("7z", args, func(r io.Reader) error {
+			violation = writeEntry(target, r, guard)
+			return violation
+		})
+		if violation != nil {
+			return violation
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %s file (wrong or missing password?): %w", format, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testGuard(limits ExtractLimits, archiveSize int64) *extractGuard {
+	return &extractGuard{limits: limits, archiveSize: archiveSize}
+}
+
+var defaultLimits = ExtractLimits{
+	MaxUncompressedSize: defaultMaxUncompressedSize,
+	MaxEntries:          defaultMaxEntries,
+	MaxCompressionRatio: defaultMaxCompressionRatio,
+	MaxDepth:            defaultMaxDepth,
+}
+
+func TestGetExtractLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     ExtractLimits
+		wantErr  bool
+	}{
+		{"defaults", nil, defaultLimits, false},
+		{"tightened", map[string]interface{}{"max_uncompressed_size": 1024, "max_entries": 5, "max_compression_ratio": 2.5, "max_depth": 0},
+			ExtractLimits{MaxUncompressedSize: 1024, MaxEntries: 5, MaxCompressionRatio: 2.5, MaxDepth: 0}, false},
+		{"size above default", map[string]interface{}{"max_uncompressed_size": defaultMaxUncompressedSize + 1}, ExtractLimits{}, true},
+		{"zero size", map[string]interface{}{"max_uncompressed_size": 0}, ExtractLimits{}, true},
+		{"entries above default", map[string]interface{}{"max_entries": defaultMaxEntries + 1}, ExtractLimits{}, true},
+		{"ratio below one", map[string]interface{}{"max_compression_ratio": 0.5}, ExtractLimits{}, true},
+		{"ratio above default", map[string]interface{}{"max_compression_ratio": 1000}, ExtractLimits{}, true},
+		{"negative depth", map[string]interface{}{"max_depth": -1}, ExtractLimits{}, true},
+		{"wrong type", map[string]interface{}{"max_entries": "many"}, ExtractLimits{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getExtractLimits(tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getExtractLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("getExtractLimits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractGuardEntry(t *testing.T) {
+	limits := defaultLimits
+	limits.MaxDepth = 2
+
+	tests := []struct {
+		name    string
+		entry   string
+		mode    fs.FileMode
+		want    string
+		wantErr bool
+	}{
+		{"plain file", "docs/readme.txt", 0644, filepath.FromSlash("docs/readme.txt"), false},
+		{"backslashes", `docs\readme.txt`, 0644, filepath.FromSlash("docs/readme.txt"), false},
+		{"redundant parts", "./docs//readme.txt", 0644, filepath.FromSlash("docs/readme.txt"), false},
+		{"root", "./", fs.ModeDir, "", false},
+		{"parent traversal", "../etc/passwd", 0644, "", true},
+		{"nested traversal", "docs/../../etc/passwd", 0644, "", true},
+		{"windows traversal", `docs\..\..\boot.ini`, 0644, "", true},
+		{"absolute path", "/etc/passwd", 0644, "", true},
+		{"drive letter", `C:\Windows\win.ini`, 0644, "", true},
+		{"symlink", "link", fs.ModeSymlink | 0777, "", true},
+		{"device", "dev/sda", fs.ModeDevice, "", true},
+		{"named pipe", "fifo", fs.ModeNamedPipe, "", true},
+		{"at depth limit", "a/b/file", 0644, filepath.FromSlash("a/b/file"), false},
+		{"beyond depth limit", "a/b/c/file", 0644, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testGuard(limits, 1).entry(tt.entry, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("entry(%q) error = %v, wantErr %v", tt.entry, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("entry(%q) = %q, want %q", tt.entry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractGuardEntryCount(t *testing.T) {
+	limits := defaultLimits
+	limits.MaxEntries = 2
+	guard := testGuard(limits, 1)
+
+	for _, name := range []string{".", "a", "b"} {
+		if _, err := guard.entry(name, 0644); err != nil {
+			t.Fatalf("entry(%q): %v", name, err)
+		}
+	}
+	if _, err := guard.entry("c", 0644); err == nil {
+		t.Error("third entry allowed past a limit of 2")
+	}
+}
+
+func TestExtractGuardWritten(t *testing.T) {
+	tests := []struct {
+		name        string
+		limits      ExtractLimits
+		archiveSize int64
+		writes      []int64
+		wantErr     bool
+	}{
+		{"within size", ExtractLimits{MaxUncompressedSize: 100, MaxCompressionRatio: 100}, 1, []int64{60, 40}, false},
+		{"beyond size", ExtractLimits{MaxUncompressedSize: 100, MaxCompressionRatio: 100}, 1, []int64{60, 41}, true},
+		{"high ratio below the floor", ExtractLimits{MaxUncompressedSize: 1 << 30, MaxCompressionRatio: 10}, 1, []int64{ratioFloor}, false},
+		{"high ratio above the floor", ExtractLimits{MaxUncompressedSize: 1 << 30, MaxCompressionRatio: 10}, 1 << 10, []int64{ratioFloor, 1}, true},
+		{"modest ratio above the floor", ExtractLimits{MaxUncompressedSize: 1 << 30, MaxCompressionRatio: 10}, 1 << 20, []int64{4 << 20}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := testGuard(tt.limits, tt.archiveSize)
+			var err error
+			for _, n := range tt.writes {
+				if err = guard.written(n); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("written() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteEntryStopsAtSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	guard := testGuard(ExtractLimits{MaxUncompressedSize: 10, MaxCompressionRatio: 100}, 1)
+
+	if err := writeEntry(filepath.Join(dir, "a", "first"), strings.NewReader("123456"), guard); err != nil {
+		t.Fatalf("first entry: %v", err)
+	}
+	if err := writeEntry(filepath.Join(dir, "second"), strings.NewReader(strings.Repeat("x", 1<<20)), guard); err == nil {
+		t.Fatal("entry past the size limit was written")
+	}
+
+	// Only one byte past the limit is ever read, whatever the entry's real size
+	info, err := os.Stat(filepath.Join(dir, "second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 5 {
+		t.Errorf("wrote %d bytes of the oversized entry, want 5", info.Size())
+	}
+}
+
+const sevenZipListing = `
+7-Zip [64] 16.02 : Copyright (c) 1999-2016 Igor Pavlov : 2016-05-21
+
+Scanning the drive for archives:
+1 file, 512 bytes (1 KiB)
+
+Listing archive: test.7z
+
+--
+Path = test.7z
+Type = 7z
+Physical Size = 512
+
+----------
+Path = docs
+Size = 0
+Modified = 2024-03-01 10:00:00
+Attributes = D_ drwxr-xr-x
+CRC = 
+Folder = +
+
+Path = docs/readme & notes.txt
+Size = 1200
+Modified = 2024-03-01 10:00:01.5
+Attributes = A_ -rw-r--r--
+CRC = 3610A686
+Encrypted = +
+Folder = -
+
+Path = docs\report.pdf
+Size = 3000
+Modified = 2024-03-01 10:00:02
+Attributes = A_ -rw-r--r--
+CRC = 0000ABCD
+Folder = -
+`
+
+func TestParse7zListing(t *testing.T) {
+	items, err := parse7zListing([]byte(sevenZipListing), testGuard(defaultLimits, 512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("got %d items, want 3: %+v", len(items), items)
+	}
+
+	if !items[0].Dir || items[0].Path != "docs" {
+		t.Errorf("first item = %+v, want the docs directory", items[0])
+	}
+	file := items[1]
+	if file.Path != "docs/readme & notes.txt" || file.Name != "docs/readme & notes.txt" || file.Dir || file.Size != 1200 {
+		t.Errorf("second item = %+v", file)
+	}
+	if !file.HasCRC || file.CRC32 != 0x3610A686 {
+		t.Errorf("second item CRC = %x (%v)", file.CRC32, file.HasCRC)
+	}
+	if file.Modified.Nanosecond() != 500000000 {
+		t.Errorf("second item modified = %v", file.Modified)
+	}
+	// The path is normalized, but 7z selects the entry by its name as stored
+	if items[2].Path != "docs/report.pdf" || items[2].Name != `docs\report.pdf` {
+		t.Errorf("third item = %+v", items[2])
+	}
+}
+
+func TestParse7zListingRejects(t *testing.T) {
+	entry := func(fields string) string {
+		return "----------\n" + fields + "\n"
+	}
+
+	tests := []struct {
+		name    string
+		listing string
+		limits  ExtractLimits
+	}{
+		{"traversal", entry("Path = ../../etc/cron.d/job\nSize = 10\nFolder = -"), defaultLimits},
+		{"absolute path", entry("Path = /etc/passwd\nSize = 10\nFolder = -"), defaultLimits},
+		{"symlink field", entry("Path = link\nSize = 10\nSymbolic Link = /etc/passwd\nFolder = -"), defaultLimits},
+		{"unix symlink attributes", entry("Path = link\nSize = 10\nAttributes = A_ lrwxrwxrwx\nFolder = -"), defaultLimits},
+		{"declared size over the limit", entry("Path = big\nSize = 2000\nFolder = -"), ExtractLimits{MaxUncompressedSize: 1000, MaxEntries: 10, MaxCompressionRatio: 100}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parse7zListing([]byte(tt.listing), testGuard(tt.limits, 1)); err == nil {
+				t.Error("listing accepted")
+			}
+		})
+	}
+}
+
+func TestHasUnixSymlinkAttr(t *testing.T) {
+	tests := []struct {
+		attributes string
+		want       bool
+	}{
+		{"A_ lrwxrwxrwx", true},
+		{"A_ -rw-r--r--", false},
+		{"D_ drwxr-xr-x", false},
+		{"A", false},
+		{"", false},
+		{"A_ lrw", false},
+	}
+
+	for _, tt := range tests {
+		if got := hasUnixSymlinkAttr(tt.attributes); got != tt.want {
+			t.Errorf("hasUnixSymlinkAttr(%q) = %v, want %v", tt.attributes, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archiver/v3"
+	"github.com/qoal/file-processor/models"
+	"github.com/qoal/file-processor/utils"
+)
+
+// archiveItem is one entry of an archive as the list and extract jobs see it
+type archiveItem struct {
+	Path     string // slash-separated and relative to the archive root
+	Name     string // as stored in the archive; set for entries listed by the 7z binary, which selects them by it
+	Dir      bool
+	Size     int64
+	Modified time.Time
+	CRC32    uint32
+	HasCRC   bool
+}
+
+// ListArchive records an archive's entry tree on the job and writes the same tree as its JSON output
+func (p *ArchiveProcessor) ListArchive(job *models.ProcessingJob) error {
+	job.Status = "processing"
+	job.Progress = 10
+
+	source := utils.NormalizeArchiveFormat(job.SourceFormat)
+	if !readableArchives[source] {
+		return fmt.Errorf("archive listing failed: unsupported archive format: %s", job.SourceFormat)
+	}
+	opts, err := p.getArchiveOptions(job.Settings)
+	if err != nil {
+		return fmt.Errorf("archive listing failed: %w", err)
+	}
+
+	items, err := p.inspectArchive(source, job.InputPath, opts)
+	if err != nil {
+		return fmt.Errorf("archive listing failed: %w", err)
+	}
+	listing := buildArchiveListing(source, items)
+
+	job.Progress = 80
+
+	os.MkdirAll(p.config.OutputDir, 0755)
+	outputFile := filepath.Join(p.config.OutputDir, job.JobID+"_output.json")
+	data, err := json.MarshalIndent(listing, "", "  ")
+	if err != nil {
+		return fmt.Errorf("archive listing failed: %w", err)
+	}
+	if err := os.WriteFile(outputFile, data, 0644); err != nil {
+		return fmt.Errorf("archive listing failed: failed to write output file: %w", err)
+	}
+
+	job.OutputPath = outputFile
+	job.Listing = listing
+	job.Status = "completed"
+	job.Progress = 100
+
+	return nil
+}
+
+// inspectArchive reads every entry's metadata within the extraction limits, without writing anything to disk
+func (p *ArchiveProcessor) inspectArchive(format, input string, opts ArchiveOptions) ([]archiveItem, error) {
+	guard, err := newExtractGuard(input, opts.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "7z" || opts.InputPassword != "" {
+		return p.listWith7z(input, opts.InputPassword, guard)
+	}
+
+	walker, ok := newArchiver(format, 0).(archiver.Walker)
+	if !ok {
+		return nil, fmt.Errorf("cannot list %s archives", format)
+	}
+
+	var items []archiveItem
+	var violation error
+	err = walker.Walk(input, func(f archiver.File) error {
+		name, mode := archiveEntry(f)
+		rel, err := guard.entry(name, mode)
+		if err != nil {
+			violation = err
+			return err
+		}
+		if rel == "" {
+			return nil
+		}
+
+		item := archiveItem{Path: filepath.ToSlash(rel), Dir: f.IsDir(), Size: f.Size(), Modified: f.ModTime()}
+		if header, isZip := f.Header.(zip.FileHeader); isZip {
+			item.CRC32, item.HasCRC = header.CRC32, !item.Dir
+		} else if !item.Dir {
+			// tar and rar keep no usable checksum, so hash the data as it streams past
+			hash := crc32.NewIEEE()
+			n, err := io.Copy(hash, io.LimitReader(f, guard.remaining()+1))
+			if err != nil {
+				return err
+			}
+			item.Size, item.CRC32, item.HasCRC = n, hash.Sum32(), true
+		}
+
+		if err := guard.written(item.Size); err != nil {
+			violation = err
+			return err
+		}
+		items = append(items, item)
+		return nil
+	})
+	if violation != nil {
+		return nil, violation
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s file: %w", format, err)
+	}
+
+	return items, nil
+}
+
+// buildArchiveListing nests flat entries into a tree, adding directories that only appear in file paths
+func buildArchiveListing(format string, items []archiveItem) *models.ArchiveListing {
+	listing := &models.ArchiveListing{Format: format}
+	root := &models.ArchiveEntry{Dir: true}
+	dirs := map[string]*models.ArchiveEntry{".": root}
+
+	var ensureDir func(dir string) *models.ArchiveEntry
+	ensureDir = func(dir string) *models.ArchiveEntry {
+		if node, exists := dirs[dir]; exists {
+			return node
+		}
+		parent := ensureDir(path.Dir(dir))
+		node := &models.ArchiveEntry{Name: path.Base(dir), Path: dir, Dir: true}
+		parent.Children = append(parent.Children, node)
+		dirs[dir] = node
+		listing.Dirs++
+		return node
+	}
+
+	for _, item := range items {
+		var node *models.ArchiveEntry
+		if item.Dir {
+			node = ensureDir(item.Path)
+		} else {
+			node = &models.ArchiveEntry{Name: path.Base(item.Path), Path: item.Path, Size: item.Size}
+			parent := ensureDir(path.Dir(item.Path))
+			parent.Children = append(parent.Children, node)
+			listing.Files++
+			listing.TotalSize += item.Size
+		}
+		if !item.Modified.IsZero() {
+			modified := item.Modified.UTC()
+			node.Modified = &modified
+		}
+		if item.HasCRC {
+			node.CRC32 = fmt.Sprintf("%08X", item.CRC32)
+		}
+	}
+
+	sortArchiveEntries(root.Children)
+	listing.Tree = root.Children
+	if listing.Tree == nil {
+		listing.Tree = []*models.ArchiveEntry{}
+	}
+	return listing
+}
+
+// sortArchiveEntries orders each level directories first, then by name
+func sortArchiveEntries(entries []*models.ArchiveEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Dir != entries[j].Dir {
+			return entries[i].Dir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	for _, entry := range entries {
+		sortArchiveEntries(entry.Children)
+	}
+}
+
+// ExtractEntries pulls the entries matching the "patterns" globs out of an archive. A single match is
+// delivered as the file itself, in its own format; several are zipped with their paths kept.
+func (p *ArchiveProcessor) ExtractEntries(job *models.ProcessingJob) error {
+	job.Status = "processing"
+	job.Progress = 10
+
+	source := utils.NormalizeArchiveFormat(job.SourceFormat)
+	if !readableArchives[source] {
+		return fmt.Errorf("archive extraction failed: unsupported archive format: %s", job.SourceFormat)
+	}
+	opts, err := p.getArchiveOptions(job.Settings)
+	if err != nil {
+		return fmt.Errorf("archive extraction failed: %w", err)
+	}
+	match, err := getEntryMatcher(job.Settings)
+	if err != nil {
+		return fmt.Errorf("archive extraction failed: %w", err)
+	}
+	singleFile, err := utils.GetBoolSetting(job.Settings, "single_file", true)
+	if err != nil {
+		return fmt.Errorf("archive extraction failed: %w", err)
+	}
+
+	outputFile, format, err := p.extractMatching(source, job.InputPath, match, singleFile, opts, job)
+	if err != nil {
+		return fmt.Errorf("archive extraction failed: %w", err)
+	}
+
+	job.OutputPath = outputFile
+	job.TargetFormat = format
+	job.Status = "completed"
+	job.Progress = 100
+
+	return nil
+}
+
+// getEntryMatcher compiles the "patterns" setting. Patterns without a "/" match an entry's name at any
+// depth; patterns with one match its whole path.
+func getEntryMatcher(settings map[string]interface{}) (func(string) bool, error) {
+	patterns, err := utils.GetStringSliceSetting(settings, "patterns")
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("patterns must list at least one glob")
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	return func(entry string) bool {
+		for _, pattern := range patterns {
+			target := entry
+			if !strings.Contains(pattern, "/") {
+				target = path.Base(entry)
+			}
+			if matched, _ := path.Match(pattern, target); matched {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// extractMatching unpacks the matching entries and returns the output file and its format
+func (p *ArchiveProcessor) extractMatching(source, input string, match func(string) bool, singleFile bool, opts ArchiveOptions, job *models.ProcessingJob) (string, string, error) {
+	tempDir, err := os.MkdirTemp(p.config.TempDir, "archive_extract_")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	extractDir := filepath.Join(tempDir, "extracted")
+	if err := p.extractArchive(source, input, extractDir, opts.InputPassword, opts.Limits, match); err != nil {
+		return "", "", err
+	}
+
+	job.Progress = 50
+
+	// Only matches were extracted, but their paths are picked up from what landed on disk
+	var matches []string
+	err = filepath.WalkDir(extractDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(extractDir, name)
+		if err != nil {
+			return err
+		}
+		if match(filepath.ToSlash(rel)) {
+			matches = append(matches, rel)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", fmt.Errorf("failed to collect extracted files: %w", err)
+	}
+	if len(matches) == 0 {
+		return "", "", fmt.Errorf("no archive entries match the patterns")
+	}
+
+	os.MkdirAll(p.config.OutputDir, 0755)
+
+	if len(matches) == 1 && singleFile {
+		ext := strings.ToLower(filepath.Ext(matches[0]))
+		format := strings.TrimPrefix(ext, ".")
+		if format == "" {
+			format = "bin"
+			ext = ".bin"
+		}
+		outputFile := filepath.Join(p.config.OutputDir, job.JobID+"_output"+ext)
+		if err := moveFile(filepath.Join(extractDir, matches[0]), outputFile); err != nil {
+			return "", "", fmt.Errorf("failed to move extracted file: %w", err)
+		}
+		return outputFile, format, nil
+	}
+
+	// Gather the matches, paths intact, so the zip holds exactly them
+	stageDir := filepath.Join(tempDir, "matched")
+	for _, rel := range matches {
+		target := filepath.Join(stageDir, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return "", "", fmt.Errorf("failed to stage extracted files: %w", err)
+		}
+		if err := os.Rename(filepath.Join(extractDir, rel), target); err != nil {
+			return "", "", fmt.Errorf("failed to stage extracted files: %w", err)
+		}
+	}
+
+	outputFile := filepath.Join(p.config.OutputDir, job.JobID+"_output.zip")
+	if err := p.createArchive("zip", stageDir, outputFile, opts); err != nil {
+		return "", "", err
+	}
+	return outputFile, "zip", nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/qoal/file-processor/config"
 	"github.com/qoal/file-processor/models"
@@ -14,12 +15,14 @@ import (
 )
 
 type ArchiveProcessor struct {
-	config *config.Config
+	config   *config.Config
+	executor *utils.SecureCommandExecutor
 }
 
 func NewArchiveProcessor(cfg *config.Config) *ArchiveProcessor {
 	return &ArchiveProcessor{
-		config: cfg,
+		config:   cfg,
+		executor: utils.NewSecureCommandExecutor(10 * time.Minute),
 	}
 }
 
@@ -61,20 +64,55 @@ func (p *ArchiveProcessor) executeArchiveConversion(inputFile string, job *model
 	outputFile := filepath.Join(p.config.OutputDir,
 		job.JobID+"_output"+ext)
 
-	conversionType := strings.ToUpper(job.SourceFormat) + "_TO_" + strings.ToUpper(job.TargetFormat)
-
-	switch conversionType {
-	case "RAR_TO_ZIP":
-		return p.convertRarToZip(inputFile, outputFile, job)
-	case "TAR_GZ_TO_ZIP":
-		return p.convertTarGzToZip(inputFile, outputFile, job)
-	case "ZIP_TO_TAR_GZ":
-		return p.convertZipToTarGz(inputFile, outputFile, job)
-	case "ZIP_TO_ZIP":
-		return p.convertZipToZip(inputFile, outputFile, job)
-	default:
+	// Formats arrive in several spellings ("tgz", "tar_gz", "tar.gz"); compare canonical names
+	source := utils.NormalizeArchiveFormat(job.SourceFormat)
+	target := utils.NormalizeArchiveFormat(job.TargetFormat)
+	conversionType := strings.ToUpper(source) + "_TO_" + strings.ToUpper(target)
+
+	opts, err := p.getArchiveOptions(job.Settings)
+	if err != nil {
+		return "", err
+	}
+	_, levelSet := job.Settings["compression_level"]
+	repack := levelSet || opts.Password != "" || opts.InputPassword != ""
+
+	switch {
+	case !readableArchives[source]:
 		return "", fmt.Errorf("unsupported archive conversion: %s", conversionType)
+	case !writableArchives[target]:
+		return "", fmt.Errorf("unsupported archive conversion: %s (%s can only be a source)", conversionType, target)
+	case source == target && !repack:
+		return p.copyArchive(inputFile, outputFile, job)
+	default:
+		return p.convertArchive(inputFile, outputFile, source, target, opts, job)
+	}
+}
+
+// getArchiveOptions reads the compression level, the output and input passwords and the extraction limits
+func (p *ArchiveProcessor) getArchiveOptions(settings map[string]interface{}) (ArchiveOptions, error) {
+	opts := ArchiveOptions{Level: p.getCompressionLevel(settings)}
+
+	var err error
+	if opts.Limits, err = getExtractLimits(settings); err != nil {
+		return opts, err
+	}
+	if opts.Password, err = utils.GetStringSetting(settings, "password", ""); err != nil {
+		return opts, err
 	}
+	if opts.InputPassword, err = utils.GetStringSetting(settings, "input_password", ""); err != nil {
+		return opts, err
+	}
+
+	// Passwords reach 7z as -p arguments, which other processes on the worker host can read while it runs;
+	// 7z only prompts for them on a terminal. The command executor strips these characters from arguments,
+	// which would silently change the password.
+	for _, password := range []string{opts.Password, opts.InputPassword} {
+		if strings.ContainsAny(password, ";&|$`") {
+			return opts, fmt.Errorf("passwords can't contain any of ; & | $ ` because they are removed from the arguments passed to 7z, which would change the password")
+		}
+	}
+
+	return opts, nil
 }
 
 func (p *ArchiveProcessor) GetCompressionLevel(settings map[string]interface{}) int {
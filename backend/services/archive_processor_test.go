@@ -0,0 +1,48 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/qoal/file-processor/config"
+)
+
+func TestGetArchiveOptions(t *testing.T) {
+	p := NewArchiveProcessor(&config.Config{})
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     ArchiveOptions
+		wantErr  string
+	}{
+		{"defaults", nil, ArchiveOptions{Level: 5, Limits: defaultLimits}, ""},
+		{"level and passwords", map[string]interface{}{"compression_level": "ultra", "password": "s3cret!#%", "input_password": "open sesame"},
+			ArchiveOptions{Level: 9, Password: "s3cret!#%", InputPassword: "open sesame", Limits: defaultLimits}, ""},
+		{"unknown level", map[string]interface{}{"compression_level": "extreme"}, ArchiveOptions{Level: 5, Limits: defaultLimits}, ""},
+		{"password with semicolon", map[string]interface{}{"password": "a;b"}, ArchiveOptions{}, "removed from the arguments"},
+		{"input password with dollar", map[string]interface{}{"input_password": "$HOME"}, ArchiveOptions{}, "removed from the arguments"},
+		{"password with backtick", map[string]interface{}{"password": "`id`"}, ArchiveOptions{}, "removed from the arguments"},
+		{"password with ampersand and pipe", map[string]interface{}{"password": "a&b|c"}, ArchiveOptions{}, "removed from the arguments"},
+		{"password not a string", map[string]interface{}{"password": 1234}, ArchiveOptions{}, "password"},
+		{"bad limit", map[string]interface{}{"max_entries": 0}, ArchiveOptions{}, "max_entries"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.getArchiveOptions(tt.settings)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("getArchiveOptions() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("getArchiveOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
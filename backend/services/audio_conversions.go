@@ -1,210 +1,252 @@
 package services
 
 import (
-	"encoding/binary"
+	"encoding/json"
 	"fmt"
-	"io"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
 
-	"github.com/go-audio/wav"
-	"github.com/hajimehoshi/go-mp3"
 	"github.com/qoal/file-processor/models"
+	"github.com/qoal/file-processor/utils"
 )
 
-// Basic WAV header structure
-type WavHeader struct {
-	ChunkID       [4]byte
-	ChunkSize     uint32
-	Format        [4]byte
-	Subchunk1ID   [4]byte
-	Subchunk1Size uint32
-	AudioFormat   uint16
-	NumChannels   uint16
-	SampleRate    uint32
-	ByteRate      uint32
-	BlockAlign    uint16
-	BitsPerSample uint16
-	Subchunk2ID   [4]byte
-	Subchunk2Size uint32
+// AudioCodecProfile describes the encoder and limits ffmpeg should use for a target format
+type AudioCodecProfile struct {
+	Codec         string
+	Lossless      bool
+	MaxChannels   int   // 0 means no limit
+	SampleRates   []int // the only rates the encoder takes; nil allows any up to MaxSampleRate
+	MaxSampleRate int
+	ExtraArgs     []string
 }
 
-func (p *EnhancedAudioProcessor) convertMP3toWAV(input, output string, job *models.ProcessingJob) (string, error) {
-	// Open MP3 file
-	file, err := os.Open(input)
-	if err != nil {
-		return "", fmt.Errorf("failed to open MP3 file: %w", err)
-	}
-	defer file.Close()
-
-	// Decode MP3
-	decoder, err := mp3.NewDecoder(file)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode MP3: %w", err)
-	}
+// MP3 and AAC only define a fixed set of sample rates
+var (
+	mp3SampleRates = []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000}
+	aacSampleRates = []int{7350, 8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000, 64000, 88200, 96000}
+)
 
-	// Get audio properties
-	sampleRate := decoder.SampleRate()
-	channels := 2 // Assume stereo for now
-	bitDepth := 16
+// audioCodecProfiles maps each output container to the codec it carries
+var audioCodecProfiles = map[string]AudioCodecProfile{
+	"mp3": {
+		Codec: "libmp3lame", MaxChannels: 2, SampleRates: mp3SampleRates,
+		ExtraArgs: []string{"-id3v2_version", "3", "-write_id3v1", "1"},
+	},
+	"wav":  {Codec: "pcm_s16le", Lossless: true, MaxSampleRate: 384000},
+	"flac": {Codec: "flac", Lossless: true, MaxChannels: 8, MaxSampleRate: 655350},
+	"aac":  {Codec: "aac", MaxChannels: 8, SampleRates: aacSampleRates, ExtraArgs: []string{"-f", "adts"}},
+	"m4a":  {Codec: "aac", MaxChannels: 8, SampleRates: aacSampleRates, ExtraArgs: []string{"-movflags", "+faststart"}},
+	"ogg":  {Codec: "libvorbis", MaxChannels: 8, MaxSampleRate: 192000},
+	"wma":  {Codec: "wmav2", MaxChannels: 2, MaxSampleRate: 48000},
+}
 
-	// Create output WAV file
-	out, err := os.Create(output)
-	if err != nil {
-		return "", fmt.Errorf("failed to create output file: %w", err)
+// GetAudioCodecProfile returns the codec profile for a target audio format
+func GetAudioCodecProfile(targetFormat string) (AudioCodecProfile, error) {
+	profile, exists := audioCodecProfiles[strings.ToLower(targetFormat)]
+	if !exists {
+		return AudioCodecProfile{}, fmt.Errorf("no codec profile for audio format: %s", targetFormat)
 	}
-	defer out.Close()
+	return profile, nil
+}
 
-	// Create WAV encoder
-	enc := wav.NewEncoder(out, sampleRate, bitDepth, channels, 1)
+// AudioStreamInfo is the layout of the first audio stream in a file
+type AudioStreamInfo struct {
+	Codec         string
+	Channels      int
+	ChannelLayout string
+	SampleRate    int
+	BitDepth      int
+}
 
-	// Read and convert audio data
-	samples := make([][]int, channels)
-	for i := range samples {
-		samples[i] = make([]int, 0)
+// probeAudio reads the channel layout, sample rate and bit depth of the first audio stream
+func (p *EnhancedAudioProcessor) probeAudio(input string) (*AudioStreamInfo, error) {
+	args := []string{
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=codec_name,channels,channel_layout,sample_rate,bits_per_sample,bits_per_raw_sample",
+		"-of", "json",
+		input,
 	}
 
-	// Read all samples
-	for {
-		// Read samples from MP3
-		var tmp [2][512]float32
-		tmpBytes := make([]byte, 512*4) // 4 bytes per float32
-		n, err := decoder.Read(tmpBytes)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return "", fmt.Errorf("failed to read MP3 samples: %w", err)
-		}
+	output, err := p.executor.ExecuteCommandOutput("ffprobe", args)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
 
-		// Convert bytes to float32 samples (simplified)
-		samplesRead := n / 4 // 4 bytes per float32
-		for i := 0; i < samplesRead && i < 512; i++ {
-			for ch := 0; ch < channels; ch++ {
-				// Simplified conversion - in reality you'd properly decode the float32
-				sample := int(tmp[ch][i] * 32767)
-				if sample > 32767 {
-					sample = 32767
-				} else if sample < -32768 {
-					sample = -32768
-				}
-				samples[ch] = append(samples[ch], sample)
-			}
-		}
+	var probe struct {
+		Streams []struct {
+			CodecName        string `json:"codec_name"`
+			Channels         int    `json:"channels"`
+			ChannelLayout    string `json:"channel_layout"`
+			SampleRate       string `json:"sample_rate"`
+			BitsPerSample    int    `json:"bits_per_sample"`
+			BitsPerRawSample string `json:"bits_per_raw_sample"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	if len(probe.Streams) == 0 {
+		return nil, fmt.Errorf("no audio stream found in input")
 	}
 
-	// Write samples to WAV
-	for i := 0; i < len(samples[0]); i++ {
-		for ch := 0; ch < channels; ch++ {
-			if err := enc.WriteFrame(samples[ch][i]); err != nil {
-				return "", fmt.Errorf("failed to write WAV frame: %w", err)
-			}
-		}
+	stream := probe.Streams[0]
+	info := &AudioStreamInfo{
+		Codec:         stream.CodecName,
+		Channels:      stream.Channels,
+		ChannelLayout: stream.ChannelLayout,
 	}
+	info.SampleRate, _ = strconv.Atoi(stream.SampleRate)
 
-	// Close encoder to finalize WAV file
-	if err := enc.Close(); err != nil {
-		return "", fmt.Errorf("failed to close WAV encoder: %w", err)
+	// Lossless codecs report depth in one of two fields; lossy codecs report neither
+	info.BitDepth = stream.BitsPerSample
+	if raw, err := strconv.Atoi(stream.BitsPerRawSample); err == nil && raw > 0 {
+		info.BitDepth = raw
 	}
 
-	return output, nil
+	return info, nil
 }
 
-func (p *EnhancedAudioProcessor) convertWAVtoMP3(input, output string, job *models.ProcessingJob) (string, error) {
-	// Open WAV file
-	file, err := os.Open(input)
+// transcodeAudio converts input into the job's target format, keeping the source channel layout
+func (p *EnhancedAudioProcessor) transcodeAudio(input, output string, job *models.ProcessingJob) (string, error) {
+	profile, err := GetAudioCodecProfile(job.TargetFormat)
 	if err != nil {
-		return "", fmt.Errorf("failed to open WAV file: %w", err)
+		return "", err
 	}
-	defer file.Close()
 
-	// Decode WAV
-	decoder := wav.NewDecoder(file)
-	if !decoder.IsValidFile() {
-		return "", fmt.Errorf("invalid WAV file")
+	info, err := p.probeAudio(input)
+	if err != nil {
+		return "", err
 	}
 
-	// For now, we'll create a placeholder MP3 file
-	// Real MP3 encoding would require a complex encoder
-	// This creates a valid file structure but with basic audio data
-	out, err := os.Create(output)
+	args, err := p.buildTranscodeArgs(input, output, profile, info, job.Settings)
 	if err != nil {
-		return "", fmt.Errorf("failed to create output file: %w", err)
+		return "", err
 	}
-	defer out.Close()
 
-	// Read WAV samples
-	samples, err := decoder.FullPCMBuffer()
-	if err != nil {
-		// Fallback to simple copy if full buffer fails
-		return p.copyAudioFile(input, output)
+	if err := p.executor.ExecuteCommand("ffmpeg", args); err != nil {
+		return "", fmt.Errorf("ffmpeg audio transcode failed: %w", err)
 	}
 
-	// For this implementation, we'll create a basic MP3-like file
-	// Note: This is not a real MP3 encoding, just a placeholder
-	// In production, you'd use a proper MP3 encoder like LAME
+	return output, nil
+}
 
-	// Write basic MP3 header (simplified)
-	mp3Header := []byte{
-		0xFF, 0xFB, 0x90, 0x00, // MPEG1 Layer 3, 44.1kHz, 128kbps
-	}
+// buildTranscodeArgs assembles the ffmpeg argument list from the profile, source layout and settings
+func (p *EnhancedAudioProcessor) buildTranscodeArgs(input, output string, profile AudioCodecProfile, info *AudioStreamInfo, settings map[string]interface{}) ([]string, error) {
+	quality := p.getAudioQualityPreset(settings)
 
-	if _, err := out.Write(mp3Header); err != nil {
-		return "", fmt.Errorf("failed to write MP3 header: %w", err)
+	channels, err := utils.GetIntSetting(settings, "channels", info.Channels)
+	if err != nil {
+		return nil, err
 	}
-
-	// Write audio data (simplified - just basic PCM data)
-	// Real MP3 would use psychoacoustic modeling and Huffman encoding
-	for i := 0; i < len(samples.Data); i += samples.Format.NumChannels {
-		for ch := 0; ch < samples.Format.NumChannels; ch++ {
-			if i+ch < len(samples.Data) {
-				sample := samples.Data[i+ch]
-				// Convert to 16-bit and write (simplified)
-				val := int16(sample * 32767)
-				if err := binary.Write(out, binary.LittleEndian, val); err != nil {
-					return "", fmt.Errorf("failed to write audio data: %w", err)
-				}
-			}
+	if channels < 1 {
+		return nil, fmt.Errorf("invalid channel count: %d", channels)
+	}
+	if profile.MaxChannels > 0 && channels > profile.MaxChannels {
+		if settings["channels"] != nil {
+			return nil, fmt.Errorf("channels must be at most %d for %s, got %d", profile.MaxChannels, profile.Codec, channels)
 		}
+		// A source with more channels than the container holds gets a downmix rather than a failed encode
+		channels = profile.MaxChannels
 	}
 
-	return output, nil
-}
+	// Lossless targets keep the source rate; lossy targets follow the quality preset
+	defaultRate := quality.SampleRate
+	if profile.Lossless && info.SampleRate > 0 {
+		defaultRate = info.SampleRate
+	}
+	sampleRate, err := utils.GetIntSetting(settings, "sample_rate", defaultRate)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSampleRate(profile, sampleRate); err != nil {
+		return nil, err
+	}
+	if !profile.Lossless && settings["bit_depth"] != nil {
+		return nil, fmt.Errorf("bit_depth only applies to lossless output (wav or flac), not %s", profile.Codec)
+	}
 
-func (p *EnhancedAudioProcessor) convertFLACtoMP3(input, output string, job *models.ProcessingJob) (string, error) {
-	// For now, just copy the file with MP3 extension
-	// Real implementation would require FLAC decoding and MP3 encoding
-	return p.copyAudioFile(input, output)
-}
+	args := []string{
+		"-hide_banner", "-nostdin", "-loglevel", "error", "-y",
+		"-i", input,
+		"-map", "0:a:0",
+		// Keep container and stream tags (title, artist, album...) from the source
+		"-map_metadata", "0",
+		"-map_metadata:s:a", "0:s:a",
+		"-ar", strconv.Itoa(sampleRate),
+	}
 
-func (p *EnhancedAudioProcessor) convertM4AtoMP3(input, output string, job *models.ProcessingJob) (string, error) {
-	// For now, just copy the file with MP3 extension
-	// Real implementation would require M4A decoding and MP3 encoding
-	return p.copyAudioFile(input, output)
-}
+	// Only pass -ac when the layout changes so ffmpeg keeps e.g. 5.1(side) intact
+	if channels != info.Channels {
+		args = append(args, "-ac", strconv.Itoa(channels))
+	}
 
-func (p *EnhancedAudioProcessor) convertOGGtoMP3(input, output string, job *models.ProcessingJob) (string, error) {
-	// For now, just copy the file with MP3 extension
-	// Real implementation would require OGG decoding and MP3 encoding
-	return p.copyAudioFile(input, output)
+	if profile.Lossless {
+		codecArgs, err := losslessCodecArgs(profile, info, settings)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, codecArgs...)
+	} else {
+		args = append(args, "-c:a", profile.Codec, "-b:a", quality.Bitrate)
+	}
+	args = append(args, profile.ExtraArgs...)
+
+	return append(args, output), nil
 }
 
-// copyAudioFile is a helper function to copy audio files
-func (p *EnhancedAudioProcessor) copyAudioFile(input, output string) (string, error) {
-	inputFile, err := os.Open(input)
+// losslessCodecArgs picks the PCM codec or FLAC sample format for the requested bit depth
+func losslessCodecArgs(profile AudioCodecProfile, info *AudioStreamInfo, settings map[string]interface{}) ([]string, error) {
+	defaultDepth := 16
+	if info.BitDepth > 16 {
+		defaultDepth = 24
+	}
+	bitDepth, err := utils.GetIntSetting(settings, "bit_depth", defaultDepth)
 	if err != nil {
-		return "", fmt.Errorf("failed to open input file: %w", err)
+		return nil, err
 	}
-	defer inputFile.Close()
 
-	outputFile, err := os.Create(output)
-	if err != nil {
-		return "", fmt.Errorf("failed to create output file: %w", err)
+	if profile.Codec == "flac" {
+		switch bitDepth {
+		case 16:
+			return []string{"-c:a", "flac", "-sample_fmt", "s16"}, nil
+		case 24:
+			return []string{"-c:a", "flac", "-sample_fmt", "s32", "-bits_per_raw_sample", "24"}, nil
+		default:
+			return nil, fmt.Errorf("bit_depth must be 16 or 24 for FLAC, got %d", bitDepth)
+		}
 	}
-	defer outputFile.Close()
 
-	if _, err := io.Copy(outputFile, inputFile); err != nil {
-		return "", fmt.Errorf("failed to copy file: %w", err)
+	switch bitDepth {
+	case 8:
+		return []string{"-c:a", "pcm_u8"}, nil
+	case 16:
+		return []string{"-c:a", "pcm_s16le"}, nil
+	case 24:
+		return []string{"-c:a", "pcm_s24le"}, nil
+	case 32:
+		return []string{"-c:a", "pcm_s32le"}, nil
+	default:
+		return nil, fmt.Errorf("bit_depth must be 8, 16, 24 or 32 for WAV, got %d", bitDepth)
 	}
+}
 
-	return output, nil
+// validateSampleRate checks a sample rate against what the profile's encoder takes, so a bad setting fails
+// with a reason rather than an ffmpeg error
+func validateSampleRate(profile AudioCodecProfile, sampleRate int) error {
+	if profile.SampleRates != nil {
+		if !slices.Contains(profile.SampleRates, sampleRate) {
+			rates := make([]string, len(profile.SampleRates))
+			for i, rate := range profile.SampleRates {
+				rates[i] = strconv.Itoa(rate)
+			}
+			return fmt.Errorf("sample_rate must be one of %s for %s, got %d", strings.Join(rates, ", "), profile.Codec, sampleRate)
+		}
+		return nil
+	}
+	if sampleRate < 1 || sampleRate > profile.MaxSampleRate {
+		return fmt.Errorf("sample_rate must be between 1 and %d for %s, got %d", profile.MaxSampleRate, profile.Codec, sampleRate)
+	}
+	return nil
 }
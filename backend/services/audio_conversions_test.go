@@ -0,0 +1,81 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/qoal/file-processor/config"
+)
+
+func TestBuildTranscodeArgsValidatesSettings(t *testing.T) {
+	p := NewEnhancedAudioProcessor(&config.Config{})
+	stereo := &AudioStreamInfo{Codec: "pcm_s16le", Channels: 2, SampleRate: 44100, BitDepth: 16}
+	surround := &AudioStreamInfo{Codec: "flac", Channels: 6, SampleRate: 96000, BitDepth: 24}
+
+	tests := []struct {
+		name     string
+		format   string
+		info     *AudioStreamInfo
+		settings map[string]interface{}
+		wantArgs []string // pairs expected in the argument list
+		wantErr  string
+	}{
+		{"mp3 defaults", "mp3", stereo, nil, []string{"-ar", "44100", "-c:a", "libmp3lame"}, ""},
+		{"mp3 at a standard rate", "mp3", stereo, map[string]interface{}{"sample_rate": 22050}, []string{"-ar", "22050"}, ""},
+		{"mp3 at a rate it lacks", "mp3", stereo, map[string]interface{}{"sample_rate": 96000}, nil, "sample_rate must be one of"},
+		{"aac at 96 kHz", "m4a", stereo, map[string]interface{}{"sample_rate": 96000}, []string{"-ar", "96000"}, ""},
+		{"aac at an odd rate", "aac", stereo, map[string]interface{}{"sample_rate": 44000}, nil, "sample_rate must be one of"},
+		{"wma above its ceiling", "wma", stereo, map[string]interface{}{"sample_rate": 96000}, nil, "between 1 and 48000"},
+		{"ogg at an odd rate", "ogg", stereo, map[string]interface{}{"sample_rate": 44000}, []string{"-ar", "44000"}, ""},
+		{"zero rate", "flac", stereo, map[string]interface{}{"sample_rate": 0}, nil, "sample_rate must be between"},
+		{"flac keeps the source rate", "flac", surround, nil, []string{"-ar", "96000", "-bits_per_raw_sample", "24"}, ""},
+		{"surround source downmixed for mp3", "mp3", surround, nil, []string{"-ac", "2"}, ""},
+		{"surround requested for mp3", "mp3", stereo, map[string]interface{}{"channels": 6}, nil, "channels must be at most 2"},
+		{"mono requested", "mp3", stereo, map[string]interface{}{"channels": 1}, []string{"-ac", "1"}, ""},
+		{"no channels", "wav", stereo, map[string]interface{}{"channels": 0}, nil, "invalid channel count"},
+		{"wav at 24 bits", "wav", stereo, map[string]interface{}{"bit_depth": 24}, []string{"-c:a", "pcm_s24le"}, ""},
+		{"wav at 12 bits", "wav", stereo, map[string]interface{}{"bit_depth": 12}, nil, "bit_depth must be 8, 16, 24 or 32"},
+		{"flac at 32 bits", "flac", stereo, map[string]interface{}{"bit_depth": 32}, nil, "bit_depth must be 16 or 24"},
+		{"bit depth for a lossy target", "mp3", stereo, map[string]interface{}{"bit_depth": 24}, nil, "bit_depth only applies to lossless"},
+		{"sample rate not a number", "mp3", stereo, map[string]interface{}{"sample_rate": "fast"}, nil, "sample_rate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := GetAudioCodecProfile(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			args, err := p.buildTranscodeArgs("in", "out", profile, tt.info, tt.settings)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("buildTranscodeArgs() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < len(tt.wantArgs); i += 2 {
+				at := slices.Index(args, tt.wantArgs[i])
+				if at == -1 || at+1 >= len(args) || args[at+1] != tt.wantArgs[i+1] {
+					t.Errorf("args %q lack %s %s", args, tt.wantArgs[i], tt.wantArgs[i+1])
+				}
+			}
+		})
+	}
+}
+
+func TestAudioProfilesAcceptPresetRates(t *testing.T) {
+	// Lossy targets default to their quality preset's rate, which every one of them must take
+	p := NewEnhancedAudioProcessor(&config.Config{})
+	for format, profile := range audioCodecProfiles {
+		for _, preset := range []string{"low", "standard", "high", "veryhigh"} {
+			rate := p.getAudioQualityPreset(map[string]interface{}{"quality_preset": preset}).SampleRate
+			if err := validateSampleRate(profile, rate); err != nil {
+				t.Errorf("%s with the %s preset: %v", format, preset, err)
+			}
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/qoal/file-processor/config"
 	"github.com/qoal/file-processor/models"
@@ -11,12 +13,14 @@ import (
 )
 
 type EnhancedAudioProcessor struct {
-	config *config.Config
+	config   *config.Config
+	executor *utils.SecureCommandExecutor
 }
 
 func NewEnhancedAudioProcessor(cfg *config.Config) *EnhancedAudioProcessor {
 	return &EnhancedAudioProcessor{
-		config: cfg,
+		config:   cfg,
+		executor: utils.NewSecureCommandExecutor(15 * time.Minute),
 	}
 }
 
@@ -58,25 +62,14 @@ func (p *EnhancedAudioProcessor) executeAudioConversion(inputFile string, job *m
 	outputFile := filepath.Join(p.config.OutputDir,
 		job.JobID+"_output"+ext)
 
-	conversionType := job.SourceFormat + "_TO_" + job.TargetFormat
-	switch conversionType {
-	case "MP3_TO_WAV":
-		return p.convertMP3toWAV(inputFile, outputFile, job)
-	case "WAV_TO_MP3":
-		return p.convertWAVtoMP3(inputFile, outputFile, job)
-	case "FLAC_TO_MP3":
-		return p.convertFLACtoMP3(inputFile, outputFile, job)
-	case "M4A_TO_MP3":
-		return p.convertM4AtoMP3(inputFile, outputFile, job)
-	case "OGG_TO_MP3":
-		return p.convertOGGtoMP3(inputFile, outputFile, job)
-	default:
+	// Every supported source converts into every supported target
+	if _, err := utils.GetAudioExtension(job.SourceFormat); err != nil {
+		conversionType := strings.ToUpper(job.SourceFormat) + "_TO_" + strings.ToUpper(job.TargetFormat)
 		return "", fmt.Errorf("unsupported audio conversion: %s", conversionType)
 	}
-}
 
-// buildSpecificAudioCommand is deprecated - audio conversions now use simple file copy
-// to avoid external FFmpeg dependency. This allows the system to work without external tools.
+	return p.transcodeAudio(inputFile, outputFile, job)
+}
 
 type AudioQuality struct {
 	Bitrate     string
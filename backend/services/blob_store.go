@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/qoal/file-processor/models"
+	"github.com/qoal/file-processor/storage"
+	"github.com/qoal/file-processor/utils"
+)
+
+// Blobs are shared only between one user's jobs: under per-user encryption nobody else could read them, and
+// a hit on another user's upload would tell a user what that user had stored.
+
+// StoreUpload stores an uploaded file under its SHA-256 digest, once per user however often it's uploaded,
+// and returns its key and digest. The caller holds a reference until it calls ReleaseBlob, by which time
+// the job created for the file holds its own.
+func (s *JobService) StoreUpload(ctx context.Context, userID, filename string, r io.ReadSeeker, size int64) (string, string, error) {
+	digest, err := utils.HashReader(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", "", fmt.Errorf("failed to rewind file: %w", err)
+	}
+
+	key := storage.BlobKey(userID, digest, filename)
+	stored, err := s.AcquireBlob(ctx, userID, key)
+	if err != nil {
+		return "", "", err
+	}
+	if stored {
+		return key, digest, nil
+	}
+
+	if err := storage.ForUser(s.storage, userID).Save(key, r, size, storage.GetMimeType(filename)); err != nil {
+		return "", "", fmt.Errorf("failed to save file: %w", err)
+	}
+	if err := addBlob(s.db, &models.Blob{StorageKey: key, UserID: userID, Digest: digest, Size: size}); err != nil {
+		return "", "", err
+	}
+	return key, digest, nil
+}
+
+// addBlob records a newly stored file with one reference, or takes another if the same content was stored
+// concurrently
+func addBlob(db *gorm.DB, blob *models.Blob) error {
+	blob.RefCount = 1
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "storage_key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("qoal_blob.ref_count + 1")}),
+	}).Create(blob).Error
+	if err != nil {
+		return fmt.Errorf("failed to record stored file: %w", err)
+	}
+	return nil
+}
+
+// AcquireBlob takes a reference to the user's blob at key; it reports false when key isn't one
+func (s *JobService) AcquireBlob(ctx context.Context, userID, key string) (bool, error) {
+	result := s.db.Model(&models.Blob{}).
+		Where("storage_key = ? AND user_id = ?", key, userID).
+		Update("ref_count", gorm.Expr("ref_count + 1"))
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to reference stored file: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ReleaseBlob drops a reference to the user's blob at key and deletes the file with the last one, along with any
+// cached result that pointed at it. Keys that aren't the user's blobs, such as direct uploads, are left alone.
+func (s *JobService) ReleaseBlob(ctx context.Context, userID, key string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return s.releaseBlobsIn(tx, userID, []string{key})
+	})
+}
+
+// releaseBlobsIn releases the user's blobs at keys within tx. Files are deleted once every reference is dropped
+// but before tx commits, while their rows are still locked, so a failure leaves every row in place.
+func (s *JobService) releaseBlobsIn(tx *gorm.DB, userID string, keys []string) error {
+	var unused []string
+	for _, key := range keys {
+		// Locked, so an upload of the same content waits to see whether the file survives
+		var blob models.Blob
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("storage_key = ? AND user_id = ?", key, userID).First(&blob).Error
+		if err == gorm.ErrRecordNotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get stored file: %w", err)
+		}
+
+		if blob.RefCount > 1 {
+			if err := tx.Model(&blob).Update("ref_count", blob.RefCount-1).Error; err != nil {
+				return fmt.Errorf("failed to release stored file: %w", err)
+			}
+			continue
+		}
+
+		if err := tx.Where("output_path = ?", key).Delete(&models.CachedResult{}).Error; err != nil {
+			return fmt.Errorf("failed to delete cached result: %w", err)
+		}
+		if err := tx.Delete(&blob).Error; err != nil {
+			return fmt.Errorf("failed to release stored file: %w", err)
+		}
+		unused = append(unused, key)
+	}
+
+	for _, key := range unused {
+		if err := s.storage.Delete(key); err != nil {
+			return fmt.Errorf("failed to delete stored file: %w", err)
+		}
+	}
+	return nil
+}
+
+// acquireBlobs takes a reference to each of keys that is one of the user's blobs and returns those it took
+func (s *JobService) acquireBlobs(ctx context.Context, userID string, keys []string) ([]string, error) {
+	var held []string
+	for _, key := range keys {
+		acquired, err := s.AcquireBlob(ctx, userID, key)
+		if err != nil {
+			s.releaseBlobs(ctx, userID, held)
+			return nil, err
+		}
+		if acquired {
+			held = append(held, key)
+		}
+	}
+	return held, nil
+}
+
+func (s *JobService) releaseBlobs(ctx context.Context, userID string, keys []string) {
+	for _, key := range keys {
+		s.ReleaseBlob(ctx, userID, key)
+	}
+}
+
+// OwnsFile reports whether key is one of the user's files: a blob of theirs, the input of one of their jobs, such
+// as a direct upload, or the output of one they completed
+func (s *JobService) OwnsFile(ctx context.Context, userID, key string) (bool, error) {
+	var blobs int64
+	if err := s.db.Model(&models.Blob{}).Where("storage_key = ? AND user_id = ?", key, userID).Count(&blobs).Error; err != nil {
+		return false, fmt.Errorf("failed to check stored file: %w", err)
+	}
+	if blobs > 0 {
+		return true, nil
+	}
+
+	var jobs int64
+	err := s.db.Model(&models.Job{}).
+		Where("user_id = ? AND (input_path = ? OR (output_path = ? AND status = ?))", userID, key, key, models.StatusCompleted).
+		Count(&jobs).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check stored file: %w", err)
+	}
+	return jobs > 0, nil
+}
+
+// blobDigest returns the digest of the user's upload at key, or "" when key isn't one
+func (s *JobService) blobDigest(userID, key string) (string, error) {
+	var blob models.Blob
+	err := s.db.Where("storage_key = ? AND user_id = ?", key, userID).First(&blob).Error
+	if err == gorm.ErrRecordNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get stored file: %w", err)
+	}
+	return blob.Digest, nil
+}
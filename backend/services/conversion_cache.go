@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/qoal/file-processor/models"
+)
+
+// cacheKey identifies a job's result by whose it is, what it does, and the content it does it to.
+// Only single-input jobs on a stored upload have a digest to key on; merges always run.
+func cacheKey(userID, jobType, inputDigest, targetFormat string, settings map[string]interface{}) (string, error) {
+	normalized, err := normalizeSettings(settings)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal([]interface{}{userID, jobType, inputDigest, strings.ToLower(targetFormat), normalized})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal cache key: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// normalizeSettings returns settings as they'd read back from the queue, so a task's settings key the same
+// result as the request's. Only null values are dropped, as absent: a false or empty setting can differ from
+// a processor's default. Map keys marshal sorted.
+func normalizeSettings(settings map[string]interface{}) (interface{}, error) {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal settings: %w", err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal settings: %w", err)
+	}
+	return dropNulls(decoded), nil
+}
+
+func dropNulls(value interface{}) interface{} {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	for key, item := range object {
+		if item == nil {
+			delete(object, key)
+		} else {
+			object[key] = dropNulls(item)
+		}
+	}
+	if len(object) == 0 {
+		return nil
+	}
+	return object
+}
+
+// cachedResult returns the result of an earlier identical job, with a reference taken to its output for job,
+// or nil if there is none
+func (s *JobService) cachedResult(ctx context.Context, job *models.Job, settings map[string]interface{}) (*models.CachedResult, error) {
+	if job.InputDigest == "" || len(job.InputPaths) > 0 {
+		return nil, nil
+	}
+
+	key, err := cacheKey(job.UserID, job.JobType, job.InputDigest, job.TargetFormat, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var cached models.CachedResult
+	if err := s.db.Where("cache_key = ?", key).First(&cached).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get cached result: %w", err)
+	}
+
+	// The output may have been released since the lookup, in which case the job runs after all
+	acquired, err := s.AcquireBlob(ctx, job.UserID, cached.OutputPath)
+	if err != nil || !acquired {
+		return nil, err
+	}
+	return &cached, nil
+}
+
+// CompleteJob marks a job completed with its stored output. The job's result, its reference to the output
+// and the cache entry for later identical jobs are written in one transaction; if it fails, the output is
+// deleted, so a retry that stores its own output leaves nothing unreferenced behind.
+func (s *JobService) CompleteJob(ctx context.Context, task *JobTask, outputKey string, size int64, job *models.ProcessingJob) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		updates, err := jobResultUpdates(job)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		updates["status"] = string(models.StatusCompleted)
+		updates["output_path"] = outputKey
+		updates["completed_at"] = now
+		updates["updated_at"] = now
+		if err := tx.Model(&models.Job{}).Where("job_id = ?", task.JobID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update job status to completed: %w", err)
+		}
+
+		if err := addBlob(tx, &models.Blob{StorageKey: outputKey, UserID: task.UserID, Size: size}); err != nil {
+			return err
+		}
+		return cacheResult(tx, task, outputKey, job)
+	})
+	if err != nil {
+		s.storage.Delete(outputKey)
+		return err
+	}
+	return nil
+}
+
+// cacheResult keeps a finished job's result for later identical jobs
+func cacheResult(tx *gorm.DB, task *JobTask, outputKey string, job *models.ProcessingJob) error {
+	if task.InputDigest == "" || len(task.InputPaths) > 0 {
+		return nil
+	}
+
+	key, err := cacheKey(task.UserID, task.JobType, task.InputDigest, task.TargetFormat, task.Settings)
+	if err != nil {
+		return err
+	}
+	result := models.CachedResult{
+		CacheKey:     key,
+		UserID:       task.UserID,
+		InputDigest:  task.InputDigest,
+		TargetFormat: job.TargetFormat,
+		OutputPath:   outputKey,
+		Manifest:     job.Manifest,
+		Listing:      job.Listing,
+	}
+	// When identical jobs ran side by side, the first to finish fills the cache
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&result).Error; err != nil {
+		return fmt.Errorf("failed to cache job result: %w", err)
+	}
+	return nil
+}
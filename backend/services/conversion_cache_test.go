@@ -0,0 +1,98 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	base := map[string]interface{}{"quality_preset": "high"}
+	mustKey := func(userID, jobType, digest, target string, settings map[string]interface{}) string {
+		t.Helper()
+		key, err := cacheKey(userID, jobType, digest, target, settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	baseKey := mustKey("user", "convert", "digest", "png", base)
+
+	tests := []struct {
+		name     string
+		userID   string
+		jobType  string
+		target   string
+		settings map[string]interface{}
+		same     bool
+	}{
+		{"identical", "user", "convert", "png", map[string]interface{}{"quality_preset": "high"}, true},
+		{"target format case", "user", "convert", "PNG", base, true},
+		{"null setting is unset", "user", "convert", "png", map[string]interface{}{"quality_preset": "high", "width": nil}, true},
+		{"false differs from a default of true", "user", "convert", "png", map[string]interface{}{"quality_preset": "high", "auto_orient": false}, false},
+		{"empty string differs from unset", "user", "convert", "png", map[string]interface{}{"quality_preset": ""}, false},
+		{"other setting value", "user", "convert", "png", map[string]interface{}{"quality_preset": "low"}, false},
+		{"other user", "someone", "convert", "png", base, false},
+		{"other job type", "user", "responsive", "png", base, false},
+		{"other target", "user", "convert", "webp", base, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := mustKey(tt.userID, tt.jobType, "digest", tt.target, tt.settings)
+			if (key == baseKey) != tt.same {
+				t.Errorf("same key = %v, want %v", key == baseKey, tt.same)
+			}
+		})
+	}
+}
+
+func TestCacheKeyFalseVersusUnset(t *testing.T) {
+	// Each of these defaults to true in its processor, so turning it off must not hit a cached default run
+	for _, setting := range []string{"auto_orient", "page_numbers", "infer_types", "include_headers", "single_file"} {
+		unset, err := cacheKey("user", "convert", "digest", "pdf", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		off, err := cacheKey("user", "convert", "digest", "pdf", map[string]interface{}{setting: false})
+		if err != nil {
+			t.Fatal(err)
+		}
+		on, err := cacheKey("user", "convert", "digest", "pdf", map[string]interface{}{setting: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if off == unset || off == on {
+			t.Errorf("%s=false shares a key with unset or true", setting)
+		}
+	}
+}
+
+func TestCacheKeyMatchesQueuedSettings(t *testing.T) {
+	// A task's settings come back from the queue as JSON, and must key the same result as the request's
+	settings := map[string]interface{}{
+		"page_ranges": []string{"1-2", "5"},
+		"bookmarks":   true,
+		"width":       800,
+		"nested":      map[string]interface{}{"a": 1, "b": nil},
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queued map[string]interface{}
+	if err := json.Unmarshal(data, &queued); err != nil {
+		t.Fatal(err)
+	}
+
+	requestKey, err := cacheKey("user", "convert", "digest", "pdf", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queuedKey, err := cacheKey("user", "convert", "digest", "pdf", queued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requestKey != queuedKey {
+		t.Error("queued settings key a different result")
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/qoal/file-processor/models"
+)
+
+// deadLetterLimit bounds the dead-letter list; the oldest entries are dropped past it
+const deadLetterLimit = 10000
+
+// DeadLetter is a task that failed on every attempt, kept so it can be inspected and replayed
+type DeadLetter struct {
+	ID       string    `json:"id"`
+	Task     JobTask   `json:"task"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+func (s *JobService) deadLetter(ctx context.Context, task *JobTask, jobErr error) error {
+	entry := DeadLetter{
+		ID:       uuid.New().String(),
+		Task:     *task,
+		Error:    jobErr.Error(),
+		Attempts: task.Attempt,
+		FailedAt: time.Now(),
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+
+	pipe := s.redisClient.TxPipeline()
+	pipe.LPush(ctx, deadLetterKey, data)
+	pipe.LTrim(ctx, deadLetterKey, 0, deadLetterLimit-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to dead-letter job: %w", err)
+	}
+	return nil
+}
+
+// ListDeadLetters returns a page of dead-lettered tasks, newest first, and how many there are in all
+func (s *JobService) ListDeadLetters(ctx context.Context, page, limit int) ([]DeadLetter, int64, error) {
+	total, err := s.redisClient.LLen(ctx, deadLetterKey).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count dead letters: %w", err)
+	}
+
+	start := int64((page - 1) * limit)
+	raw, err := s.redisClient.LRange(ctx, deadLetterKey, start, start+int64(limit)-1).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+
+	entries := make([]DeadLetter, 0, len(raw))
+	for _, data := range raw {
+		var entry DeadLetter
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, 0, fmt.Errorf("failed to unmarshal dead letter: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total, nil
+}
+
+// ReplayDeadLetter takes a task off the dead-letter list and queues its job again with a fresh set of attempts
+func (s *JobService) ReplayDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	raw, err := s.redisClient.LRange(ctx, deadLetterKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+
+	for _, data := range raw {
+		var entry DeadLetter
+		if err := json.Unmarshal([]byte(data), &entry); err != nil || entry.ID != id {
+			continue
+		}
+
+		var job models.Job
+		if err := s.db.Where("job_id = ?", entry.Task.JobID).First(&job).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, fmt.Errorf("job %s no longer exists", entry.Task.JobID)
+			}
+			return nil, fmt.Errorf("failed to get job: %w", err)
+		}
+
+		// Only one replay gets past removing the entry
+		removed, err := s.redisClient.LRem(ctx, deadLetterKey, 1, data).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove dead letter: %w", err)
+		}
+		if removed == 0 {
+			return nil, fmt.Errorf("dead letter already replayed")
+		}
+
+		if err := s.db.Model(&job).Updates(map[string]interface{}{
+			"status":        string(models.StatusPending),
+			"attempts":      0,
+			"error":         "",
+			"next_retry_at": nil,
+			"updated_at":    time.Now(),
+		}).Error; err != nil {
+			return nil, fmt.Errorf("failed to reset job: %w", err)
+		}
+
+		taskData, err := json.Marshal(entry.Task)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal job task: %w", err)
+		}
+		if err := s.redisClient.LPush(ctx, queueKey, taskData).Err(); err != nil {
+			return nil, fmt.Errorf("failed to add job to queue: %w", err)
+		}
+		return &entry, nil
+	}
+
+	return nil, fmt.Errorf("dead letter not found")
+}
@@ -3,69 +3,17 @@ package services
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf16"
 
-	"github.com/jung-kurt/gofpdf"
 	"github.com/qoal/file-processor/models"
+	"github.com/qoal/file-processor/utils"
 	"github.com/unidoc/unioffice/document"
-	"github.com/unidoc/unioffice/spreadsheet"
 )
 
-func (p *EnhancedDocumentProcessor) convertTextToPDF(input, output string, job *models.ProcessingJob) (string, error) {
-	// Read text content
-	content, err := os.ReadFile(input)
-	if err != nil {
-		return "", fmt.Errorf("failed to read text file: %w", err)
-	}
-
-	// Create PDF using gofpdf
-	pdf := gofpdf.New("P", "mm", "A4", "")
-	pdf.AddPage()
-
-	// Set font
-	pdf.SetFont("Arial", "", 12)
-
-	// Add text content
-	lines := strings.Split(string(content), "\n")
-	for i, line := range lines {
-		pdf.Text(10, 20+float64(i*8), line)
-	}
-
-	// Write PDF to output file
-	if err := pdf.OutputFileAndClose(output); err != nil {
-		return "", fmt.Errorf("failed to write PDF file: %w", err)
-	}
-
-	return output, nil
-}
-
-func (p *EnhancedDocumentProcessor) convertDocxToText(input, output string, job *models.ProcessingJob) (string, error) {
-	// Open DOCX document
-	doc, err := document.Open(input)
-	if err != nil {
-		return "", fmt.Errorf("failed to open DOCX: %w", err)
-	}
-	defer doc.Close()
-
-	// Extract text content
-	var textContent strings.Builder
-
-	// Extract text from paragraphs
-	for _, para := range doc.Paragraphs() {
-		for _, run := range para.Runs() {
-			textContent.WriteString(run.Text())
-		}
-		textContent.WriteString("\n")
-	}
-
-	// Write text to output file
-	if err := os.WriteFile(output, []byte(textContent.String()), 0644); err != nil {
-		return "", fmt.Errorf("failed to write text file: %w", err)
-	}
-
-	return output, nil
-}
-
 func (p *EnhancedDocumentProcessor) convertTextToDocx(input, output string, job *models.ProcessingJob) (string, error) {
 	// Read text content
 	content, err := os.ReadFile(input)
@@ -93,96 +41,269 @@ func (p *EnhancedDocumentProcessor) convertTextToDocx(input, output string, job
 	return output, nil
 }
 
-func (p *EnhancedDocumentProcessor) convertXlsxToCSV(input, output string, job *models.ProcessingJob) (string, error) {
-	// Open XLSX spreadsheet
-	ss, err := spreadsheet.Open(input)
+// pdfPageRangePattern accepts qpdf page ranges such as "1-3,7", "r3-r1" or "1-z:odd"
+var pdfPageRangePattern = regexp.MustCompile(`^[0-9zr]+(-[0-9zr]+)?(,[0-9zr]+(-[0-9zr]+)?)*(:(even|odd))?$`)
+
+// pdfBookmark is a top-level outline entry pointing at the first page of a merged source
+type pdfBookmark struct {
+	Title string
+	Page  int
+}
+
+func (p *EnhancedDocumentProcessor) mergePDFs(inputFiles []string, output string, job *models.ProcessingJob) (string, error) {
+	pageRanges, err := utils.GetStringSliceSetting(job.Settings, "page_ranges")
+	if err != nil {
+		return "", err
+	}
+	if len(pageRanges) > len(inputFiles) {
+		return "", fmt.Errorf("got %d page ranges for %d input files", len(pageRanges), len(inputFiles))
+	}
+
+	addBookmarks, err := utils.GetBoolSetting(job.Settings, "bookmarks", false)
 	if err != nil {
-		return "", fmt.Errorf("failed to open XLSX: %w", err)
+		return "", err
 	}
-	defer ss.Close()
+	titles, err := utils.GetStringSliceSetting(job.Settings, "bookmark_titles")
+	if err != nil {
+		return "", err
+	}
+
+	workDir, err := os.MkdirTemp(p.config.TempDir, job.JobID+"_merge_")
+	if err != nil {
+		return "", fmt.Errorf("failed to create merge directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	// Cut each source down to its selected pages first so bookmark targets
+	// can be computed from the real page counts
+	parts := make([]string, len(inputFiles))
+	bookmarks := make([]pdfBookmark, len(inputFiles))
+	nextPage := 1
+	for i, input := range inputFiles {
+		pageRange := "1-z"
+		if i < len(pageRanges) && strings.TrimSpace(pageRanges[i]) != "" {
+			pageRange = strings.ReplaceAll(pageRanges[i], " ", "")
+		}
+		if !pdfPageRangePattern.MatchString(pageRange) {
+			return "", fmt.Errorf("invalid page range for input %d: %q", i+1, pageRange)
+		}
 
-	// Get the first sheet
-	sheet := ss.Sheets()[0]
+		parts[i] = filepath.Join(workDir, fmt.Sprintf("part_%03d.pdf", i))
+		args := []string{input, "--pages", ".", pageRange, "--", parts[i]}
+		if err := p.executor.ExecuteCommand("qpdf", args); err != nil {
+			return "", fmt.Errorf("failed to select pages from input %d: %w", i+1, err)
+		}
 
-	// Convert to CSV format
-	var csvContent strings.Builder
+		pages, err := p.countPDFPages(parts[i])
+		if err != nil {
+			return "", err
+		}
 
-	// Iterate through rows and cells
-	for _, row := range sheet.Rows() {
-		var cells []string
-		for _, cell := range row.Cells() {
-			cells = append(cells, cell.GetString())
+		title := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+		if i < len(titles) && titles[i] != "" {
+			title = titles[i]
 		}
-		csvContent.WriteString(strings.Join(cells, ","))
-		csvContent.WriteString("\n")
+		bookmarks[i] = pdfBookmark{Title: title, Page: nextPage}
+		nextPage += pages
+	}
+
+	merged := output
+	if addBookmarks {
+		merged = filepath.Join(workDir, "merged.pdf")
+	}
+
+	args := []string{"--empty"}
+	if addBookmarks {
+		// The outline is appended as an update, which needs a plain cross-reference table to follow
+		args = append(args, "--object-streams=disable")
+	}
+	args = append(args, "--pages")
+	args = append(args, parts...)
+	args = append(args, "--", merged)
+	if err := p.executor.ExecuteCommand("qpdf", args); err != nil {
+		return "", fmt.Errorf("failed to merge PDFs: %w", err)
 	}
 
-	// Write CSV to output file
-	if err := os.WriteFile(output, []byte(csvContent.String()), 0644); err != nil {
-		return "", fmt.Errorf("failed to write CSV file: %w", err)
+	if addBookmarks {
+		if err := p.addPDFOutline(merged, output, bookmarks); err != nil {
+			return "", err
+		}
 	}
 
 	return output, nil
 }
 
-func (p *EnhancedDocumentProcessor) convertCSVToXlsx(input, output string, job *models.ProcessingJob) (string, error) {
-	// Read CSV content
-	content, err := os.ReadFile(input)
+// countPDFPages returns the number of pages qpdf reports for a PDF
+func (p *EnhancedDocumentProcessor) countPDFPages(input string) (int, error) {
+	out, err := p.executor.ExecuteCommandOutput("qpdf", []string{"--show-npages", input})
 	if err != nil {
-		return "", fmt.Errorf("failed to read CSV file: %w", err)
+		return 0, fmt.Errorf("failed to count PDF pages: %w", err)
 	}
 
-	// Create new spreadsheet
-	ss := spreadsheet.New()
-	defer ss.Close()
+	pages, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected qpdf page count output: %q", out)
+	}
+	return pages, nil
+}
 
-	// Add a sheet
-	sheet := ss.AddSheet()
+// pdfRef is a reference to an indirect PDF object, written "12 0 R"
+type pdfRef struct {
+	Num, Gen int
+}
 
-	// Parse CSV and populate sheet
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
+func (r pdfRef) String() string {
+	return fmt.Sprintf("%d %d R", r.Num, r.Gen)
+}
 
-		cells := strings.Split(line, ",")
-		row := sheet.AddRow()
+var (
+	pdfShowPagesPattern = regexp.MustCompile(`(?m)^page \d+: (\d+) (\d+) R`)
+	pdfRootPattern      = regexp.MustCompile(`/Root\s+(\d+)\s+(\d+)\s+R`)
+	pdfSizePattern      = regexp.MustCompile(`/Size\s+(\d+)`)
+	pdfIDPattern        = regexp.MustCompile(`/ID\s*(\[[^\]]*\])`)
+	pdfStartXrefPattern = regexp.MustCompile(`startxref\s+(\d+)\s+%%EOF\s*$`)
+)
 
-		for _, cellValue := range cells {
-			cell := row.AddCell()
-			cell.SetString(cellValue)
-		}
+// addPDFOutline writes input to output with one top-level bookmark per merged source. The outline is appended to
+// the file as an incremental update, so the pages are kept byte for byte rather than rendered again.
+func (p *EnhancedDocumentProcessor) addPDFOutline(input, output string, bookmarks []pdfBookmark) error {
+	showPages, err := p.executor.ExecuteCommandOutput("qpdf", []string{"--show-pages", input})
+	if err != nil {
+		return fmt.Errorf("failed to read merged pages: %w", err)
+	}
+	var pages []pdfRef
+	for _, m := range pdfShowPagesPattern.FindAllStringSubmatch(string(showPages), -1) {
+		num, _ := strconv.Atoi(m[1])
+		gen, _ := strconv.Atoi(m[2])
+		pages = append(pages, pdfRef{num, gen})
 	}
 
-	// Save spreadsheet
-	if err := ss.SaveToFile(output); err != nil {
-		return "", fmt.Errorf("failed to save XLSX file: %w", err)
+	trailer, err := p.executor.ExecuteCommandOutput("qpdf", []string{"--show-object=trailer", input})
+	if err != nil {
+		return fmt.Errorf("failed to read merged trailer: %w", err)
+	}
+	m := pdfRootPattern.FindStringSubmatch(string(trailer))
+	if m == nil {
+		return fmt.Errorf("merged PDF has no document catalog")
+	}
+	catalog, err := p.executor.ExecuteCommandOutput("qpdf", []string{"--show-object=" + m[1] + "," + m[2], input})
+	if err != nil {
+		return fmt.Errorf("failed to read merged catalog: %w", err)
 	}
 
-	return output, nil
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return fmt.Errorf("failed to read merged PDF: %w", err)
+	}
+	update, err := pdfOutlineUpdate(data, string(trailer), string(catalog), pages, bookmarks)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(output, append(data, update...), 0644); err != nil {
+		return fmt.Errorf("failed to write merged PDF: %w", err)
+	}
+	return nil
 }
 
-func (p *EnhancedDocumentProcessor) mergePDFs(inputFiles []string, output string, job *models.ProcessingJob) (string, error) {
-	// Create output file
-	out, err := os.Create(output)
-	if err != nil {
-		return "", fmt.Errorf("failed to create output file: %w", err)
+// pdfOutlineUpdate returns the incremental update that gives pdf an outline: the outline's objects, the catalog
+// pointing at it, and a cross-reference section for them chained to the file's own. trailer and catalog are the
+// file's trailer and catalog dictionaries, and pages its page objects in order.
+func pdfOutlineUpdate(pdf []byte, trailer, catalog string, pages []pdfRef, bookmarks []pdfBookmark) ([]byte, error) {
+	tail := pdf[max(len(pdf)-1024, 0):]
+	m := pdfStartXrefPattern.FindSubmatch(tail)
+	if m == nil {
+		return nil, fmt.Errorf("merged PDF has no startxref")
+	}
+	prevXref := string(m[1])
+
+	rootMatch := pdfRootPattern.FindStringSubmatch(trailer)
+	sizeMatch := pdfSizePattern.FindStringSubmatch(trailer)
+	if rootMatch == nil || sizeMatch == nil {
+		return nil, fmt.Errorf("merged PDF has an incomplete trailer")
+	}
+	rootNum, _ := strconv.Atoi(rootMatch[1])
+	rootGen, _ := strconv.Atoi(rootMatch[2])
+	root := pdfRef{rootNum, rootGen}
+	size, _ := strconv.Atoi(sizeMatch[1])
+
+	catalog = strings.TrimSpace(catalog)
+	if !strings.HasPrefix(catalog, "<<") || !strings.HasSuffix(catalog, ">>") {
+		return nil, fmt.Errorf("merged PDF has an invalid document catalog")
+	}
+	if strings.Contains(catalog, "/Outlines") {
+		return nil, fmt.Errorf("merged PDF already has an outline")
 	}
-	defer out.Close()
 
-	// For now, create a simple merged representation
-	var mergedContent strings.Builder
-	mergedContent.WriteString("Merged PDF document\n")
-	mergedContent.WriteString(fmt.Sprintf("Merged from %d files:\n", len(inputFiles)))
+	// The outline dictionary takes the next free object number and its items the ones after
+	outline := pdfRef{size, 0}
+	items := make([]pdfRef, len(bookmarks))
+	for i := range items {
+		items[i] = pdfRef{size + 1 + i, 0}
+	}
 
-	for i, file := range inputFiles {
-		mergedContent.WriteString(fmt.Sprintf("File %d: %s\n", i+1, file))
+	var b strings.Builder
+	offset := len(pdf)
+	if len(pdf) > 0 && pdf[len(pdf)-1] != '\n' {
+		b.WriteString("\n")
+	}
+	offsets := make(map[int]int)
+	writeObject := func(ref pdfRef, body string) {
+		offsets[ref.Num] = offset + b.Len()
+		fmt.Fprintf(&b, "%d %d obj\n%s\nendobj\n", ref.Num, ref.Gen, body)
 	}
 
-	if err := os.WriteFile(output, []byte(mergedContent.String()), 0644); err != nil {
-		return "", fmt.Errorf("failed to write merged PDF: %w", err)
+	catalogExtra := fmt.Sprintf(" /Outlines %s", outline)
+	if !strings.Contains(catalog, "/PageMode") {
+		catalogExtra += " /PageMode /UseOutlines"
 	}
+	writeObject(root, strings.TrimSpace(strings.TrimSuffix(catalog, ">>"))+catalogExtra+" >>")
 
-	return output, nil
+	outlineBody := "<< /Type /Outlines >>"
+	if len(items) > 0 {
+		outlineBody = fmt.Sprintf("<< /Type /Outlines /First %s /Last %s /Count %d >>", items[0], items[len(items)-1], len(items))
+	}
+	writeObject(outline, outlineBody)
+
+	for i, bookmark := range bookmarks {
+		if bookmark.Page < 1 || bookmark.Page > len(pages) {
+			return nil, fmt.Errorf("bookmark %q points at page %d of %d", bookmark.Title, bookmark.Page, len(pages))
+		}
+		var item strings.Builder
+		fmt.Fprintf(&item, "<< /Title %s /Parent %s /Dest [%s /Fit]", pdfTextString(bookmark.Title), outline, pages[bookmark.Page-1])
+		if i > 0 {
+			fmt.Fprintf(&item, " /Prev %s", items[i-1])
+		}
+		if i+1 < len(items) {
+			fmt.Fprintf(&item, " /Next %s", items[i+1])
+		}
+		item.WriteString(" >>")
+		writeObject(items[i], item.String())
+	}
+
+	// Each cross-reference entry is exactly 20 bytes, its line ending included
+	xref := offset + b.Len()
+	fmt.Fprintf(&b, "xref\n%d 1\n%010d %05d n\r\n", root.Num, offsets[root.Num], root.Gen)
+	fmt.Fprintf(&b, "%d %d\n", outline.Num, len(items)+1)
+	for num := outline.Num; num <= outline.Num+len(items); num++ {
+		fmt.Fprintf(&b, "%010d 00000 n\r\n", offsets[num])
+	}
+
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root %s /Prev %s", size+len(items)+1, root, prevXref)
+	if id := pdfIDPattern.FindStringSubmatch(trailer); id != nil {
+		fmt.Fprintf(&b, " /ID %s", id[1])
+	}
+	fmt.Fprintf(&b, " >>\nstartxref\n%d\n%%%%EOF\n", xref)
+	return []byte(b.String()), nil
+}
+
+// pdfTextString encodes s as a UTF-16BE hex string, which holds any title without escaping
+func pdfTextString(s string) string {
+	var hex strings.Builder
+	hex.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&hex, "%04X", unit)
+	}
+	hex.WriteString(">")
+	return hex.String()
 }
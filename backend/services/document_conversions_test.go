@@ -0,0 +1,177 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPDFPageRangePattern(t *testing.T) {
+	tests := []struct {
+		pageRange string
+		valid     bool
+	}{
+		{"1-z", true},
+		{"1-3,7", true},
+		{"r3-r1", true},
+		{"1-z:odd", true},
+		{"2,4,6:even", true},
+		{"z", true},
+		{"", false},
+		{"1-3,", false},
+		{"1--3", false},
+		{"a-b", false},
+		{"1-3:all", false},
+		{"1;rm", false},
+		{"--pages", false},
+	}
+
+	for _, tt := range tests {
+		if got := pdfPageRangePattern.MatchString(tt.pageRange); got != tt.valid {
+			t.Errorf("pdfPageRangePattern.MatchString(%q) = %v, want %v", tt.pageRange, got, tt.valid)
+		}
+	}
+}
+
+// testPDF lays out a minimal PDF with a catalog, a page tree and pages pages, and returns it with the
+// trailer and catalog dictionaries as qpdf would show them
+func testPDF(pages int) ([]byte, string, string, []pdfRef) {
+	var b strings.Builder
+	b.WriteString("%PDF-1.7\n")
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	catalog := "<< /Pages 2 0 R /Type /Catalog >>"
+	object(catalog)
+	var kids []string
+	var refs []pdfRef
+	for i := 0; i < pages; i++ {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 3+i))
+		refs = append(refs, pdfRef{3 + i, 0})
+	}
+	object(fmt.Sprintf("<< /Count %d /Kids [%s] /Type /Pages >>", pages, strings.Join(kids, " ")))
+	for i := 0; i < pages; i++ {
+		object("<< /MediaBox [0 0 612 792] /Parent 2 0 R /Type /Page >>")
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f\r\n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n\r\n", offset)
+	}
+	trailer := fmt.Sprintf("<< /ID [<01> <02>] /Root 1 0 R /Size %d >>", len(offsets)+1)
+	fmt.Fprintf(&b, "trailer %s\nstartxref\n%d\n%%%%EOF\n", trailer, xref)
+	return []byte(b.String()), trailer, catalog, refs
+}
+
+func TestPDFOutlineUpdate(t *testing.T) {
+	pdf, trailer, catalog, pages := testPDF(5)
+	bookmarks := []pdfBookmark{{"Intro", 1}, {"Résumé", 2}, {"Appendix", 5}}
+
+	update, err := pdfOutlineUpdate(pdf, trailer, catalog, pages, bookmarks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := append(append([]byte(nil), pdf...), update...)
+
+	// The new startxref points at the update's cross-reference section, chained to the original
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(out)
+	if m == nil {
+		t.Fatalf("update doesn't end in startxref: %q", update)
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !strings.HasPrefix(string(out[xref:]), "xref\n") {
+		t.Fatalf("startxref %d doesn't point at xref", xref)
+	}
+	if !strings.Contains(string(update), "/Size 12 /Root 1 0 R /Prev "+string(pdfStartXrefPattern.FindSubmatch(pdf)[1])+" /ID [<01> <02>] >>") {
+		t.Errorf("trailer = %q", update[strings.Index(string(update), "trailer"):])
+	}
+
+	// Every entry is 20 bytes and points at the object it numbers
+	section := string(out[xref:])
+	objects := make(map[int]string)
+	lines := regexp.MustCompile(`(?m)^(\d+) (\d+)\n((?:\d{10} \d{5} n\r\n)+)`).FindAllStringSubmatch(section, -1)
+	if len(lines) != 2 {
+		t.Fatalf("got %d cross-reference subsections, want 2: %q", len(lines), section)
+	}
+	for _, sub := range lines {
+		first, _ := strconv.Atoi(sub[1])
+		entries := sub[3]
+		for i := 0; i < len(entries)/20; i++ {
+			offset, _ := strconv.Atoi(entries[i*20 : i*20+10])
+			num := first + i
+			header := fmt.Sprintf("%d 0 obj\n", num)
+			if !strings.HasPrefix(string(out[offset:]), header) {
+				t.Fatalf("entry for object %d points at %q", num, out[offset:min(offset+20, len(out))])
+			}
+			body := string(out[offset+len(header):])
+			objects[num] = body[:strings.Index(body, "\nendobj")]
+		}
+	}
+
+	if got, want := objects[1], "<< /Pages 2 0 R /Type /Catalog /Outlines 8 0 R /PageMode /UseOutlines >>"; got != want {
+		t.Errorf("catalog = %s, want %s", got, want)
+	}
+	if got, want := objects[8], "<< /Type /Outlines /First 9 0 R /Last 11 0 R /Count 3 >>"; got != want {
+		t.Errorf("outline = %s, want %s", got, want)
+	}
+	items := []string{
+		"<< /Title " + pdfTextString("Intro") + " /Parent 8 0 R /Dest [3 0 R /Fit] /Next 10 0 R >>",
+		"<< /Title " + pdfTextString("Résumé") + " /Parent 8 0 R /Dest [4 0 R /Fit] /Prev 9 0 R /Next 11 0 R >>",
+		"<< /Title " + pdfTextString("Appendix") + " /Parent 8 0 R /Dest [7 0 R /Fit] /Prev 10 0 R >>",
+	}
+	for i, want := range items {
+		if got := objects[9+i]; got != want {
+			t.Errorf("item %d = %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+func TestPDFOutlineUpdateRejects(t *testing.T) {
+	pdf, trailer, catalog, pages := testPDF(2)
+
+	tests := []struct {
+		name      string
+		pdf       []byte
+		trailer   string
+		catalog   string
+		bookmarks []pdfBookmark
+	}{
+		{"no startxref", pdf[:len(pdf)-20], trailer, catalog, []pdfBookmark{{"a", 1}}},
+		{"trailer without a root", pdf, "<< /Size 5 >>", catalog, []pdfBookmark{{"a", 1}}},
+		{"catalog not a dictionary", pdf, trailer, "null", []pdfBookmark{{"a", 1}}},
+		{"existing outline", pdf, trailer, "<< /Outlines 9 0 R /Pages 2 0 R /Type /Catalog >>", []pdfBookmark{{"a", 1}}},
+		{"page past the end", pdf, trailer, catalog, []pdfBookmark{{"a", 1}, {"b", 3}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := pdfOutlineUpdate(tt.pdf, tt.trailer, tt.catalog, pages, tt.bookmarks); err == nil {
+				t.Error("pdfOutlineUpdate() succeeded")
+			}
+		})
+	}
+}
+
+func TestPDFTextString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", "<FEFF>"},
+		{"Ab", "<FEFF00410062>"},
+		{"(é)", "<FEFF002800E90029>"},
+		{"𝄞", "<FEFFD834DD1E>"},
+	}
+
+	for _, tt := range tests {
+		if got := pdfTextString(tt.in); got != tt.want {
+			t.Errorf("pdfTextString(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/qoal/file-processor/models"
+	"github.com/qoal/file-processor/utils"
+)
+
+// defaultFontDir is where the font-dejavu package installs its TTFs
+const defaultFontDir = "/usr/share/fonts/dejavu"
+
+// PDFLayout controls page geometry and decoration for rendered text
+type PDFLayout struct {
+	PageSize    string
+	Orientation string
+	Margin      float64 // mm
+	FontSize    float64 // pt
+	Header      string
+	Footer      string
+	PageNumbers bool
+	Markdown    bool
+}
+
+// pdfFonts names the registered font families and how to encode text for them
+type pdfFonts struct {
+	Regular   string
+	Bold      string
+	Mono      string
+	Bullet    string
+	translate func(string) string
+}
+
+var (
+	mdHeadingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdListPattern    = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	mdRulePattern    = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)
+	mdBoldPattern    = regexp.MustCompile(`(\*\*|__)(.+?)(\*\*|__)`)
+	mdCodePattern    = regexp.MustCompile("`([^`]+)`")
+	mdLinkPattern    = regexp.MustCompile(`\[([^\]]+)\]\(([^)]+)\)`)
+)
+
+// mdHeadingScale sizes headings h1..h6 relative to the body font
+var mdHeadingScale = []float64{2.0, 1.6, 1.35, 1.2, 1.1, 1.0}
+
+func (p *EnhancedDocumentProcessor) getPDFLayout(job *models.ProcessingJob) (PDFLayout, error) {
+	layout := PDFLayout{}
+	var err error
+
+	if layout.PageSize, err = utils.GetStringSetting(job.Settings, "page_size", "A4"); err != nil {
+		return layout, err
+	}
+	switch strings.ToLower(layout.PageSize) {
+	case "a3", "a4", "a5", "letter", "legal":
+	default:
+		return layout, fmt.Errorf("unsupported page size: %s", layout.PageSize)
+	}
+
+	if layout.Orientation, err = utils.GetStringSetting(job.Settings, "orientation", "portrait"); err != nil {
+		return layout, err
+	}
+	switch strings.ToLower(layout.Orientation) {
+	case "portrait", "p":
+		layout.Orientation = "P"
+	case "landscape", "l":
+		layout.Orientation = "L"
+	default:
+		return layout, fmt.Errorf("unsupported orientation: %s", layout.Orientation)
+	}
+
+	margin, err := utils.GetIntSetting(job.Settings, "margin_mm", 20)
+	if err != nil {
+		return layout, err
+	}
+	if margin < 5 || margin > 60 {
+		return layout, fmt.Errorf("margin_mm must be between 5 and 60, got %d", margin)
+	}
+	layout.Margin = float64(margin)
+
+	fontSize, err := utils.GetIntSetting(job.Settings, "font_size", 11)
+	if err != nil {
+		return layout, err
+	}
+	if fontSize < 6 || fontSize > 36 {
+		return layout, fmt.Errorf("font_size must be between 6 and 36, got %d", fontSize)
+	}
+	layout.FontSize = float64(fontSize)
+
+	if layout.Header, err = utils.GetStringSetting(job.Settings, "header", ""); err != nil {
+		return layout, err
+	}
+	if layout.Footer, err = utils.GetStringSetting(job.Settings, "footer", ""); err != nil {
+		return layout, err
+	}
+	if layout.PageNumbers, err = utils.GetBoolSetting(job.Settings, "page_numbers", true); err != nil {
+		return layout, err
+	}
+
+	// Markdown sources render as Markdown unless told otherwise
+	isMarkdown := strings.EqualFold(job.SourceFormat, "md")
+	if layout.Markdown, err = utils.GetBoolSetting(job.Settings, "markdown", isMarkdown); err != nil {
+		return layout, err
+	}
+
+	return layout, nil
+}
+
+// loadPDFFonts embeds the UTF-8 TTFs from the font directory, falling back to core fonts when they are missing
+func (p *EnhancedDocumentProcessor) loadPDFFonts(pdf *gofpdf.Fpdf) pdfFonts {
+	fontDir := p.config.FontDir
+	if fontDir == "" {
+		fontDir = defaultFontDir
+	}
+
+	files := map[string]string{
+		"":  "DejaVuSans.ttf",
+		"B": "DejaVuSans-Bold.ttf",
+		"M": "DejaVuSansMono.ttf",
+	}
+	loaded := make(map[string][]byte, len(files))
+	for style, name := range files {
+		data, err := os.ReadFile(filepath.Join(fontDir, name))
+		if err != nil {
+			// Core fonts only cover cp1252, so non-Latin text degrades but the job still completes
+			return pdfFonts{
+				Regular:   "Helvetica",
+				Bold:      "Helvetica",
+				Mono:      "Courier",
+				Bullet:    "-",
+				translate: pdf.UnicodeTranslatorFromDescriptor(""),
+			}
+		}
+		loaded[style] = data
+	}
+
+	pdf.AddUTF8FontFromBytes("DejaVu", "", loaded[""])
+	pdf.AddUTF8FontFromBytes("DejaVu", "B", loaded["B"])
+	pdf.AddUTF8FontFromBytes("DejaVuMono", "", loaded["M"])
+
+	return pdfFonts{
+		Regular:   "DejaVu",
+		Bold:      "DejaVu",
+		Mono:      "DejaVuMono",
+		Bullet:    "•",
+		translate: func(s string) string { return s },
+	}
+}
+
+func (p *EnhancedDocumentProcessor) convertTextToPDF(input, output string, job *models.ProcessingJob) (string, error) {
+	content, err := os.ReadFile(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to read text file: %w", err)
+	}
+
+	layout, err := p.getPDFLayout(job)
+	if err != nil {
+		return "", err
+	}
+
+	pdf := gofpdf.New(layout.Orientation, "mm", layout.PageSize, "")
+	fonts := p.loadPDFFonts(pdf)
+
+	pdf.SetMargins(layout.Margin, layout.Margin, layout.Margin)
+	pdf.SetAutoPageBreak(true, layout.Margin)
+	pdf.AliasNbPages("{nb}")
+	p.setPDFHeaderFooter(pdf, layout, fonts)
+
+	pdf.AddPage()
+	pdf.SetFont(fonts.Regular, "", layout.FontSize)
+
+	text := strings.ReplaceAll(strings.ReplaceAll(string(content), "\r\n", "\n"), "\t", "    ")
+	if layout.Markdown {
+		renderMarkdownPDF(pdf, text, layout, fonts)
+	} else {
+		renderPlainPDF(pdf, text, layout, fonts)
+	}
+
+	if err := pdf.OutputFileAndClose(output); err != nil {
+		return "", fmt.Errorf("failed to write PDF file: %w", err)
+	}
+
+	return output, nil
+}
+
+// setPDFHeaderFooter draws the optional header and the footer text / page number in the margins
+func (p *EnhancedDocumentProcessor) setPDFHeaderFooter(pdf *gofpdf.Fpdf, layout PDFLayout, fonts pdfFonts) {
+	decorationSize := layout.FontSize * 0.8
+
+	if layout.Header != "" {
+		pdf.SetHeaderFunc(func() {
+			pdf.SetY(layout.Margin / 2)
+			pdf.SetFont(fonts.Regular, "", decorationSize)
+			pdf.SetTextColor(110, 110, 110)
+			pdf.CellFormat(0, pointsToMM(decorationSize), fonts.translate(layout.Header), "", 0, "C", false, 0, "")
+			pdf.SetTextColor(0, 0, 0)
+			pdf.SetY(layout.Margin)
+		})
+	}
+
+	if layout.Footer != "" || layout.PageNumbers {
+		pdf.SetFooterFunc(func() {
+			pdf.SetY(-layout.Margin/2 - pointsToMM(decorationSize))
+			pdf.SetFont(fonts.Regular, "", decorationSize)
+			pdf.SetTextColor(110, 110, 110)
+			if layout.Footer != "" {
+				pdf.CellFormat(0, pointsToMM(decorationSize), fonts.translate(layout.Footer), "", 0, "L", false, 0, "")
+				pdf.SetX(layout.Margin)
+			}
+			if layout.PageNumbers {
+				pdf.CellFormat(0, pointsToMM(decorationSize), fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+			}
+			pdf.SetTextColor(0, 0, 0)
+		})
+	}
+}
+
+// renderPlainPDF writes each line as a wrapped paragraph, letting gofpdf break pages
+func renderPlainPDF(pdf *gofpdf.Fpdf, text string, layout PDFLayout, fonts pdfFonts) {
+	lineHeight := pointsToMM(layout.FontSize) * 1.4
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			pdf.Ln(lineHeight)
+			continue
+		}
+		pdf.MultiCell(0, lineHeight, fonts.translate(line), "", "L", false)
+	}
+}
+
+// renderMarkdownPDF handles the block-level Markdown we care about: headings, lists, code fences, quotes and rules
+func renderMarkdownPDF(pdf *gofpdf.Fpdf, text string, layout PDFLayout, fonts pdfFonts) {
+	left, _, right, _ := pdf.GetMargins()
+	pageWidth, _ := pdf.GetPageSize()
+	lineHeight := pointsToMM(layout.FontSize) * 1.4
+	inCode := false
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			pdf.Ln(lineHeight / 3)
+			continue
+		}
+
+		if inCode {
+			codeSize := layout.FontSize * 0.9
+			pdf.SetFont(fonts.Mono, "", codeSize)
+			pdf.SetFillColor(244, 244, 244)
+			pdf.MultiCell(0, pointsToMM(codeSize)*1.35, fonts.translate(" "+line), "", "L", true)
+			pdf.SetFont(fonts.Regular, "", layout.FontSize)
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			pdf.Ln(lineHeight / 2)
+			continue
+		}
+
+		if m := mdHeadingPattern.FindStringSubmatch(line); m != nil {
+			level := len(m[1])
+			size := layout.FontSize * mdHeadingScale[level-1]
+			title := stripInlineMarkdown(m[2])
+
+			pdf.Ln(lineHeight / 2)
+			pdf.Bookmark(fonts.translate(title), level-1, -1)
+			pdf.SetFont(fonts.Bold, "B", size)
+			pdf.MultiCell(0, pointsToMM(size)*1.3, fonts.translate(title), "", "L", false)
+			pdf.SetFont(fonts.Regular, "", layout.FontSize)
+			pdf.Ln(lineHeight / 4)
+			continue
+		}
+
+		if mdRulePattern.MatchString(line) {
+			y := pdf.GetY() + lineHeight/2
+			pdf.SetDrawColor(180, 180, 180)
+			pdf.Line(left, y, pageWidth-right, y)
+			pdf.Ln(lineHeight)
+			continue
+		}
+
+		if m := mdListPattern.FindStringSubmatch(line); m != nil {
+			indent := left + 6 + float64(len(m[1])/2)*6
+			marker := m[2]
+			if !strings.ContainsAny(marker[len(marker)-1:], ".)") {
+				marker = fonts.Bullet
+			}
+			markerWidth := 6.0
+
+			pdf.SetX(indent)
+			pdf.CellFormat(markerWidth, lineHeight, fonts.translate(marker), "", 0, "L", false, 0, "")
+			// Move the left margin so wrapped lines hang under the item text
+			pdf.SetLeftMargin(indent + markerWidth)
+			pdf.MultiCell(0, lineHeight, fonts.translate(stripInlineMarkdown(m[3])), "", "L", false)
+			pdf.SetLeftMargin(left)
+			continue
+		}
+
+		if strings.HasPrefix(strings.TrimSpace(line), ">") {
+			quote := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), ">"))
+			pdf.SetLeftMargin(left + 8)
+			pdf.SetX(left + 8)
+			pdf.SetTextColor(90, 90, 90)
+			pdf.MultiCell(0, lineHeight, fonts.translate(stripInlineMarkdown(quote)), "", "L", false)
+			pdf.SetTextColor(0, 0, 0)
+			pdf.SetLeftMargin(left)
+			continue
+		}
+
+		pdf.MultiCell(0, lineHeight, fonts.translate(stripInlineMarkdown(line)), "", "L", false)
+	}
+}
+
+// stripInlineMarkdown drops emphasis and code markers and shows links as "text (url)"
+func stripInlineMarkdown(s string) string {
+	s = mdLinkPattern.ReplaceAllString(s, "$1 ($2)")
+	s = mdBoldPattern.ReplaceAllString(s, "$2")
+	return mdCodePattern.ReplaceAllString(s, "$1")
+}
+
+// pointsToMM converts a font size in points to millimetres
+func pointsToMM(points float64) float64 {
+	return points * 25.4 / 72
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/qoal/file-processor/config"
 	"github.com/qoal/file-processor/models"
@@ -12,12 +13,14 @@ import (
 )
 
 type EnhancedDocumentProcessor struct {
-	config *config.Config
+	config   *config.Config
+	executor *utils.SecureCommandExecutor
 }
 
 func NewEnhancedDocumentProcessor(cfg *config.Config) *EnhancedDocumentProcessor {
 	return &EnhancedDocumentProcessor{
-		config: cfg,
+		config:   cfg,
+		executor: utils.NewSecureCommandExecutor(10 * time.Minute),
 	}
 }
 
@@ -58,8 +61,45 @@ func (p *EnhancedDocumentProcessor) ProcessDocument(job *models.ProcessingJob) e
 	return nil
 }
 
+// MergeDocuments concatenates the job's ordered PDF inputs into a single PDF
+func (p *EnhancedDocumentProcessor) MergeDocuments(job *models.ProcessingJob) error {
+	job.Status = "processing"
+	job.Progress = 10
+
+	if len(job.InputPaths) < 2 {
+		return fmt.Errorf("merge needs at least 2 input files, got %d", len(job.InputPaths))
+	}
+
+	for i, input := range job.InputPaths {
+		if !strings.EqualFold(filepath.Ext(input), ".pdf") {
+			return fmt.Errorf("input %d is not a PDF: %s", i+1, filepath.Base(input))
+		}
+		if err := p.validateDocument(input); err != nil {
+			return fmt.Errorf("document validation failed for input %d: %w", i+1, err)
+		}
+	}
+
+	job.Progress = 40
+
+	outputFile, err := p.mergePDFs(job.InputPaths, filepath.Join(p.config.OutputDir, job.JobID+"_output.pdf"), job)
+	if err != nil {
+		return fmt.Errorf("document merge failed: %w", err)
+	}
+
+	job.OutputPath = outputFile
+	job.Status = "completed"
+	job.Progress = 100
+
+	return nil
+}
+
 func (p *EnhancedDocumentProcessor) executeDocumentConversion(inputFile string, job *models.ProcessingJob) (string, error) {
-	ext, err := utils.GetDocumentExtension(job.TargetFormat)
+	getExtension := utils.GetDocumentExtension
+	if strings.EqualFold(job.TargetFormat, "zip") {
+		// Bulk spreadsheet export packs one CSV per sheet
+		getExtension = utils.GetArchiveExtension
+	}
+	ext, err := getExtension(job.TargetFormat)
 	if err != nil {
 		return "", fmt.Errorf("failed to get target extension: %w", err)
 	}
@@ -67,17 +107,23 @@ func (p *EnhancedDocumentProcessor) executeDocumentConversion(inputFile string,
 
 	conversionType := strings.ToUpper(job.SourceFormat) + "_TO_" + strings.ToUpper(job.TargetFormat)
 	switch conversionType {
-	case "TEXT_TO_PDF":
+	case "TXT_TO_PDF", "TEXT_TO_PDF", "MD_TO_PDF":
 		return p.convertTextToPDF(inputFile, outputFile, job)
-	case "DOCX_TO_TEXT":
+	case "DOCX_TO_TXT", "DOCX_TO_TEXT", "DOCX_TO_MD", "DOCX_TO_HTML":
 		return p.convertDocxToText(inputFile, outputFile, job)
 	case "TEXT_TO_DOCX":
 		return p.convertTextToDocx(inputFile, outputFile, job)
 	case "XLSX_TO_CSV":
 		return p.convertXlsxToCSV(inputFile, outputFile, job)
+	case "XLSX_TO_ZIP":
+		return p.convertXlsxToZip(inputFile, outputFile, job)
 	case "CSV_TO_XLSX":
 		return p.convertCSVToXlsx(inputFile, outputFile, job)
 	default:
+		// Office formats without a native converter go through headless LibreOffice
+		if CanConvertWithOffice(job.SourceFormat, job.TargetFormat) {
+			return p.convertWithLibreOffice(inputFile, outputFile, job)
+		}
 		return "", fmt.Errorf("unsupported document conversion: %s", conversionType)
 	}
 }
@@ -0,0 +1,670 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/qoal/file-processor/models"
+	"github.com/qoal/file-processor/utils"
+	"github.com/unidoc/unioffice/document"
+	"github.com/unidoc/unioffice/schema/soo/wml"
+)
+
+type docxBlockKind int
+
+const (
+	docxParagraph docxBlockKind = iota
+	docxHeading
+	docxListItem
+	docxTable
+)
+
+// docxInline is a run of text, a line break or a footnote reference inside a block
+type docxInline struct {
+	Text     string
+	Link     string
+	Footnote int // display number of a footnote reference, 0 for text
+	Break    bool
+}
+
+// docxBlock is one block-level element of a DOCX in reading order
+type docxBlock struct {
+	Kind    docxBlockKind
+	Level   int // heading level (1-6) or list nesting depth (0-based)
+	Ordered bool
+	Number  int    // position within an ordered list level
+	Marker  string // list marker as Word would render it, e.g. "1.", "a)" or "•"
+	Inline  []docxInline
+	Rows    [][][]docxInline
+}
+
+type docxFootnote struct {
+	Number int
+	Blocks []docxBlock
+}
+
+// docxContent is a DOCX flattened into the parts we emit, each in reading order
+type docxContent struct {
+	Headers   []docxBlock
+	Body      []docxBlock
+	Footnotes []docxFootnote
+	Footers   []docxBlock
+}
+
+var docxHeadingStylePattern = regexp.MustCompile(`(?i)^heading\s*([1-9])$`)
+
+// docxMaxListLevel is the deepest of Word's nine list levels; a paragraph's ilvl is clamped to 0..docxMaxListLevel
+// so a malformed document can't index outside, or grow without bound, the list counters
+const docxMaxListLevel = 8
+
+// docxExtractor walks the WordprocessingML tree directly so paragraphs and tables keep their order
+type docxExtractor struct {
+	doc           *document.Document
+	listCounters  map[int64][]int
+	footnoteOrder map[int64]int
+}
+
+func extractDocx(doc *document.Document, includeHeaders bool) *docxContent {
+	e := &docxExtractor{
+		doc:           doc,
+		listCounters:  make(map[int64][]int),
+		footnoteOrder: make(map[int64]int),
+	}
+
+	content := &docxContent{}
+	if body := doc.X().Body; body != nil {
+		content.Body = e.blockLevel(body.EG_BlockLevelElts)
+	}
+
+	if includeHeaders {
+		for _, header := range doc.Headers() {
+			content.Headers = append(content.Headers, e.contentBlocks(header.X().EG_ContentBlockContent)...)
+		}
+		for _, footer := range doc.Footers() {
+			content.Footers = append(content.Footers, e.contentBlocks(footer.X().EG_ContentBlockContent)...)
+		}
+	}
+
+	// Footnotes are numbered by first reference in the body, not by their XML id
+	// Footnotes() panics on a document without a footnotes part
+	notes := make(map[int64]*wml.CT_FtnEdn)
+	if doc.HasFootnotes() {
+		for _, footnote := range doc.Footnotes() {
+			notes[footnote.X().IdAttr] = footnote.X()
+		}
+	}
+	ordered := make([]int64, len(e.footnoteOrder))
+	for id, number := range e.footnoteOrder {
+		ordered[number-1] = id
+	}
+	for _, id := range ordered {
+		note, exists := notes[id]
+		if !exists {
+			continue
+		}
+		content.Footnotes = append(content.Footnotes, docxFootnote{
+			Number: e.footnoteOrder[id],
+			Blocks: e.blockLevel(note.EG_BlockLevelElts),
+		})
+	}
+
+	return content
+}
+
+func (e *docxExtractor) blockLevel(elts []*wml.EG_BlockLevelElts) []docxBlock {
+	var blocks []docxBlock
+	for _, elt := range elts {
+		blocks = append(blocks, e.contentBlocks(elt.EG_ContentBlockContent)...)
+	}
+	return blocks
+}
+
+func (e *docxExtractor) contentBlocks(contents []*wml.EG_ContentBlockContent) []docxBlock {
+	var blocks []docxBlock
+	for _, content := range contents {
+		for _, p := range content.P {
+			if block, ok := e.paragraph(p); ok {
+				blocks = append(blocks, block)
+			}
+		}
+		for _, tbl := range content.Tbl {
+			blocks = append(blocks, e.table(tbl))
+		}
+		// Content controls (e.g. generated tables of contents) wrap ordinary blocks
+		if content.Sdt != nil && content.Sdt.SdtContent != nil {
+			sdt := content.Sdt.SdtContent
+			blocks = append(blocks, e.contentBlocks([]*wml.EG_ContentBlockContent{{P: sdt.P, Tbl: sdt.Tbl, Sdt: sdt.Sdt}})...)
+		}
+	}
+	return blocks
+}
+
+func (e *docxExtractor) paragraph(p *wml.CT_P) (docxBlock, bool) {
+	block := docxBlock{Kind: docxParagraph, Inline: e.inlines(p.EG_PContent)}
+
+	if p.PPr != nil && p.PPr.PStyle != nil {
+		if level := e.headingLevel(p.PPr.PStyle.ValAttr); level > 0 {
+			block.Kind = docxHeading
+			block.Level = level
+		}
+	}
+
+	if block.Kind == docxParagraph && p.PPr != nil && p.PPr.NumPr != nil && p.PPr.NumPr.NumId != nil {
+		level := int64(0)
+		if p.PPr.NumPr.Ilvl != nil {
+			level = min(max(p.PPr.NumPr.Ilvl.ValAttr, 0), docxMaxListLevel)
+		}
+		// numId 0 explicitly removes numbering inherited from a style
+		if numID := p.PPr.NumPr.NumId.ValAttr; numID != 0 {
+			e.listItem(&block, numID, level)
+		}
+	}
+
+	if block.Kind == docxParagraph && isBlankInline(block.Inline) {
+		return block, false
+	}
+	return block, true
+}
+
+// headingLevel maps Title and "heading N" styles (by id or display name) to a level
+func (e *docxExtractor) headingLevel(styleID string) int {
+	names := []string{styleID}
+	if style := e.doc.GetStyleByID(styleID); style.X() != nil {
+		names = append(names, style.Name())
+	}
+
+	for _, name := range names {
+		if strings.EqualFold(name, "title") {
+			return 1
+		}
+		if m := docxHeadingStylePattern.FindStringSubmatch(name); m != nil {
+			level, _ := strconv.Atoi(m[1])
+			if level > 6 {
+				level = 6
+			}
+			return level
+		}
+	}
+	return 0
+}
+
+// listItem resolves the numbering definition and advances the per-list counters
+func (e *docxExtractor) listItem(block *docxBlock, numID, level int64) {
+	block.Kind = docxListItem
+	block.Level = int(level)
+
+	counters := e.listCounters[numID]
+	for int64(len(counters)) <= level {
+		counters = append(counters, 0)
+	}
+	counters[level]++
+	// Starting an item resets every deeper level, as Word does
+	for i := level + 1; i < int64(len(counters)); i++ {
+		counters[i] = 0
+	}
+	e.listCounters[numID] = counters
+	block.Number = counters[level]
+
+	lvl := e.doc.GetNumberingLevelByIds(numID, level).X()
+	if lvl == nil || lvl.NumFmt == nil || lvl.NumFmt.ValAttr == wml.ST_NumberFormatBullet {
+		block.Marker = "•"
+		return
+	}
+
+	block.Ordered = true
+	text := "%" + strconv.Itoa(int(level)+1) + "."
+	if lvl.LvlText != nil && lvl.LvlText.ValAttr != nil {
+		text = *lvl.LvlText.ValAttr
+	}
+	for i := len(counters) - 1; i >= 0; i-- {
+		format := wml.ST_NumberFormatDecimal
+		if levelDef := e.doc.GetNumberingLevelByIds(numID, int64(i)).X(); levelDef != nil && levelDef.NumFmt != nil {
+			format = levelDef.NumFmt.ValAttr
+		}
+		start := 1
+		if levelDef := e.doc.GetNumberingLevelByIds(numID, int64(i)).X(); levelDef != nil && levelDef.Start != nil {
+			start = int(levelDef.Start.ValAttr)
+		}
+		text = strings.ReplaceAll(text, "%"+strconv.Itoa(i+1), formatListNumber(counters[i]+start-1, format))
+	}
+	block.Marker = text
+}
+
+func (e *docxExtractor) inlines(contents []*wml.EG_PContent) []docxInline {
+	var out []docxInline
+	for _, content := range contents {
+		out = append(out, e.runContent(content.EG_ContentRunContent, "")...)
+		for _, field := range content.FldSimple {
+			out = append(out, e.inlines(field.EG_PContent)...)
+		}
+		if link := content.Hyperlink; link != nil {
+			target := ""
+			if link.IdAttr != nil {
+				target = safeLinkTarget(e.doc.GetTargetByRelId(*link.IdAttr))
+			} else if link.AnchorAttr != nil {
+				target = "#" + *link.AnchorAttr
+			}
+			out = append(out, e.runContent(link.EG_ContentRunContent, target)...)
+		}
+	}
+	return out
+}
+
+func (e *docxExtractor) runContent(contents []*wml.EG_ContentRunContent, link string) []docxInline {
+	var out []docxInline
+	for _, content := range contents {
+		if content.R == nil {
+			continue
+		}
+		for _, inner := range content.R.EG_RunInnerContent {
+			switch {
+			case inner.T != nil:
+				out = append(out, docxInline{Text: inner.T.Content, Link: link})
+			case inner.Tab != nil:
+				out = append(out, docxInline{Text: "\t", Link: link})
+			case inner.NoBreakHyphen != nil:
+				out = append(out, docxInline{Text: "-", Link: link})
+			case inner.Br != nil:
+				out = append(out, docxInline{Break: true})
+			case inner.FootnoteReference != nil:
+				id := inner.FootnoteReference.IdAttr
+				if _, seen := e.footnoteOrder[id]; !seen {
+					e.footnoteOrder[id] = len(e.footnoteOrder) + 1
+				}
+				out = append(out, docxInline{Footnote: e.footnoteOrder[id]})
+			}
+		}
+	}
+	return out
+}
+
+func (e *docxExtractor) table(tbl *wml.CT_Tbl) docxBlock {
+	block := docxBlock{Kind: docxTable}
+	for _, rowContent := range tbl.EG_ContentRowContent {
+		for _, tr := range rowContent.Tr {
+			var row [][]docxInline
+			for _, cellContent := range tr.EG_ContentCellContent {
+				for _, tc := range cellContent.Tc {
+					row = append(row, e.cellInline(tc))
+				}
+			}
+			block.Rows = append(block.Rows, row)
+		}
+	}
+	return block
+}
+
+// cellInline flattens a table cell's paragraphs (and any nested tables) into one line-broken run
+func (e *docxExtractor) cellInline(tc *wml.CT_Tc) []docxInline {
+	var out []docxInline
+	for _, block := range e.blockLevel(tc.EG_BlockLevelElts) {
+		if len(out) > 0 {
+			out = append(out, docxInline{Break: true})
+		}
+		if block.Kind == docxTable {
+			for _, row := range block.Rows {
+				for i, cell := range row {
+					if i > 0 {
+						out = append(out, docxInline{Text: " "})
+					}
+					out = append(out, cell...)
+				}
+			}
+			continue
+		}
+		if block.Marker != "" {
+			out = append(out, docxInline{Text: block.Marker + " "})
+		}
+		out = append(out, block.Inline...)
+	}
+	return out
+}
+
+// safeLinkTarget returns target if it's a web or mail address or an anchor, and "" otherwise, so a javascript: or
+// data: link in the document comes out as plain text rather than a live link
+func safeLinkTarget(target string) string {
+	target = strings.TrimSpace(target)
+	if strings.HasPrefix(target, "#") {
+		return target
+	}
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https", "mailto":
+		return target
+	}
+	return ""
+}
+
+func isBlankInline(inline []docxInline) bool {
+	for _, part := range inline {
+		if part.Footnote != 0 || strings.TrimSpace(part.Text) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// formatListNumber renders n in a Word list number format
+func formatListNumber(n int, format wml.ST_NumberFormat) string {
+	switch format {
+	case wml.ST_NumberFormatLowerLetter:
+		return strings.ToLower(toLetters(n))
+	case wml.ST_NumberFormatUpperLetter:
+		return toLetters(n)
+	case wml.ST_NumberFormatLowerRoman:
+		return strings.ToLower(toRoman(n))
+	case wml.ST_NumberFormatUpperRoman:
+		return toRoman(n)
+	case wml.ST_NumberFormatNone:
+		return ""
+	default:
+		return strconv.Itoa(n)
+	}
+}
+
+// toLetters renders 1..26 as A..Z, then AA, BB... as Word does
+func toLetters(n int) string {
+	if n < 1 {
+		return strconv.Itoa(n)
+	}
+	letter := string(rune('A' + (n-1)%26))
+	return strings.Repeat(letter, (n-1)/26+1)
+}
+
+func toRoman(n int) string {
+	if n < 1 || n > 3999 {
+		return strconv.Itoa(n)
+	}
+	values := []int{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+	symbols := []string{"M", "CM", "D", "CD", "C", "XC", "L", "XL", "X", "IX", "V", "IV", "I"}
+	var roman strings.Builder
+	for i, value := range values {
+		for n >= value {
+			roman.WriteString(symbols[i])
+			n -= value
+		}
+	}
+	return roman.String()
+}
+
+func (p *EnhancedDocumentProcessor) convertDocxToText(input, output string, job *models.ProcessingJob) (string, error) {
+	doc, err := document.Open(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to open DOCX: %w", err)
+	}
+	defer doc.Close()
+
+	includeHeaders, err := utils.GetBoolSetting(job.Settings, "include_headers", true)
+	if err != nil {
+		return "", err
+	}
+	content := extractDocx(doc, includeHeaders)
+
+	var rendered string
+	switch strings.ToLower(job.TargetFormat) {
+	case "md":
+		rendered = renderDocxMarkdown(content)
+	case "html":
+		title := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+		rendered = renderDocxHTML(content, title)
+	default:
+		rendered = renderDocxText(content)
+	}
+
+	if err := os.WriteFile(output, []byte(rendered), 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s file: %w", job.TargetFormat, err)
+	}
+
+	return output, nil
+}
+
+// renderDocxText lays the document out as plain text with tab-separated tables
+func renderDocxText(content *docxContent) string {
+	inline := func(parts []docxInline) string {
+		var b strings.Builder
+		for _, part := range parts {
+			switch {
+			case part.Break:
+				b.WriteString("\n")
+			case part.Footnote != 0:
+				fmt.Fprintf(&b, "[%d]", part.Footnote)
+			default:
+				b.WriteString(part.Text)
+			}
+		}
+		return b.String()
+	}
+
+	blocks := func(b *strings.Builder, list []docxBlock) {
+		for _, block := range list {
+			switch block.Kind {
+			case docxListItem:
+				fmt.Fprintf(b, "%s%s %s\n", strings.Repeat("    ", block.Level), block.Marker, inline(block.Inline))
+			case docxTable:
+				for _, row := range block.Rows {
+					cells := make([]string, len(row))
+					for i, cell := range row {
+						cells[i] = strings.ReplaceAll(inline(cell), "\n", " ")
+					}
+					b.WriteString(strings.Join(cells, "\t") + "\n")
+				}
+				b.WriteString("\n")
+			case docxHeading:
+				b.WriteString(inline(block.Inline) + "\n\n")
+			default:
+				b.WriteString(inline(block.Inline) + "\n")
+			}
+		}
+	}
+
+	var b strings.Builder
+	if len(content.Headers) > 0 {
+		blocks(&b, content.Headers)
+		b.WriteString("\n")
+	}
+	blocks(&b, content.Body)
+	if len(content.Footnotes) > 0 {
+		b.WriteString("\n")
+		for _, note := range content.Footnotes {
+			var body strings.Builder
+			blocks(&body, note.Blocks)
+			fmt.Fprintf(&b, "[%d] %s\n", note.Number, strings.TrimSpace(body.String()))
+		}
+	}
+	if len(content.Footers) > 0 {
+		b.WriteString("\n")
+		blocks(&b, content.Footers)
+	}
+	return b.String()
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", "&lt;", ">", "&gt;")
+
+// renderDocxMarkdown emits CommonMark with GFM tables and footnotes
+func renderDocxMarkdown(content *docxContent) string {
+	inline := func(parts []docxInline, lineBreak string) string {
+		var b strings.Builder
+		for i := 0; i < len(parts); i++ {
+			part := parts[i]
+			switch {
+			case part.Break:
+				b.WriteString(lineBreak)
+			case part.Footnote != 0:
+				fmt.Fprintf(&b, "[^%d]", part.Footnote)
+			case part.Link != "":
+				// Merge consecutive runs of the same hyperlink into one link
+				var text strings.Builder
+				for ; i < len(parts) && parts[i].Link == part.Link && !parts[i].Break && parts[i].Footnote == 0; i++ {
+					text.WriteString(parts[i].Text)
+				}
+				i--
+				fmt.Fprintf(&b, "[%s](%s)", markdownEscaper.Replace(text.String()), part.Link)
+			default:
+				b.WriteString(markdownEscaper.Replace(part.Text))
+			}
+		}
+		return b.String()
+	}
+
+	blocks := func(b *strings.Builder, list []docxBlock) {
+		for i, block := range list {
+			switch block.Kind {
+			case docxHeading:
+				fmt.Fprintf(b, "%s %s\n\n", strings.Repeat("#", block.Level), inline(block.Inline, " "))
+			case docxListItem:
+				marker := "-"
+				if block.Ordered {
+					marker = strconv.Itoa(block.Number) + "."
+				}
+				fmt.Fprintf(b, "%s%s %s\n", strings.Repeat("    ", block.Level), marker, inline(block.Inline, " "))
+				if i+1 == len(list) || list[i+1].Kind != docxListItem {
+					b.WriteString("\n")
+				}
+			case docxTable:
+				width := 0
+				for _, row := range block.Rows {
+					if len(row) > width {
+						width = len(row)
+					}
+				}
+				for r, row := range block.Rows {
+					cells := make([]string, width)
+					for c, cell := range row {
+						cells[c] = strings.ReplaceAll(inline(cell, "<br>"), "|", `\|`)
+					}
+					b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+					// GFM needs a delimiter row; the first row doubles as the header
+					if r == 0 {
+						b.WriteString("|" + strings.Repeat(" --- |", width) + "\n")
+					}
+				}
+				b.WriteString("\n")
+			default:
+				b.WriteString(inline(block.Inline, "  \n") + "\n\n")
+			}
+		}
+	}
+
+	var b strings.Builder
+	if len(content.Headers) > 0 {
+		blocks(&b, content.Headers)
+		b.WriteString("---\n\n")
+	}
+	blocks(&b, content.Body)
+	for _, note := range content.Footnotes {
+		var body strings.Builder
+		blocks(&body, note.Blocks)
+		fmt.Fprintf(&b, "[^%d]: %s\n", note.Number, strings.TrimSpace(body.String()))
+	}
+	if len(content.Footers) > 0 {
+		b.WriteString("\n---\n\n")
+		blocks(&b, content.Footers)
+	}
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
+
+// renderDocxHTML emits a standalone HTML5 document with semantic header/footer and footnote sections
+func renderDocxHTML(content *docxContent, title string) string {
+	inline := func(parts []docxInline) string {
+		var b strings.Builder
+		for _, part := range parts {
+			switch {
+			case part.Break:
+				b.WriteString("<br>")
+			case part.Footnote != 0:
+				fmt.Fprintf(&b, `<sup id="fnref-%d"><a href="#fn-%d">%d</a></sup>`, part.Footnote, part.Footnote, part.Footnote)
+			case part.Link != "":
+				fmt.Fprintf(&b, `<a href="%s">%s</a>`, html.EscapeString(part.Link), html.EscapeString(part.Text))
+			default:
+				b.WriteString(html.EscapeString(part.Text))
+			}
+		}
+		return b.String()
+	}
+
+	blocks := func(b *strings.Builder, list []docxBlock) {
+		// openLists holds the tag of every list currently open, one per nesting level
+		var openLists []string
+		closeLists := func(depth int) {
+			for len(openLists) > depth {
+				fmt.Fprintf(b, "</li></%s>\n", openLists[len(openLists)-1])
+				openLists = openLists[:len(openLists)-1]
+			}
+		}
+
+		for _, block := range list {
+			if block.Kind != docxListItem {
+				closeLists(0)
+			}
+
+			switch block.Kind {
+			case docxHeading:
+				fmt.Fprintf(b, "<h%d>%s</h%d>\n", block.Level, inline(block.Inline), block.Level)
+			case docxListItem:
+				tag := "ul"
+				if block.Ordered {
+					tag = "ol"
+				}
+				closeLists(block.Level + 1)
+				if len(openLists) == block.Level+1 {
+					b.WriteString("</li>\n")
+				}
+				for len(openLists) <= block.Level {
+					fmt.Fprintf(b, "<%s>\n", tag)
+					openLists = append(openLists, tag)
+				}
+				fmt.Fprintf(b, "<li>%s", inline(block.Inline))
+			case docxTable:
+				b.WriteString("<table>\n")
+				for _, row := range block.Rows {
+					b.WriteString("<tr>")
+					for _, cell := range row {
+						fmt.Fprintf(b, "<td>%s</td>", inline(cell))
+					}
+					b.WriteString("</tr>\n")
+				}
+				b.WriteString("</table>\n")
+			default:
+				fmt.Fprintf(b, "<p>%s</p>\n", inline(block.Inline))
+			}
+		}
+		closeLists(0)
+	}
+
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n</head>\n<body>\n", html.EscapeString(title))
+	if len(content.Headers) > 0 {
+		b.WriteString("<header>\n")
+		blocks(&b, content.Headers)
+		b.WriteString("</header>\n")
+	}
+	b.WriteString("<main>\n")
+	blocks(&b, content.Body)
+	b.WriteString("</main>\n")
+	if len(content.Footnotes) > 0 {
+		b.WriteString("<section class=\"footnotes\">\n<ol>\n")
+		for _, note := range content.Footnotes {
+			var body strings.Builder
+			blocks(&body, note.Blocks)
+			fmt.Fprintf(&b, "<li id=\"fn-%d\">%s <a href=\"#fnref-%d\">↩</a></li>\n", note.Number, strings.TrimSpace(body.String()), note.Number)
+		}
+		b.WriteString("</ol>\n</section>\n")
+	}
+	if len(content.Footers) > 0 {
+		b.WriteString("<footer>\n")
+		blocks(&b, content.Footers)
+		b.WriteString("</footer>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/unidoc/unioffice/document"
+	"github.com/unidoc/unioffice/schema/soo/wml"
+)
+
+// docxText joins a block's text runs, marking footnote references as [n]
+func docxText(inline []docxInline) string {
+	var b strings.Builder
+	for _, part := range inline {
+		if part.Footnote != 0 {
+			fmt.Fprintf(&b, "[%d]", part.Footnote)
+		}
+		b.WriteString(part.Text)
+	}
+	return b.String()
+}
+
+// newDocxList defines a list whose every level uses format, marked like "1." with that level's own counter
+func newDocxList(doc *document.Document, format wml.ST_NumberFormat) document.NumberingDefinition {
+	def := doc.Numbering.AddDefinition()
+	for i := 0; i <= docxMaxListLevel; i++ {
+		level := def.AddLevel()
+		level.SetFormat(format)
+		level.SetText(fmt.Sprintf("%%%d.", i+1))
+	}
+	return def
+}
+
+func addDocxListItem(doc *document.Document, def document.NumberingDefinition, level int, text string) document.Paragraph {
+	p := doc.AddParagraph()
+	p.SetNumberingDefinition(def)
+	p.SetNumberingLevel(level)
+	p.AddRun().AddText(text)
+	return p
+}
+
+func TestExtractDocx(t *testing.T) {
+	doc := document.New()
+	defer doc.Close()
+
+	heading := doc.AddParagraph()
+	heading.SetStyle("Heading2")
+	heading.AddRun().AddText("Plan")
+
+	doc.AddParagraph().AddRun().AddText("Intro")
+	doc.AddParagraph().AddRun().AddText("   ")
+
+	list := newDocxList(doc, wml.ST_NumberFormatDecimal)
+	addDocxListItem(doc, list, 0, "first")
+	addDocxListItem(doc, list, 0, "second")
+	addDocxListItem(doc, list, 1, "nested")
+
+	table := doc.AddTable()
+	row := table.AddRow()
+	row.AddCell().AddParagraph().AddRun().AddText("a")
+	row.AddCell().AddParagraph().AddRun().AddText("b")
+
+	content := extractDocx(doc, false)
+
+	want := []struct {
+		kind   docxBlockKind
+		level  int
+		marker string
+		text   string
+	}{
+		{docxHeading, 2, "", "Plan"},
+		{docxParagraph, 0, "", "Intro"},
+		{docxListItem, 0, "1.", "first"},
+		{docxListItem, 0, "2.", "second"},
+		{docxListItem, 1, "1.", "nested"},
+		{docxTable, 0, "", ""},
+	}
+	if len(content.Body) != len(want) {
+		t.Fatalf("got %d blocks, want %d: %+v", len(content.Body), len(want), content.Body)
+	}
+	for i, w := range want {
+		block := content.Body[i]
+		if block.Kind != w.kind || block.Level != w.level || block.Marker != w.marker || docxText(block.Inline) != w.text {
+			t.Errorf("block %d = {%v %d %q %q}, want %+v", i, block.Kind, block.Level, block.Marker, docxText(block.Inline), w)
+		}
+	}
+	if rows := content.Body[5].Rows; len(rows) != 1 || len(rows[0]) != 2 || docxText(rows[0][1]) != "b" {
+		t.Errorf("table rows = %+v", rows)
+	}
+}
+
+func TestExtractDocxListLevelOutOfRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		ilvl  int64
+		level int
+	}{
+		{"negative", -1, 0},
+		{"past the last level", 9, docxMaxListLevel},
+		{"huge", 1 << 40, docxMaxListLevel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := document.New()
+			defer doc.Close()
+			list := newDocxList(doc, wml.ST_NumberFormatBullet)
+			p := addDocxListItem(doc, list, 0, "item")
+			p.X().PPr.NumPr.Ilvl.ValAttr = tt.ilvl
+
+			content := extractDocx(doc, false)
+			if len(content.Body) != 1 || content.Body[0].Kind != docxListItem || content.Body[0].Level != tt.level {
+				t.Errorf("blocks = %+v, want one list item at level %d", content.Body, tt.level)
+			}
+		})
+	}
+}
+
+func TestExtractDocxFootnotes(t *testing.T) {
+	doc := document.New()
+	defer doc.Close()
+
+	first := doc.AddParagraph()
+	first.AddRun().AddText("Claim")
+	first.AddFootnote("Source one")
+	second := doc.AddParagraph()
+	second.AddRun().AddText("Other")
+	second.AddFootnote("Source two")
+
+	content := extractDocx(doc, false)
+	if len(content.Body) != 2 || docxText(content.Body[1].Inline) != "Other[2]" {
+		t.Fatalf("body = %+v", content.Body)
+	}
+	if len(content.Footnotes) != 2 {
+		t.Fatalf("got %d footnotes, want 2", len(content.Footnotes))
+	}
+	for i, want := range []string{"Source one", "Source two"} {
+		note := content.Footnotes[i]
+		if note.Number != i+1 || len(note.Blocks) == 0 || !strings.Contains(docxText(note.Blocks[0].Inline), want) {
+			t.Errorf("footnote %d = %+v, want %q", i+1, note, want)
+		}
+	}
+
+	text := renderDocxText(content)
+	if !strings.Contains(text, "Claim[1]") || !strings.Contains(text, "[2] Source two") {
+		t.Errorf("renderDocxText() = %q", text)
+	}
+}
+
+func TestExtractDocxHyperlinks(t *testing.T) {
+	tests := []struct {
+		target string
+		link   string
+	}{
+		{"https://example.com/a?b=c", "https://example.com/a?b=c"},
+		{"http://example.com", "http://example.com"},
+		{"mailto:someone@example.com", "mailto:someone@example.com"},
+		{"javascript:alert(1)", ""},
+		{"JavaScript:alert(1)", ""},
+		{" javascript:alert(1)", ""},
+		{"data:text/html,<script>alert(1)</script>", ""},
+		{"vbscript:msgbox", ""},
+		{"java\tscript:alert(1)", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			doc := document.New()
+			defer doc.Close()
+			link := doc.AddParagraph().AddHyperLink()
+			link.SetTarget(tt.target)
+			link.AddRun().AddText("click")
+
+			content := extractDocx(doc, false)
+			if len(content.Body) != 1 || len(content.Body[0].Inline) != 1 {
+				t.Fatalf("body = %+v", content.Body)
+			}
+			if got := content.Body[0].Inline[0].Link; got != tt.link {
+				t.Errorf("link = %q, want %q", got, tt.link)
+			}
+
+			rendered := renderDocxHTML(content, "doc")
+			if tt.link == "" && strings.Contains(rendered, "<a href") {
+				t.Errorf("renderDocxHTML() kept the link: %s", rendered)
+			}
+			if tt.link != "" && !strings.Contains(rendered, `<a href="`) {
+				t.Errorf("renderDocxHTML() dropped the link: %s", rendered)
+			}
+		})
+	}
+}
+
+func TestSafeLinkTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"#section-2", "#section-2"},
+		{"https://example.com", "https://example.com"},
+		{"HTTPS://EXAMPLE.COM", "HTTPS://EXAMPLE.COM"},
+		{"mailto:a@b.c", "mailto:a@b.c"},
+		{"relative/path", ""},
+		{"file:///etc/passwd", ""},
+		{"javascript:void(0)", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := safeLinkTarget(tt.target); got != tt.want {
+			t.Errorf("safeLinkTarget(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestFormatListNumber(t *testing.T) {
+	tests := []struct {
+		n      int
+		format wml.ST_NumberFormat
+		want   string
+	}{
+		{3, wml.ST_NumberFormatDecimal, "3"},
+		{1, wml.ST_NumberFormatLowerLetter, "a"},
+		{27, wml.ST_NumberFormatUpperLetter, "AA"},
+		{4, wml.ST_NumberFormatLowerRoman, "iv"},
+		{1994, wml.ST_NumberFormatUpperRoman, "MCMXCIV"},
+		{0, wml.ST_NumberFormatUpperRoman, "0"},
+		{5, wml.ST_NumberFormatNone, ""},
+	}
+
+	for _, tt := range tests {
+		if got := formatListNumber(tt.n, tt.format); got != tt.want {
+			t.Errorf("formatListNumber(%d, %v) = %q, want %q", tt.n, tt.format, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"fmt"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/qoal/file-processor/models"
+	"github.com/qoal/file-processor/utils"
+)
+
+// animationFrameCount returns how many frames an image holds; formats without animation report 1
+func animationFrameCount(inputFile string) (int, error) {
+	switch strings.ToLower(filepath.Ext(inputFile)) {
+	case ".gif":
+		file, err := os.Open(inputFile)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open input file: %w", err)
+		}
+		defer file.Close()
+
+		g, err := gif.DecodeAll(file)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode GIF: %w", err)
+		}
+		return len(g.Image), nil
+	case ".webp":
+		return webpFrameCount(inputFile)
+	default:
+		return 1, nil
+	}
+}
+
+// webpFrameCount counts the ANMF frames of a WebP without decoding them
+func webpFrameCount(inputFile string) (int, error) {
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read input file: %w", err)
+	}
+
+	chunks := webpChunks(data)
+	if chunks == nil {
+		return 0, fmt.Errorf("not a WebP file")
+	}
+
+	frames := 0
+	for _, chunk := range chunks {
+		if chunk.fourCC == "ANMF" {
+			frames++
+		}
+	}
+	if frames == 0 {
+		return 1, nil
+	}
+	return frames, nil
+}
+
+// convertAnimation re-encodes an animated GIF or WebP frame-for-frame; ImageMagick carries delays and loop count over
+func (p *EnhancedImageProcessor) convertAnimation(input, output string, job *models.ProcessingJob) (string, error) {
+	target := strings.ToLower(job.TargetFormat)
+
+	// Frames may be partial updates over earlier ones; coalescing makes each a full canvas
+	args := []string{input, "-coalesce"}
+	switch target {
+	case "gif":
+		// Re-optimize back into partial frames with a shared palette to keep the GIF small
+		args = append(args, "-layers", "Optimize")
+	case "webp":
+		codecArgs, err := magickCodecArgs("webp", job.Settings)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, codecArgs...)
+	default:
+		return "", fmt.Errorf("animation cannot be written as %s", target)
+	}
+
+	policy, err := getMetadataPolicy(job.Settings)
+	if err != nil {
+		return "", err
+	}
+	if policy != MetadataKeep {
+		// ImageMagick carries profiles across by default; animations have no GPS-only removal
+		args = append(args, "-strip")
+	}
+
+	if _, exists := job.Settings["loop"]; exists {
+		loop, err := utils.GetIntSetting(job.Settings, "loop", 0)
+		if err != nil {
+			return "", err
+		}
+		if loop < 0 {
+			return "", fmt.Errorf("loop must be 0 (forever) or a positive count, got %d", loop)
+		}
+		args = append(args, "-loop", strconv.Itoa(loop))
+	}
+
+	args = append(args, target+":"+output)
+	if err := p.executor.ExecuteCommand("convert", args); err != nil {
+		return "", fmt.Errorf("ImageMagick animation conversion failed: %w", err)
+	}
+
+	return output, nil
+}
+
+// convertAnimationToVideo encodes an animated GIF as H.264 or VP9, keeping each frame's own delay
+func (p *EnhancedImageProcessor) convertAnimationToVideo(input, output string, job *models.ProcessingJob) (string, error) {
+	if !strings.EqualFold(job.SourceFormat, "gif") {
+		return "", fmt.Errorf("only GIF animations can be converted to video")
+	}
+
+	profile, err := GetVideoCodecProfile(job.TargetFormat)
+	if err != nil {
+		return "", err
+	}
+
+	var rateArgs []string
+	switch profile.VideoCodec {
+	case "libx264":
+		rateArgs = []string{"-crf", "23"}
+	case "libvpx-vp9":
+		// Constant quality mode needs the bitrate cap lifted
+		rateArgs = []string{"-crf", "32", "-b:v", "0"}
+	default:
+		return "", fmt.Errorf("animations can only be converted to H.264 or VP9 video, not %s", job.TargetFormat)
+	}
+
+	args := []string{
+		"-hide_banner", "-nostdin", "-loglevel", "error", "-y",
+		"-i", input,
+		"-map", "0:v:0",
+		// GIF frames carry individual delays; passing timestamps through preserves the timing
+		"-fps_mode", "passthrough",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-c:v", profile.VideoCodec,
+		"-pix_fmt", "yuv420p",
+	}
+	args = append(args, rateArgs...)
+	args = append(args, profile.ExtraArgs...)
+	args = append(args, "-an", output)
+
+	if err := p.executor.ExecuteCommand("ffmpeg", args); err != nil {
+		return "", fmt.Errorf("ffmpeg animation encode failed: %w", err)
+	}
+
+	return output, nil
+}
+
+// extractFrame renders frame index of an animation to a temporary PNG the still-image converters can read
+func (p *EnhancedImageProcessor) extractFrame(input string, index, frames int) (string, error) {
+	if index < 0 || index >= frames {
+		return "", fmt.Errorf("frame %d out of range (animation has %d frames)", index, frames)
+	}
+
+	tmp, err := os.CreateTemp(p.config.TempDir, "frame_*.png")
+	if err != nil {
+		return "", fmt.Errorf("failed to create frame file: %w", err)
+	}
+	tmp.Close()
+
+	// A frame only draws the region that changed, so composite everything up to it and keep the last
+	args := []string{fmt.Sprintf("%s[0-%d]", input, index), "-coalesce"}
+	if index > 0 {
+		args = append(args, "-delete", fmt.Sprintf("0-%d", index-1))
+	}
+	args = append(args, "png:"+tmp.Name())
+
+	if err := p.executor.ExecuteCommand("convert", args); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("ImageMagick frame extraction failed: %w", err)
+	}
+
+	return tmp.Name(), nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/qoal/file-processor/utils"
+)
+
+// magickFormats are the image formats the Go codecs can't fully handle, keyed by extension to the ImageMagick coder
+var magickFormats = map[string]string{
+	".webp": "webp",
+	".heic": "heic",
+	".heif": "heic",
+}
+
+// isMagickFormat reports whether an extension is encoded (and, for HEIC/HEIF, decoded) through ImageMagick
+func isMagickFormat(ext string) bool {
+	_, exists := magickFormats[strings.ToLower(ext)]
+	return exists
+}
+
+// decodeWithMagick converts the primary image of a file to PNG through ImageMagick and decodes that
+func (p *EnhancedImageProcessor) decodeWithMagick(inputFile string) (image.Image, error) {
+	tmp, err := os.CreateTemp(p.config.TempDir, "decode_*.png")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create intermediate file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	// [0] picks the primary image; HEIC containers often also carry thumbnails and depth maps
+	args := []string{inputFile + "[0]", "png:" + tmp.Name()}
+	if err := p.executor.ExecuteCommand("convert", args); err != nil {
+		return nil, fmt.Errorf("ImageMagick decode failed: %w", err)
+	}
+
+	file, err := os.Open(tmp.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open intermediate file: %w", err)
+	}
+	defer file.Close()
+
+	return png.Decode(file)
+}
+
+// encodeWithMagick writes img losslessly to a PNG intermediate and has ImageMagick encode it into outputFile
+func (p *EnhancedImageProcessor) encodeWithMagick(img image.Image, outputFile string, settings map[string]interface{}) error {
+	ext := strings.ToLower(filepath.Ext(outputFile))
+	coder, exists := magickFormats[ext]
+	if !exists {
+		return fmt.Errorf("unsupported output format: %s", ext)
+	}
+
+	codecArgs, err := magickCodecArgs(coder, settings)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(p.config.TempDir, "encode_*.png")
+	if err != nil {
+		return fmt.Errorf("failed to create intermediate file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(tmp, img); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write intermediate file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write intermediate file: %w", err)
+	}
+
+	args := append([]string{"png:" + tmp.Name()}, codecArgs...)
+	args = append(args, coder+":"+outputFile)
+	if err := p.executor.ExecuteCommand("convert", args); err != nil {
+		return fmt.Errorf("ImageMagick encode failed: %w", err)
+	}
+
+	return nil
+}
+
+// magickCodecArgs maps the quality, lossless and method settings onto libwebp/libheif options
+func magickCodecArgs(coder string, settings map[string]interface{}) ([]string, error) {
+	quality, err := utils.GetIntSetting(settings, "quality", 80)
+	if err != nil {
+		return nil, err
+	}
+	if quality < 1 || quality > 100 {
+		return nil, fmt.Errorf("quality must be between 1 and 100, got %d", quality)
+	}
+
+	lossless, err := utils.GetBoolSetting(settings, "lossless", false)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"-quality", strconv.Itoa(quality)}
+
+	switch coder {
+	case "webp":
+		// method trades encode time for size: 0 is fastest, 6 smallest
+		method, err := utils.GetIntSetting(settings, "method", 4)
+		if err != nil {
+			return nil, err
+		}
+		if method < 0 || method > 6 {
+			return nil, fmt.Errorf("webp method must be between 0 and 6, got %d", method)
+		}
+		args = append(args, "-define", "webp:method="+strconv.Itoa(method))
+		if lossless {
+			// In lossless mode quality sets compression effort instead of fidelity
+			args = append(args, "-define", "webp:lossless=true")
+		}
+	case "heic":
+		// ImageMagick switches libheif to lossless at quality 100
+		if lossless {
+			args = []string{"-quality", "100"}
+		}
+	}
+
+	return args, nil
+}
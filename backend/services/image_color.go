@@ -0,0 +1,262 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/qoal/file-processor/utils"
+)
+
+// defaultICCProfileGlob finds the profiles the ghostscript package ships, whose directory carries its version
+const defaultICCProfileGlob = "/usr/share/ghostscript/*/iccprofiles"
+
+// ColorOptions controls colour management for still image conversions
+type ColorOptions struct {
+	ToSRGB   bool   // convert ICC-tagged sources to sRGB instead of keeping their pixel values
+	EmbedICC bool   // embed the output's ICC profile where the format allows it
+	BitDepth int    // 16 keeps 16-bit samples when source and target both have them, 8 always reduces
+	Intent   string // ICC rendering intent for conversions
+}
+
+// sourceColor describes the colour model of a decoded source as far as conversion cares
+type sourceColor struct {
+	CMYK bool
+	Deep bool // more than 8 bits per sample
+}
+
+var renderingIntents = map[string]string{
+	"perceptual": "Perceptual",
+	"relative":   "Relative",
+	"saturation": "Saturation",
+	"absolute":   "Absolute",
+}
+
+func getColorOptions(settings map[string]interface{}) (ColorOptions, error) {
+	opts := ColorOptions{}
+
+	colorSpace, err := utils.GetStringSetting(settings, "color_space", "preserve")
+	if err != nil {
+		return opts, err
+	}
+	switch colorSpace {
+	case "preserve":
+	case "srgb":
+		opts.ToSRGB = true
+	default:
+		return opts, fmt.Errorf("unsupported color_space: %s (use preserve or srgb)", colorSpace)
+	}
+
+	iccProfile, err := utils.GetStringSetting(settings, "icc_profile", "embed")
+	if err != nil {
+		return opts, err
+	}
+	switch iccProfile {
+	case "embed":
+		opts.EmbedICC = true
+	case "strip":
+	default:
+		return opts, fmt.Errorf("unsupported icc_profile: %s (use embed or strip)", iccProfile)
+	}
+
+	if opts.BitDepth, err = utils.GetIntSetting(settings, "bit_depth", 16); err != nil {
+		return opts, err
+	}
+	if opts.BitDepth != 8 && opts.BitDepth != 16 {
+		return opts, fmt.Errorf("bit_depth must be 8 or 16, got %d", opts.BitDepth)
+	}
+
+	intent, err := utils.GetStringSetting(settings, "rendering_intent", "perceptual")
+	if err != nil {
+		return opts, err
+	}
+	if opts.Intent = renderingIntents[intent]; opts.Intent == "" {
+		return opts, fmt.Errorf("unsupported rendering_intent: %s", intent)
+	}
+
+	return opts, nil
+}
+
+// detectSourceColor reads the colour model and sample depth without decoding pixels
+func detectSourceColor(inputFile string, icc []byte) sourceColor {
+	src := sourceColor{CMYK: iccColorSpace(icc) == "CMYK"}
+
+	ext := strings.ToLower(filepath.Ext(inputFile))
+	if ext == ".tif" || ext == ".tiff" {
+		// x/image/tiff can't open CMYK files at all, so read the tags directly
+		if data, err := os.ReadFile(inputFile); err == nil {
+			src.CMYK = src.CMYK || tiffTagShort(data, 262) == 5
+			src.Deep = tiffTagShort(data, 258) > 8
+		}
+	}
+
+	if file, err := os.Open(inputFile); err == nil {
+		defer file.Close()
+		if config, _, err := image.DecodeConfig(file); err == nil {
+			switch config.ColorModel {
+			case color.CMYKModel:
+				src.CMYK = true
+			case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+				src.Deep = true
+			}
+		}
+	}
+
+	return src
+}
+
+// iccColorSpace returns the data colour space signature of an ICC profile, e.g. "RGB", "CMYK" or "GRAY"
+func iccColorSpace(icc []byte) string {
+	if len(icc) < 20 {
+		return ""
+	}
+	return strings.TrimSpace(string(icc[16:20]))
+}
+
+// isSRGBProfile spots sRGB profiles by their description; converting those would be a no-op
+func isSRGBProfile(icc []byte) bool {
+	return bytes.Contains(icc, []byte("sRGB"))
+}
+
+// supportsDeepColor reports whether our encoder for a target writes 16-bit samples
+func supportsDeepColor(target string) bool {
+	switch strings.ToLower(target) {
+	case "png", "tiff", "tif":
+		return true
+	default:
+		return false
+	}
+}
+
+// isDeepImage reports whether a decoded image holds 16-bit samples
+func isDeepImage(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
+	default:
+		return false
+	}
+}
+
+// iccProfilePath resolves a profile shipped in ICC_PROFILE_DIR or, by default, with ghostscript
+func (p *EnhancedImageProcessor) iccProfilePath(name string) (string, error) {
+	dir := p.config.ICCProfileDir
+	if dir == "" {
+		matches, _ := filepath.Glob(filepath.Join(defaultICCProfileGlob, name))
+		if len(matches) == 0 {
+			return "", fmt.Errorf("ICC profile %s not found; set ICC_PROFILE_DIR", name)
+		}
+		return matches[len(matches)-1], nil
+	}
+
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("ICC profile %s not found in %s", name, dir)
+	}
+	return path, nil
+}
+
+// convertToSRGB runs the source through LittleCMS (via ImageMagick) into sRGB and returns a temporary PNG.
+// Untagged CMYK is assumed to use the default press profile, which is what the stdlib's naive maths gets wrong.
+func (p *EnhancedImageProcessor) convertToSRGB(inputFile string, icc []byte, src sourceColor, opts ColorOptions) (string, error) {
+	srgb, err := p.iccProfilePath("srgb.icc")
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{inputFile + "[0]", "-intent", opts.Intent, "-black-point-compensation"}
+	if src.CMYK && len(icc) == 0 {
+		cmyk, err := p.iccProfilePath("default_cmyk.icc")
+		if err != nil {
+			return "", err
+		}
+		// The first profile is assigned, the second converts into sRGB
+		args = append(args, "-profile", cmyk)
+	}
+	args = append(args, "-profile", srgb)
+
+	depth := "8"
+	if src.Deep && opts.BitDepth == 16 {
+		depth = "16"
+	}
+	args = append(args, "-depth", depth)
+
+	tmp, err := os.CreateTemp(p.config.TempDir, "srgb_*.png")
+	if err != nil {
+		return "", fmt.Errorf("failed to create colour-managed file: %w", err)
+	}
+	tmp.Close()
+
+	args = append(args, "png:"+tmp.Name())
+	if err := p.executor.ExecuteCommand("convert", args); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("ICC conversion to sRGB failed: %w", err)
+	}
+
+	return tmp.Name(), nil
+}
+
+// orientPixels applies an EXIF orientation sample-for-sample, keeping 16-bit data that imaging would truncate
+func orientPixels(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA64(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// tiffTagShort returns the first SHORT value of a tag in IFD0, 0 when absent
+func tiffTagShort(data []byte, tag uint16) int {
+	order, ifd, ok := tiffIFD(data)
+	if !ok {
+		return 0
+	}
+	entry, ok := findIFDEntry(data, order, ifd, tag)
+	if !ok {
+		return 0
+	}
+	count := order.Uint32(data[entry+4:])
+	value := data[entry+8:]
+	// More than two SHORTs don't fit in the entry and sit behind an offset
+	if count > 2 {
+		offset := int(order.Uint32(value))
+		if offset < 0 || offset+2 > len(data) {
+			return 0
+		}
+		value = data[offset:]
+	}
+	return int(order.Uint16(value))
+}
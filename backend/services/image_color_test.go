@@ -0,0 +1,139 @@
+package services
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetColorOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     ColorOptions
+		wantErr  bool
+	}{
+		{"defaults", map[string]interface{}{}, ColorOptions{EmbedICC: true, BitDepth: 16, Intent: "Perceptual"}, false},
+		{"all set", map[string]interface{}{"color_space": "srgb", "icc_profile": "strip", "bit_depth": 8.0, "rendering_intent": "relative"}, ColorOptions{ToSRGB: true, BitDepth: 8, Intent: "Relative"}, false},
+		{"absolute intent", map[string]interface{}{"rendering_intent": "absolute"}, ColorOptions{EmbedICC: true, BitDepth: 16, Intent: "Absolute"}, false},
+		{"unknown color space", map[string]interface{}{"color_space": "cmyk"}, ColorOptions{}, true},
+		{"unknown icc profile", map[string]interface{}{"icc_profile": "keep"}, ColorOptions{}, true},
+		{"unsupported bit depth", map[string]interface{}{"bit_depth": 12.0}, ColorOptions{}, true},
+		{"bit depth of the wrong type", map[string]interface{}{"bit_depth": "16"}, ColorOptions{}, true},
+		{"unknown intent", map[string]interface{}{"rendering_intent": "vivid"}, ColorOptions{}, true},
+		{"intent is case sensitive", map[string]interface{}{"rendering_intent": "Perceptual"}, ColorOptions{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getColorOptions(tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getColorOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("getColorOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// iccProfileFor returns a stub ICC header whose data colour space is space
+func iccProfileFor(space string) []byte {
+	icc := make([]byte, 128)
+	copy(icc[16:20], space)
+	return icc
+}
+
+// colorTIFF is IFD0 with the given photometric interpretation and bits per sample for three samples,
+// which don't fit in the entry and are stored after the IFD
+func colorTIFF(photometric, bits uint32) []byte {
+	var extra []byte
+	for i := 0; i < 3; i++ {
+		extra = binary.LittleEndian.AppendUint16(extra, uint16(bits))
+	}
+	return buildTIFF([]tiffEntry{
+		{258, 3, 3, extraOffset(2)},
+		{262, 3, 1, photometric},
+	}, extra)
+}
+
+func TestDetectSourceColor(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	writePNG := func(name string, img image.Image) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	rect := image.Rect(0, 0, 2, 2)
+	plain := writePNG("plain.png", image.NewNRGBA(rect))
+	deep := writePNG("deep.png", image.NewNRGBA64(rect))
+	gray16 := writePNG("gray16.png", image.NewGray16(rect))
+
+	tests := []struct {
+		name  string
+		input string
+		icc   []byte
+		want  sourceColor
+	}{
+		{"8-bit PNG", plain, nil, sourceColor{}},
+		{"16-bit PNG", deep, nil, sourceColor{Deep: true}},
+		{"16-bit greyscale PNG", gray16, nil, sourceColor{Deep: true}},
+		{"RGB profile", plain, iccProfileFor("RGB "), sourceColor{}},
+		{"CMYK profile", plain, iccProfileFor("CMYK"), sourceColor{CMYK: true}},
+		{"truncated profile", plain, []byte("CMYK"), sourceColor{}},
+		{"CMYK TIFF", writeFile("cmyk.tif", colorTIFF(5, 8)), nil, sourceColor{CMYK: true}},
+		{"16-bit TIFF", writeFile("deep.TIFF", colorTIFF(2, 16)), nil, sourceColor{Deep: true}},
+		{"16-bit CMYK TIFF", writeFile("deep_cmyk.tiff", colorTIFF(5, 16)), nil, sourceColor{CMYK: true, Deep: true}},
+		{"8-bit RGB TIFF", writeFile("rgb.tif", colorTIFF(2, 8)), nil, sourceColor{}},
+		{"TIFF tags only read from TIFFs", writeFile("cmyk.png", colorTIFF(5, 16)), nil, sourceColor{}},
+		{"missing file", filepath.Join(dir, "missing.png"), nil, sourceColor{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectSourceColor(tt.input, tt.icc); got != tt.want {
+				t.Errorf("detectSourceColor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsDeepImage(t *testing.T) {
+	rect := image.Rect(0, 0, 1, 1)
+	tests := []struct {
+		img  image.Image
+		want bool
+	}{
+		{image.NewRGBA64(rect), true},
+		{image.NewNRGBA64(rect), true},
+		{image.NewGray16(rect), true},
+		{image.NewNRGBA(rect), false},
+		{image.NewCMYK(rect), false},
+		{image.NewUniform(color.White), false},
+	}
+
+	for _, tt := range tests {
+		if got := isDeepImage(tt.img); got != tt.want {
+			t.Errorf("isDeepImage(%T) = %v, want %v", tt.img, got, tt.want)
+		}
+	}
+}
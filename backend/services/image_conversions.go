@@ -10,7 +10,6 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/disintegration/imaging"
 	"github.com/qoal/file-processor/models"
 	"github.com/qoal/file-processor/utils"
 	"golang.org/x/image/bmp"
@@ -48,6 +47,9 @@ func (p *EnhancedImageProcessor) decodeImage(inputFile string) (image.Image, str
 	case ".webp":
 		img, err := webp.Decode(input)
 		return img, "webp", err
+	case ".heic", ".heif":
+		img, err := p.decodeWithMagick(inputFile)
+		return img, "heic", err
 	default:
 		// Try to decode with default image.Decode
 		img, format, err := image.Decode(input)
@@ -321,54 +323,38 @@ func (p *EnhancedImageProcessor) genericImageConversion(inputFile, outputFile st
 		return "", fmt.Errorf("failed to decode image: %w", err)
 	}
 
-	// Apply processing options if specified
-	if job.Settings != nil {
-		// Resize if specified
-		if width, ok := job.Settings["width"].(float64); ok {
-			if height, ok := job.Settings["height"].(float64); ok {
-				img = imaging.Resize(img, int(width), int(height), imaging.Lanczos)
-			}
-		}
+	if err := p.encodeImage(img, format, outputFile, job.Settings); err != nil {
+		return "", err
+	}
 
-		// Crop if specified
-		if cropWidth, ok := job.Settings["crop_width"].(float64); ok {
-			if cropHeight, ok := job.Settings["crop_height"].(float64); ok {
-				if cropX, ok := job.Settings["crop_x"].(float64); ok {
-					if cropY, ok := job.Settings["crop_y"].(float64); ok {
-						img = imaging.Crop(img, image.Rect(int(cropX), int(cropY), int(cropX+cropWidth), int(cropY+cropHeight)))
-					}
-				}
-			}
-		}
+	return outputFile, nil
+}
 
-		// Rotate if specified
-		if rotate, ok := job.Settings["rotate"].(float64); ok {
-			switch rotate {
-			case 90:
-				img = imaging.Rotate90(img)
-			case 180:
-				img = imaging.Rotate180(img)
-			case 270:
-				img = imaging.Rotate270(img)
-			}
+// encodeImage writes img in the format named by outputFile's extension, falling back to the source format
+func (p *EnhancedImageProcessor) encodeImage(img image.Image, format, outputFile string, settings map[string]interface{}) error {
+	// Determine output format from extension
+	outputExt := strings.ToLower(filepath.Ext(outputFile))
+
+	// WebP and HEIC/HEIF have no Go encoder; ImageMagick writes those
+	if isMagickFormat(outputExt) {
+		if err := p.encodeWithMagick(img, outputFile, settings); err != nil {
+			return fmt.Errorf("failed to encode image: %w", err)
 		}
+		return nil
 	}
 
 	// Create output file
 	output, err := os.Create(outputFile)
 	if err != nil {
-		return "", fmt.Errorf("failed to create output file: %w", err)
+		return fmt.Errorf("failed to create output file: %w", err)
 	}
 	defer output.Close()
 
-	// Determine output format from extension
-	outputExt := strings.ToLower(filepath.Ext(outputFile))
-
 	switch outputExt {
 	case ".jpg", ".jpeg":
 		quality := 90
-		if job.Settings != nil {
-			if q, ok := job.Settings["quality"].(float64); ok {
+		if settings != nil {
+			if q, ok := settings["quality"].(float64); ok {
 				quality = int(q)
 			}
 		}
@@ -388,16 +374,13 @@ func (p *EnhancedImageProcessor) genericImageConversion(inputFile, outputFile st
 		err = bmp.Encode(output, img)
 	case ".tiff", ".tif":
 		err = tiff.Encode(output, img, nil)
-	case ".webp":
-		// WebP encoding requires saving via imaging library
-		err = imaging.Save(img, outputFile)
 	default:
 		// Try to use the original format if possible
 		switch format {
 		case "jpeg":
 			quality := 90
-			if job.Settings != nil {
-				if q, ok := job.Settings["quality"].(float64); ok {
+			if settings != nil {
+				if q, ok := settings["quality"].(float64); ok {
 					quality = int(q)
 				}
 			}
@@ -409,12 +392,12 @@ func (p *EnhancedImageProcessor) genericImageConversion(inputFile, outputFile st
 			}
 			err = encoder.Encode(output, img)
 		default:
-			return "", fmt.Errorf("unsupported output format: %s", outputExt)
+			return fmt.Errorf("unsupported output format: %s", outputExt)
 		}
 	}
 	if err != nil {
-		return "", fmt.Errorf("failed to encode image: %w", err)
+		return fmt.Errorf("failed to encode image: %w", err)
 	}
 
-	return outputFile, nil
+	return nil
 }
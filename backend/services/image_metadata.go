@@ -0,0 +1,758 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/qoal/file-processor/utils"
+)
+
+// Metadata policies for the "metadata" setting
+const (
+	MetadataStrip    = "strip"
+	MetadataKeep     = "keep"
+	MetadataStripGPS = "strip_gps"
+)
+
+// imageMetadata holds the raw EXIF (a TIFF structure), ICC and XMP payloads of an image
+type imageMetadata struct {
+	EXIF        []byte
+	ICC         []byte
+	XMP         []byte
+	Orientation int // EXIF orientation 1-8, 0 when unknown
+}
+
+func (m *imageMetadata) empty() bool {
+	return len(m.EXIF) == 0 && len(m.ICC) == 0 && len(m.XMP) == 0
+}
+
+var (
+	exifHeader     = []byte("Exif\x00\x00")
+	xmpJPEGHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccJPEGHeader  = []byte("ICC_PROFILE\x00")
+	pngSignature   = []byte("\x89PNG\r\n\x1a\n")
+	xmpGPSPattern  = regexp.MustCompile(`(?s)\s*<exif:GPS[A-Za-z]+\b[^>]*?(?:/>|>.*?</exif:GPS[A-Za-z]+>)|\s+exif:GPS[A-Za-z]+="[^"]*"`)
+	xmpOrientAttr  = regexp.MustCompile(`tiff:Orientation="\d"`)
+	xmpOrientElems = regexp.MustCompile(`<tiff:Orientation>\d</tiff:Orientation>`)
+)
+
+// getMetadataPolicy reads the "metadata" setting; stripping stays the default so nothing leaks unasked
+func getMetadataPolicy(settings map[string]interface{}) (string, error) {
+	policy, err := utils.GetStringSetting(settings, "metadata", MetadataStrip)
+	if err != nil {
+		return "", err
+	}
+	switch policy {
+	case MetadataStrip, MetadataKeep, MetadataStripGPS:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unsupported metadata policy: %s (use strip, keep or strip_gps)", policy)
+	}
+}
+
+// readImageMetadata pulls EXIF, ICC and XMP out of the source container
+func (p *EnhancedImageProcessor) readImageMetadata(inputFile string) (*imageMetadata, error) {
+	ext := strings.ToLower(filepath.Ext(inputFile))
+	if ext == ".heic" || ext == ".heif" {
+		return p.readMagickMetadata(inputFile)
+	}
+
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input file: %w", err)
+	}
+
+	meta := &imageMetadata{}
+	switch ext {
+	case ".jpg", ".jpeg":
+		readJPEGMetadata(data, meta)
+	case ".png":
+		readPNGMetadata(data, meta)
+	case ".webp":
+		readWebPMetadata(data, meta)
+	case ".tif", ".tiff":
+		// The file is itself a TIFF structure; orientation, ICC and XMP live in its first IFD
+		meta.Orientation = exifOrientation(data)
+		meta.ICC = tiffTagBytes(data, 0x8773)
+		meta.XMP = tiffTagBytes(data, 0x02BC)
+	}
+
+	if len(meta.EXIF) > 0 && meta.Orientation == 0 {
+		meta.Orientation = exifOrientation(meta.EXIF)
+	}
+	return meta, nil
+}
+
+// readMagickMetadata extracts profiles from formats we don't parse ourselves; missing profiles are not errors
+func (p *EnhancedImageProcessor) readMagickMetadata(inputFile string) (*imageMetadata, error) {
+	meta := &imageMetadata{}
+	for _, profile := range []struct {
+		name string
+		dest *[]byte
+	}{
+		{"exif", &meta.EXIF},
+		{"icc", &meta.ICC},
+		{"xmp", &meta.XMP},
+	} {
+		tmp, err := os.CreateTemp(p.config.TempDir, "profile_*."+profile.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create profile file: %w", err)
+		}
+		tmp.Close()
+
+		if err := p.executor.ExecuteCommand("convert", []string{inputFile + "[0]", profile.name + ":" + tmp.Name()}); err == nil {
+			*profile.dest, _ = os.ReadFile(tmp.Name())
+		}
+		os.Remove(tmp.Name())
+	}
+	meta.EXIF = bytes.TrimPrefix(meta.EXIF, exifHeader)
+
+	// libheif applies the irot/imir transforms while decoding, so the pixels are already upright
+	if len(meta.EXIF) > 0 {
+		meta.EXIF = setExifOrientation(meta.EXIF, 1)
+	}
+	meta.Orientation = 1
+	return meta, nil
+}
+
+func readJPEGMetadata(data []byte, meta *imageMetadata) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return
+	}
+
+	iccChunks := make(map[byte][]byte)
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return
+		}
+		marker := data[pos+1]
+		// Start of scan: everything after is entropy-coded image data
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		payload := data[pos+4 : pos+2+length]
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, exifHeader):
+			meta.EXIF = append([]byte(nil), payload[len(exifHeader):]...)
+		case marker == 0xE1 && bytes.HasPrefix(payload, xmpJPEGHeader):
+			meta.XMP = append([]byte(nil), payload[len(xmpJPEGHeader):]...)
+		case marker == 0xE2 && bytes.HasPrefix(payload, iccJPEGHeader) && len(payload) > len(iccJPEGHeader)+2:
+			// ICC profiles over 64KB are split across numbered APP2 segments
+			seq := payload[len(iccJPEGHeader)]
+			iccChunks[seq] = payload[len(iccJPEGHeader)+2:]
+		}
+		pos += 2 + length
+	}
+
+	// Sequence numbers run 1-255; counting in a byte would wrap to 0 and could loop forever
+	for seq := 1; seq <= 255; seq++ {
+		chunk, exists := iccChunks[byte(seq)]
+		if !exists {
+			break
+		}
+		meta.ICC = append(meta.ICC, chunk...)
+	}
+}
+
+func readPNGMetadata(data []byte, meta *imageMetadata) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return
+	}
+
+	for pos := len(pngSignature); pos+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		if length < 0 || pos+12+length > len(data) || chunkType == "IEND" {
+			return
+		}
+		payload := data[pos+8 : pos+8+length]
+
+		switch chunkType {
+		case "eXIf":
+			meta.EXIF = append([]byte(nil), bytes.TrimPrefix(payload, exifHeader)...)
+		case "iCCP":
+			// profile name, NUL, compression method, zlib stream
+			if idx := bytes.IndexByte(payload, 0); idx != -1 && idx+2 <= len(payload) {
+				meta.ICC, _ = inflate(payload[idx+2:])
+			}
+		case "iTXt":
+			if xmp, ok := parsePNGXMP(payload); ok {
+				meta.XMP = xmp
+			}
+		}
+		pos += 12 + length
+	}
+}
+
+// parsePNGXMP returns the text of an iTXt chunk carrying the XML:com.adobe.xmp keyword
+func parsePNGXMP(payload []byte) ([]byte, bool) {
+	fields := bytes.SplitN(payload, []byte{0}, 2)
+	if len(fields) != 2 || string(fields[0]) != "XML:com.adobe.xmp" || len(fields[1]) < 2 {
+		return nil, false
+	}
+	compressed := fields[1][0] == 1
+	// Skip compression flag and method, then the language tag and translated keyword
+	rest := bytes.SplitN(fields[1][2:], []byte{0}, 3)
+	if len(rest) != 3 {
+		return nil, false
+	}
+	if compressed {
+		text, err := inflate(rest[2])
+		return text, err == nil
+	}
+	return append([]byte(nil), rest[2]...), true
+}
+
+func readWebPMetadata(data []byte, meta *imageMetadata) {
+	for _, chunk := range webpChunks(data) {
+		switch chunk.fourCC {
+		case "EXIF":
+			meta.EXIF = append([]byte(nil), bytes.TrimPrefix(chunk.payload, exifHeader)...)
+		case "ICCP":
+			meta.ICC = append([]byte(nil), chunk.payload...)
+		case "XMP ":
+			meta.XMP = append([]byte(nil), chunk.payload...)
+		}
+	}
+}
+
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// applyPolicy drops the EXIF and XMP the policy excludes and, once pixels are upright, resets the orientation tags.
+// The ICC profile is colour data rather than metadata and is left to the icc_profile setting.
+func (m *imageMetadata) applyPolicy(policy string, oriented bool) *imageMetadata {
+	if policy == MetadataStrip {
+		return &imageMetadata{ICC: m.ICC}
+	}
+
+	out := &imageMetadata{ICC: m.ICC, EXIF: m.EXIF, XMP: m.XMP}
+	if policy == MetadataStripGPS {
+		if len(out.EXIF) > 0 {
+			out.EXIF = stripExifGPS(out.EXIF)
+		}
+		if len(out.XMP) > 0 {
+			out.XMP = xmpGPSPattern.ReplaceAll(out.XMP, nil)
+		}
+	}
+	if oriented {
+		if len(out.EXIF) > 0 {
+			out.EXIF = setExifOrientation(out.EXIF, 1)
+		}
+		if len(out.XMP) > 0 {
+			out.XMP = xmpOrientAttr.ReplaceAll(out.XMP, []byte(`tiff:Orientation="1"`))
+			out.XMP = xmpOrientElems.ReplaceAll(out.XMP, []byte(`<tiff:Orientation>1</tiff:Orientation>`))
+		}
+	}
+	return out
+}
+
+// transformImage bakes an EXIF orientation into the pixels, runs the operations pipeline
+// and stores the result as a temporary PNG for the format converters
+func (p *EnhancedImageProcessor) transformImage(inputFile string, orientation int, ops []imageOperation, keepDeep bool) (string, error) {
+	img, _, err := p.decodeImage(inputFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	if img, err = renderImage(img, orientation, ops, keepDeep); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(p.config.TempDir, "transformed_*.png")
+	if err != nil {
+		return "", fmt.Errorf("failed to create transformed file: %w", err)
+	}
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(tmp, img); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write transformed image: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write transformed image: %w", err)
+	}
+
+	return tmp.Name(), nil
+}
+
+// renderImage turns decoded pixels upright and runs the operations pipeline over them
+func renderImage(img image.Image, orientation int, ops []imageOperation, keepDeep bool) (image.Image, error) {
+	if orientation < 1 || orientation > 8 {
+		return nil, fmt.Errorf("invalid EXIF orientation: %d", orientation)
+	}
+
+	switch {
+	case isDeepImage(img) && keepDeep && len(ops) == 0:
+		// imaging works on 8-bit NRGBA; a plain reorientation can keep all 16 bits
+		if orientation > 1 {
+			img = orientPixels(img, orientation)
+		}
+	case isDeepImage(img) && !keepDeep && orientation == 1:
+		img = imaging.Clone(img)
+	case orientation == 1:
+	case orientation == 2:
+		img = imaging.FlipH(img)
+	case orientation == 3:
+		img = imaging.Rotate180(img)
+	case orientation == 4:
+		img = imaging.FlipV(img)
+	case orientation == 5:
+		img = imaging.Transpose(img)
+	case orientation == 6:
+		img = imaging.Rotate270(img) // imaging rotates counter-clockwise
+	case orientation == 7:
+		img = imaging.Transverse(img)
+	case orientation == 8:
+		img = imaging.Rotate90(img)
+	}
+	return applyImageOperations(img, ops), nil
+}
+
+// writeImageMetadata embeds the metadata into a finished JPEG, PNG, WebP or TIFF output
+func (p *EnhancedImageProcessor) writeImageMetadata(outputFile string, meta *imageMetadata) error {
+	if meta.empty() {
+		return nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(outputFile))
+	if ext == ".tif" || ext == ".tiff" {
+		return p.writeMagickMetadata(outputFile, meta)
+	}
+
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to read output file: %w", err)
+	}
+
+	switch ext {
+	case ".jpg", ".jpeg":
+		data, err = embedJPEGMetadata(data, meta)
+	case ".png":
+		data, err = embedPNGMetadata(data, meta)
+	case ".webp":
+		data, err = embedWebPMetadata(data, meta)
+	default:
+		// Other containers have no standard place for these payloads
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(outputFile, data, 0644)
+}
+
+// writeMagickMetadata attaches profiles through ImageMagick, which rewrites TIFF losslessly
+func (p *EnhancedImageProcessor) writeMagickMetadata(outputFile string, meta *imageMetadata) error {
+	args := []string{outputFile}
+	for _, profile := range []struct {
+		ext  string
+		data []byte
+	}{
+		{"exif", meta.EXIF},
+		{"icc", meta.ICC},
+		{"xmp", meta.XMP},
+	} {
+		if len(profile.data) == 0 {
+			continue
+		}
+		tmp, err := os.CreateTemp(p.config.TempDir, "profile_*."+profile.ext)
+		if err != nil {
+			return fmt.Errorf("failed to create profile file: %w", err)
+		}
+		defer os.Remove(tmp.Name())
+
+		data := profile.data
+		if profile.ext == "exif" {
+			data = append(append([]byte(nil), exifHeader...), data...)
+		}
+		_, err = tmp.Write(data)
+		tmp.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write profile file: %w", err)
+		}
+		args = append(args, "-profile", tmp.Name())
+	}
+	args = append(args, outputFile)
+
+	if err := p.executor.ExecuteCommand("convert", args); err != nil {
+		return fmt.Errorf("ImageMagick metadata write failed: %w", err)
+	}
+	return nil
+}
+
+func embedJPEGMetadata(data []byte, meta *imageMetadata) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, fmt.Errorf("output is not a JPEG file")
+	}
+
+	var segments bytes.Buffer
+	writeSegment := func(marker byte, parts ...[]byte) {
+		length := 2
+		for _, part := range parts {
+			length += len(part)
+		}
+		segments.Write([]byte{0xFF, marker, byte(length >> 8), byte(length)})
+		for _, part := range parts {
+			segments.Write(part)
+		}
+	}
+
+	// A segment holds at most 65533 payload bytes; EXIF and XMP that don't fit can't be carried
+	if len(meta.EXIF) > 0 && len(exifHeader)+len(meta.EXIF) <= 65533 {
+		writeSegment(0xE1, exifHeader, meta.EXIF)
+	}
+	if len(meta.XMP) > 0 && len(xmpJPEGHeader)+len(meta.XMP) <= 65533 {
+		writeSegment(0xE1, xmpJPEGHeader, meta.XMP)
+	}
+	if len(meta.ICC) > 0 {
+		const chunkSize = 65533 - 14
+		count := (len(meta.ICC) + chunkSize - 1) / chunkSize
+		if count > 255 {
+			return nil, fmt.Errorf("ICC profile too large for JPEG: %d bytes", len(meta.ICC))
+		}
+		for i := 0; i < count; i++ {
+			end := (i + 1) * chunkSize
+			if end > len(meta.ICC) {
+				end = len(meta.ICC)
+			}
+			writeSegment(0xE2, iccJPEGHeader, []byte{byte(i + 1), byte(count)}, meta.ICC[i*chunkSize:end])
+		}
+	}
+
+	out := make([]byte, 0, len(data)+segments.Len())
+	out = append(out, data[:2]...)
+	out = append(out, segments.Bytes()...)
+	return append(out, data[2:]...), nil
+}
+
+func embedPNGMetadata(data []byte, meta *imageMetadata) ([]byte, error) {
+	// IHDR is always first and fixed at 13 bytes; the metadata chunks go straight after it
+	ihdrEnd := len(pngSignature) + 8 + 13 + 4
+	if !bytes.HasPrefix(data, pngSignature) || len(data) < ihdrEnd || string(data[len(pngSignature)+4:len(pngSignature)+8]) != "IHDR" {
+		return nil, fmt.Errorf("output is not a PNG file")
+	}
+
+	var chunks bytes.Buffer
+	writeChunk := func(chunkType string, payload []byte) {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(payload)))
+		chunks.Write(length[:])
+		crc := crc32.NewIEEE()
+		crc.Write([]byte(chunkType))
+		crc.Write(payload)
+		chunks.WriteString(chunkType)
+		chunks.Write(payload)
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+		chunks.Write(sum[:])
+	}
+
+	if len(meta.ICC) > 0 {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(meta.ICC)
+		zw.Close()
+		writeChunk("iCCP", append([]byte("ICC Profile\x00\x00"), compressed.Bytes()...))
+	}
+	if len(meta.EXIF) > 0 {
+		writeChunk("eXIf", meta.EXIF)
+	}
+	if len(meta.XMP) > 0 {
+		// keyword, NUL, uncompressed flag and method, empty language tag and translated keyword
+		writeChunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), meta.XMP...))
+	}
+
+	out := make([]byte, 0, len(data)+chunks.Len())
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunks.Bytes()...)
+	return append(out, data[ihdrEnd:]...), nil
+}
+
+type webpChunk struct {
+	fourCC  string
+	payload []byte
+}
+
+// webpChunks splits a WebP RIFF container into its top-level chunks
+func webpChunks(data []byte) []webpChunk {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil
+	}
+
+	var chunks []webpChunk
+	for pos := 12; pos+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if size < 0 || pos+8+size > len(data) {
+			break
+		}
+		chunks = append(chunks, webpChunk{fourCC: string(data[pos : pos+4]), payload: data[pos+8 : pos+8+size]})
+		pos += 8 + size + size&1
+	}
+	return chunks
+}
+
+// embedWebPMetadata upgrades a simple WebP to the extended (VP8X) layout and adds ICCP, EXIF and XMP chunks
+func embedWebPMetadata(data []byte, meta *imageMetadata) ([]byte, error) {
+	chunks := webpChunks(data)
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("output is not a WebP file")
+	}
+
+	var vp8x []byte
+	var body []webpChunk
+	for _, chunk := range chunks {
+		switch chunk.fourCC {
+		case "VP8X":
+			vp8x = append([]byte(nil), chunk.payload...)
+		case "ICCP", "EXIF", "XMP ":
+			// Replaced below
+		default:
+			body = append(body, chunk)
+		}
+	}
+
+	if vp8x == nil {
+		width, height, alpha, err := webpCanvas(chunks[0])
+		if err != nil {
+			return nil, err
+		}
+		vp8x = make([]byte, 10)
+		if alpha {
+			vp8x[0] |= 0x10
+		}
+		putUint24(vp8x[4:], width-1)
+		putUint24(vp8x[7:], height-1)
+	}
+	if len(vp8x) < 10 {
+		return nil, fmt.Errorf("invalid VP8X chunk")
+	}
+
+	vp8x[0] &^= 0x20 | 0x08 | 0x04
+	ordered := []webpChunk{{fourCC: "VP8X", payload: vp8x}}
+	if len(meta.ICC) > 0 {
+		vp8x[0] |= 0x20
+		ordered = append(ordered, webpChunk{fourCC: "ICCP", payload: meta.ICC})
+	}
+	ordered = append(ordered, body...)
+	if len(meta.EXIF) > 0 {
+		vp8x[0] |= 0x08
+		ordered = append(ordered, webpChunk{fourCC: "EXIF", payload: meta.EXIF})
+	}
+	if len(meta.XMP) > 0 {
+		vp8x[0] |= 0x04
+		ordered = append(ordered, webpChunk{fourCC: "XMP ", payload: meta.XMP})
+	}
+
+	var out bytes.Buffer
+	out.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	for _, chunk := range ordered {
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(chunk.payload)))
+		out.WriteString(chunk.fourCC)
+		out.Write(size[:])
+		out.Write(chunk.payload)
+		if len(chunk.payload)&1 == 1 {
+			out.WriteByte(0)
+		}
+	}
+	result := out.Bytes()
+	binary.LittleEndian.PutUint32(result[4:], uint32(len(result)-8))
+	return result, nil
+}
+
+// webpCanvas reads the dimensions (and, for lossless, the alpha hint) from a simple WebP's bitstream header
+func webpCanvas(chunk webpChunk) (width, height int, alpha bool, err error) {
+	p := chunk.payload
+	switch chunk.fourCC {
+	case "VP8 ":
+		if len(p) < 10 || p[3] != 0x9D || p[4] != 0x01 || p[5] != 0x2A {
+			return 0, 0, false, fmt.Errorf("invalid VP8 bitstream")
+		}
+		width = int(binary.LittleEndian.Uint16(p[6:]) & 0x3FFF)
+		height = int(binary.LittleEndian.Uint16(p[8:]) & 0x3FFF)
+		return width, height, false, nil
+	case "VP8L":
+		if len(p) < 5 || p[0] != 0x2F {
+			return 0, 0, false, fmt.Errorf("invalid VP8L bitstream")
+		}
+		bits := binary.LittleEndian.Uint32(p[1:])
+		width = int(bits&0x3FFF) + 1
+		height = int((bits>>14)&0x3FFF) + 1
+		alpha = (bits>>28)&1 == 1
+		return width, height, alpha, nil
+	default:
+		return 0, 0, false, fmt.Errorf("unexpected WebP chunk: %s", chunk.fourCC)
+	}
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+// tiffIFD locates the first IFD of a TIFF structure (EXIF payloads use the same layout)
+func tiffIFD(data []byte) (binary.ByteOrder, int, bool) {
+	if len(data) < 8 {
+		return nil, 0, false
+	}
+	var order binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, false
+	}
+	if order.Uint16(data[2:]) != 42 {
+		return nil, 0, false
+	}
+	offset := int(order.Uint32(data[4:]))
+	if offset < 8 || offset+2 > len(data) {
+		return nil, 0, false
+	}
+	return order, offset, true
+}
+
+// findIFDEntry returns the byte offset of the 12-byte entry for tag in the IFD at ifd
+func findIFDEntry(data []byte, order binary.ByteOrder, ifd int, tag uint16) (int, bool) {
+	count := int(order.Uint16(data[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(data) {
+			return 0, false
+		}
+		if order.Uint16(data[entry:]) == tag {
+			return entry, true
+		}
+	}
+	return 0, false
+}
+
+func exifOrientation(data []byte) int {
+	order, ifd, ok := tiffIFD(data)
+	if !ok {
+		return 0
+	}
+	entry, ok := findIFDEntry(data, order, ifd, 0x0112)
+	if !ok {
+		return 0
+	}
+	orientation := int(order.Uint16(data[entry+8:]))
+	if orientation < 1 || orientation > 8 {
+		return 0
+	}
+	return orientation
+}
+
+// setExifOrientation returns a copy of exif with the orientation tag rewritten in place
+func setExifOrientation(exif []byte, orientation int) []byte {
+	out := append([]byte(nil), exif...)
+	order, ifd, ok := tiffIFD(out)
+	if !ok {
+		return out
+	}
+	if entry, ok := findIFDEntry(out, order, ifd, 0x0112); ok {
+		order.PutUint16(out[entry+8:], uint16(orientation))
+	}
+	return out
+}
+
+// tiffTagBytes returns the raw value of a BYTE/UNDEFINED tag stored outside the IFD (ICC, XMP)
+func tiffTagBytes(data []byte, tag uint16) []byte {
+	order, ifd, ok := tiffIFD(data)
+	if !ok {
+		return nil
+	}
+	entry, ok := findIFDEntry(data, order, ifd, tag)
+	if !ok {
+		return nil
+	}
+	count := int(order.Uint32(data[entry+4:]))
+	if count <= 4 {
+		return append([]byte(nil), data[entry+8:entry+8+count]...)
+	}
+	offset := int(order.Uint32(data[entry+8:]))
+	if offset < 0 || count < 0 || offset+count > len(data) {
+		return nil
+	}
+	return append([]byte(nil), data[offset:offset+count]...)
+}
+
+// tiffTypeSizes is the byte size of each TIFF field type, indexed by type id
+var tiffTypeSizes = []int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+// stripExifGPS removes the GPS IFD pointer from IFD0 and zeroes the GPS IFD so no coordinates remain.
+// Everything is edited in place, which keeps every other offset in the structure valid.
+func stripExifGPS(exif []byte) []byte {
+	out := append([]byte(nil), exif...)
+	order, ifd, ok := tiffIFD(out)
+	if !ok {
+		return out
+	}
+	entry, ok := findIFDEntry(out, order, ifd, 0x8825)
+	if !ok {
+		return out
+	}
+	// IFD0's extent is read before anything is zeroed, since a malformed GPS pointer can point into IFD0 itself.
+	// An IFD0 cut short can't have the entry removed, so none of the EXIF is kept.
+	count := int(order.Uint16(out[ifd:]))
+	ifdEnd := ifd + 2 + count*12 + 4
+	if ifdEnd > len(out) || entry+12 > ifdEnd {
+		return nil
+	}
+	overlapsIFD0 := func(start, end int) bool {
+		return start < ifdEnd && end > ifd
+	}
+
+	if gps := int(order.Uint32(out[entry+8:])); gps > 0 && gps+2 <= len(out) {
+		gpsCount := int(order.Uint16(out[gps:]))
+		end := gps + 2 + gpsCount*12 + 4
+		if end <= len(out) && !overlapsIFD0(gps, end) {
+			for i := 0; i < gpsCount; i++ {
+				e := gps + 2 + i*12
+				fieldType := int(order.Uint16(out[e+2:]))
+				if fieldType <= 0 || fieldType >= len(tiffTypeSizes) {
+					continue
+				}
+				size := tiffTypeSizes[fieldType] * int(order.Uint32(out[e+4:]))
+				offset := int(order.Uint32(out[e+8:]))
+				if size > 4 && offset+size <= len(out) && !overlapsIFD0(offset, offset+size) {
+					clear(out[offset : offset+size])
+				}
+			}
+			clear(out[gps:end])
+		}
+	}
+
+	// Shift the following entries and the next-IFD offset up over the removed entry
+	copy(out[entry:], out[entry+12:ifdEnd])
+	clear(out[ifdEnd-12 : ifdEnd])
+	order.PutUint16(out[ifd:], uint16(count-1))
+	return out
+}
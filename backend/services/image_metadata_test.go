@@ -0,0 +1,441 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"testing"
+)
+
+type tiffEntry struct {
+	tag, fieldType uint16
+	count, value   uint32
+}
+
+// buildTIFF lays out a little-endian TIFF with IFD0 at offset 8, followed by extra bytes that entries
+// can point into at extraOffset(len(ifd0))
+func buildTIFF(ifd0 []tiffEntry, extra []byte) []byte {
+	out := []byte("II*\x00\x08\x00\x00\x00")
+	out = binary.LittleEndian.AppendUint16(out, uint16(len(ifd0)))
+	for _, e := range ifd0 {
+		out = binary.LittleEndian.AppendUint16(out, e.tag)
+		out = binary.LittleEndian.AppendUint16(out, e.fieldType)
+		out = binary.LittleEndian.AppendUint32(out, e.count)
+		out = binary.LittleEndian.AppendUint32(out, e.value)
+	}
+	out = binary.LittleEndian.AppendUint32(out, 0)
+	return append(out, extra...)
+}
+
+func extraOffset(entries int) uint32 {
+	return uint32(8 + 2 + entries*12 + 4)
+}
+
+// gpsTIFF is IFD0 with an orientation, a GPS pointer and a software tag, then a GPS IFD holding a
+// latitude whose three rationals are stored after it
+func gpsTIFF() []byte {
+	gps := extraOffset(3)
+	latitude := gps + 2 + 12 + 4
+	var extra []byte
+	extra = binary.LittleEndian.AppendUint16(extra, 1)
+	extra = binary.LittleEndian.AppendUint16(extra, 0x0002)
+	extra = binary.LittleEndian.AppendUint16(extra, 5)
+	extra = binary.LittleEndian.AppendUint32(extra, 3)
+	extra = binary.LittleEndian.AppendUint32(extra, latitude)
+	extra = binary.LittleEndian.AppendUint32(extra, 0)
+	for _, v := range []uint32{51, 1, 30, 1, 2637, 100} {
+		extra = binary.LittleEndian.AppendUint32(extra, v)
+	}
+	return buildTIFF([]tiffEntry{
+		{0x0112, 3, 1, 6},
+		{0x8825, 4, 1, gps},
+		{0x0131, 2, 4, 0x00636261}, // "abc\0"
+	}, extra)
+}
+
+func TestStripExifGPS(t *testing.T) {
+	withGPSAt := func(pointer uint32, extra []byte) []byte {
+		return buildTIFF([]tiffEntry{{0x0112, 3, 1, 6}, {0x8825, 4, 1, pointer}, {0x0131, 2, 4, 0x00636261}}, extra)
+	}
+	// A GPS IFD whose one entry's value points back into IFD0
+	intoIFD0 := binary.LittleEndian.AppendUint16(nil, 1)
+	intoIFD0 = binary.LittleEndian.AppendUint16(intoIFD0, 0x0002)
+	intoIFD0 = binary.LittleEndian.AppendUint16(intoIFD0, 5)
+	intoIFD0 = binary.LittleEndian.AppendUint32(intoIFD0, 3)
+	intoIFD0 = binary.LittleEndian.AppendUint32(intoIFD0, 8)
+	intoIFD0 = binary.LittleEndian.AppendUint32(intoIFD0, 0)
+
+	tests := []struct {
+		name string
+		exif []byte
+	}{
+		{"gps ifd", gpsTIFF()},
+		{"pointer to ifd0", withGPSAt(8, nil)},
+		{"pointer inside ifd0", withGPSAt(20, nil)},
+		{"pointer past the end", withGPSAt(1<<20, nil)},
+		{"gps ifd cut short", withGPSAt(extraOffset(3), []byte{9, 0, 1})},
+		{"gps value inside ifd0", withGPSAt(extraOffset(3), intoIFD0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := stripExifGPS(tt.exif)
+			if len(out) != len(tt.exif) {
+				t.Fatalf("length = %d, want %d", len(out), len(tt.exif))
+			}
+			order, ifd, ok := tiffIFD(out)
+			if !ok {
+				t.Fatal("stripped EXIF no longer parses")
+			}
+			if _, found := findIFDEntry(out, order, ifd, 0x8825); found {
+				t.Error("GPS pointer still present")
+			}
+			if got := exifOrientation(out); got != 6 {
+				t.Errorf("orientation = %d, want 6", got)
+			}
+			if entry, found := findIFDEntry(out, order, ifd, 0x0131); !found || string(out[entry+8:entry+11]) != "abc" {
+				t.Error("entry after the GPS pointer lost")
+			}
+		})
+	}
+}
+
+func TestStripExifGPSClearsCoordinates(t *testing.T) {
+	exif := gpsTIFF()
+	out := stripExifGPS(exif)
+	if !bytes.Equal(out[extraOffset(3):], make([]byte, len(exif)-int(extraOffset(3)))) {
+		t.Errorf("GPS IFD and values not zeroed: % x", out[extraOffset(3):])
+	}
+	if !bytes.Equal(exif, gpsTIFF()) {
+		t.Error("stripExifGPS() modified its input")
+	}
+}
+
+func TestStripExifGPSMalformed(t *testing.T) {
+	// IFD0 claims more entries than it holds, so the GPS entry can't be removed safely
+	truncated := gpsTIFF()[:int(extraOffset(3))-4]
+	truncated[8] = 3
+
+	tests := []struct {
+		name string
+		exif []byte
+		want []byte
+	}{
+		{"no gps", buildTIFF([]tiffEntry{{0x0112, 3, 1, 1}}, nil), buildTIFF([]tiffEntry{{0x0112, 3, 1, 1}}, nil)},
+		{"not tiff", []byte("not a tiff header"), []byte("not a tiff header")},
+		{"empty", nil, []byte{}},
+		{"ifd0 cut short", truncated, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripExifGPS(tt.exif); !bytes.Equal(got, tt.want) {
+				t.Errorf("stripExifGPS() = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		exif []byte
+		want int
+	}{
+		{"little endian", buildTIFF([]tiffEntry{{0x0112, 3, 1, 8}}, nil), 8},
+		{"big endian", []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x03\x00\x00\x00\x00\x00\x00"), 3},
+		{"out of range", buildTIFF([]tiffEntry{{0x0112, 3, 1, 9}}, nil), 0},
+		{"missing", buildTIFF([]tiffEntry{{0x0131, 2, 1, 0}}, nil), 0},
+		{"ifd offset past the end", []byte("II*\x00\xff\x00\x00\x00"), 0},
+		{"entries cut short", buildTIFF([]tiffEntry{{0x0131, 2, 1, 0}, {0x0112, 3, 1, 6}}, nil)[:24], 0},
+		{"bad magic", []byte("II+\x00\x08\x00\x00\x00\x00\x00"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exifOrientation(tt.exif); got != tt.want {
+				t.Errorf("exifOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetExifOrientation(t *testing.T) {
+	exif := buildTIFF([]tiffEntry{{0x0112, 3, 1, 6}}, nil)
+	out := setExifOrientation(exif, 1)
+	if exifOrientation(out) != 1 || exifOrientation(exif) != 6 {
+		t.Errorf("orientation = %d (input %d), want 1 (input 6)", exifOrientation(out), exifOrientation(exif))
+	}
+}
+
+func TestTiffTagBytes(t *testing.T) {
+	payload := []byte("<x:xmpmeta/>")
+	stored := buildTIFF([]tiffEntry{{0x02BC, 7, uint32(len(payload)), extraOffset(1)}}, payload)
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"stored after the ifd", stored, payload},
+		{"inline", buildTIFF([]tiffEntry{{0x02BC, 7, 3, 0x00636261}}, nil), []byte("abc")},
+		{"value past the end", stored[:len(stored)-1], nil},
+		{"missing", buildTIFF(nil, nil), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tiffTagBytes(tt.data, 0x02BC); !bytes.Equal(got, tt.want) {
+				t.Errorf("tiffTagBytes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func jpegSegment(marker byte, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	return append([]byte{0xFF, marker, byte((len(body) + 2) >> 8), byte(len(body) + 2)}, body...)
+}
+
+func TestReadJPEGMetadata(t *testing.T) {
+	exif := buildTIFF([]tiffEntry{{0x0112, 3, 1, 3}}, nil)
+	xmp := []byte("<x:xmpmeta/>")
+	soi := []byte{0xFF, 0xD8}
+	sos := []byte{0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xE1, 0x00}
+
+	// 256 ICC segments numbered 0-255; 0 isn't a valid sequence number and mustn't be reached
+	var everySeq [][]byte
+	for seq := 255; seq >= 0; seq-- {
+		everySeq = append(everySeq, jpegSegment(0xE2, iccJPEGHeader, []byte{byte(seq), 255, byte(seq)}))
+	}
+	wantEverySeq := make([]byte, 255)
+	for i := range wantEverySeq {
+		wantEverySeq[i] = byte(i + 1)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want imageMetadata
+	}{
+		{
+			"exif, xmp and split icc",
+			bytes.Join([][]byte{
+				soi,
+				jpegSegment(0xE2, iccJPEGHeader, []byte{2, 2}, []byte("second")),
+				jpegSegment(0xE1, exifHeader, exif),
+				jpegSegment(0xE1, xmpJPEGHeader, xmp),
+				jpegSegment(0xE2, iccJPEGHeader, []byte{1, 2}, []byte("first-")),
+				sos,
+			}, nil),
+			imageMetadata{EXIF: exif, XMP: xmp, ICC: []byte("first-second")},
+		},
+		{"icc missing a chunk", bytes.Join([][]byte{soi, jpegSegment(0xE2, iccJPEGHeader, []byte{2, 2}, []byte("x")), sos}, nil), imageMetadata{}},
+		{"every sequence number", bytes.Join(append(append([][]byte{soi}, everySeq...), sos), nil), imageMetadata{ICC: wantEverySeq}},
+		{"segment past the end", append(append([]byte{}, soi...), 0xFF, 0xE1, 0xFF, 0xFF, 'E', 'x'), imageMetadata{}},
+		{"segment length under 2", append(append([]byte{}, soi...), 0xFF, 0xE1, 0x00, 0x01), imageMetadata{}},
+		{"not a marker", append(append([]byte{}, soi...), 0x00, 0xE1, 0x00, 0x02), imageMetadata{}},
+		{"not a jpeg", []byte("GIF89a"), imageMetadata{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var meta imageMetadata
+			readJPEGMetadata(tt.data, &meta)
+			if !bytes.Equal(meta.EXIF, tt.want.EXIF) || !bytes.Equal(meta.XMP, tt.want.XMP) || !bytes.Equal(meta.ICC, tt.want.ICC) {
+				t.Errorf("readJPEGMetadata() = %+v, want %+v", meta, tt.want)
+			}
+		})
+	}
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testMetadata() *imageMetadata {
+	return &imageMetadata{
+		EXIF: buildTIFF([]tiffEntry{{0x0112, 3, 1, 6}}, nil),
+		ICC:  bytes.Repeat([]byte("icc"), 100),
+		XMP:  []byte(`<x:xmpmeta><rdf:Description exif:GPSLatitude="51,30N"/></x:xmpmeta>`),
+	}
+}
+
+func assertMetadata(t *testing.T, got, want *imageMetadata) {
+	t.Helper()
+	if !bytes.Equal(got.EXIF, want.EXIF) || !bytes.Equal(got.ICC, want.ICC) || !bytes.Equal(got.XMP, want.XMP) {
+		t.Errorf("metadata = {EXIF: % x, ICC: %q, XMP: %q}, want {EXIF: % x, ICC: %q, XMP: %q}",
+			got.EXIF, got.ICC, got.XMP, want.EXIF, want.ICC, want.XMP)
+	}
+}
+
+func TestJPEGMetadataRoundTrip(t *testing.T) {
+	want := testMetadata()
+	want.ICC = bytes.Repeat([]byte{7}, 150000) // three APP2 segments
+	data, err := embedJPEGMetadata([]byte{0xFF, 0xD8, 0xFF, 0xD9}, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got imageMetadata
+	readJPEGMetadata(data, &got)
+	assertMetadata(t, &got, want)
+
+	if _, err := embedJPEGMetadata([]byte("\x89PNG"), want); err == nil {
+		t.Error("embedJPEGMetadata() accepted a PNG")
+	}
+}
+
+func TestPNGMetadataRoundTrip(t *testing.T) {
+	want := testMetadata()
+	data, err := embedPNGMetadata(testPNG(t), want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("output no longer decodes: %v", err)
+	}
+	var got imageMetadata
+	readPNGMetadata(data, &got)
+	assertMetadata(t, &got, want)
+}
+
+func TestReadPNGMetadataMalformed(t *testing.T) {
+	var icc bytes.Buffer
+	zw := zlib.NewWriter(&icc)
+	zw.Write([]byte("profile"))
+	zw.Close()
+
+	chunk := func(chunkType string, payload []byte) []byte {
+		out := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+		out = append(out, chunkType...)
+		out = append(out, payload...)
+		return append(out, 0, 0, 0, 0)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want imageMetadata
+	}{
+		{"compressed icc", bytes.Join([][]byte{pngSignature, chunk("iCCP", append([]byte("p\x00\x00"), icc.Bytes()...))}, nil), imageMetadata{ICC: []byte("profile")}},
+		{"icc without a name terminator", bytes.Join([][]byte{pngSignature, chunk("iCCP", []byte("p"))}, nil), imageMetadata{}},
+		{"exif with an Exif header", bytes.Join([][]byte{pngSignature, chunk("eXIf", append(append([]byte{}, exifHeader...), 'I', 'I'))}, nil), imageMetadata{EXIF: []byte("II")}},
+		{"chunk past the end", bytes.Join([][]byte{pngSignature, chunk("eXIf", []byte("II"))[:10]}, nil), imageMetadata{}},
+		{"huge length", bytes.Join([][]byte{pngSignature, {0xFF, 0xFF, 0xFF, 0xFF}, []byte("eXIf")}, nil), imageMetadata{}},
+		{"other itxt keyword", bytes.Join([][]byte{pngSignature, chunk("iTXt", []byte("Comment\x00\x00\x00\x00\x00hi"))}, nil), imageMetadata{}},
+		{"after IEND", bytes.Join([][]byte{pngSignature, chunk("IEND", nil), chunk("eXIf", []byte("II"))}, nil), imageMetadata{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var meta imageMetadata
+			readPNGMetadata(tt.data, &meta)
+			assertMetadata(t, &meta, &tt.want)
+		})
+	}
+}
+
+func TestWebPMetadataRoundTrip(t *testing.T) {
+	// A lossless 3x2 canvas with alpha: signature, then 14-bit width-1 and height-1 and the alpha bit
+	bits := uint32(3-1) | uint32(2-1)<<14 | 1<<28
+	vp8l := binary.LittleEndian.AppendUint32([]byte{0x2F}, bits)
+	simple := []byte("RIFF\x00\x00\x00\x00WEBPVP8L")
+	simple = binary.LittleEndian.AppendUint32(simple, uint32(len(vp8l)))
+	simple = append(append(simple, vp8l...), 0) // odd payload, padded
+	binary.LittleEndian.PutUint32(simple[4:], uint32(len(simple)-8))
+
+	want := testMetadata()
+	data, err := embedWebPMetadata(simple, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := webpChunks(data)
+	if len(chunks) != 5 || chunks[0].fourCC != "VP8X" || chunks[1].fourCC != "ICCP" || chunks[2].fourCC != "VP8L" {
+		t.Fatalf("chunks = %v", chunks)
+	}
+	vp8x := chunks[0].payload
+	if vp8x[0] != 0x10|0x20|0x08|0x04 {
+		t.Errorf("VP8X flags = %#x", vp8x[0])
+	}
+	if width, height := int(vp8x[4])+1, int(vp8x[7])+1; width != 3 || height != 2 {
+		t.Errorf("canvas = %dx%d, want 3x2", width, height)
+	}
+
+	var got imageMetadata
+	readWebPMetadata(data, &got)
+	assertMetadata(t, &got, want)
+
+	// Embedding again replaces the chunks rather than adding a second set
+	again, err := embedWebPMetadata(data, &imageMetadata{XMP: []byte("<x/>")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunks := webpChunks(again); len(chunks) != 3 || chunks[0].payload[0] != 0x10|0x04 {
+		t.Errorf("chunks after re-embedding = %v", chunks)
+	}
+}
+
+func TestWebPChunksMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"not riff", []byte("RIFX\x00\x00\x00\x00WEBP"), 0},
+		{"too short", []byte("RIFF"), 0},
+		{"chunk past the end", []byte("RIFF\x00\x00\x00\x00WEBPVP8L\xff\x00\x00\x00\x2f"), 0},
+		{"huge size", []byte("RIFF\x00\x00\x00\x00WEBPVP8L\xff\xff\xff\xff"), 0},
+		{"second chunk cut short", []byte("RIFF\x00\x00\x00\x00WEBPEXIF\x01\x00\x00\x00I\x00XMP \x09\x00"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := webpChunks(tt.data); len(got) != tt.want {
+				t.Errorf("webpChunks() = %v, want %d chunks", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := embedWebPMetadata([]byte("RIFF\x00\x00\x00\x00WEBPVP8L\x01\x00\x00\x00\x00\x00"), testMetadata()); err == nil {
+		t.Error("embedWebPMetadata() accepted a bad VP8L header")
+	}
+}
+
+func TestApplyMetadataPolicy(t *testing.T) {
+	source := &imageMetadata{
+		EXIF: gpsTIFF(),
+		ICC:  []byte("icc"),
+		XMP:  []byte(`<rdf:Description tiff:Orientation="6" exif:GPSLatitude="51,30N"><exif:GPSLongitude>0,7W</exif:GPSLongitude></rdf:Description>`),
+	}
+
+	stripped := source.applyPolicy(MetadataStrip, false)
+	if stripped.EXIF != nil || stripped.XMP != nil || string(stripped.ICC) != "icc" {
+		t.Errorf("strip = %+v, want only the ICC profile", stripped)
+	}
+
+	kept := source.applyPolicy(MetadataKeep, false)
+	assertMetadata(t, kept, source)
+
+	noGPS := source.applyPolicy(MetadataStripGPS, true)
+	order, ifd, _ := tiffIFD(noGPS.EXIF)
+	if _, found := findIFDEntry(noGPS.EXIF, order, ifd, 0x8825); found {
+		t.Error("strip_gps kept the EXIF GPS pointer")
+	}
+	if exifOrientation(noGPS.EXIF) != 1 {
+		t.Errorf("orientation = %d, want 1 once the pixels are upright", exifOrientation(noGPS.EXIF))
+	}
+	if want := `<rdf:Description tiff:Orientation="1"></rdf:Description>`; string(noGPS.XMP) != want {
+		t.Errorf("XMP = %s, want %s", noGPS.XMP, want)
+	}
+	if exifOrientation(source.EXIF) != 6 {
+		t.Error("applyPolicy() modified the source EXIF")
+	}
+}
@@ -0,0 +1,595 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/qoal/file-processor/utils"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// maxOperationDimension caps resize and crop sizes so a setting can't allocate an enormous canvas
+const maxOperationDimension = 10000
+
+// imageOperation is one validated step of the "operations" pipeline
+type imageOperation interface {
+	apply(img image.Image) image.Image
+}
+
+var imageAnchors = map[string]imaging.Anchor{
+	"center":       imaging.Center,
+	"top-left":     imaging.TopLeft,
+	"top":          imaging.Top,
+	"top-right":    imaging.TopRight,
+	"left":         imaging.Left,
+	"right":        imaging.Right,
+	"bottom-left":  imaging.BottomLeft,
+	"bottom":       imaging.Bottom,
+	"bottom-right": imaging.BottomRight,
+}
+
+// parseImageOperations validates the whole "operations" list up front so a bad step fails the job before any work.
+// Without it the older width/height, crop_* and rotate settings are translated into the same pipeline.
+func (p *EnhancedImageProcessor) parseImageOperations(settings map[string]interface{}) ([]imageOperation, error) {
+	raw, exists := settings["operations"]
+	if !exists {
+		return legacyImageOperations(settings)
+	}
+
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid type for setting operations")
+	}
+
+	ops := make([]imageOperation, 0, len(list))
+	for i, item := range list {
+		params, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("operation %d: must be an object", i+1)
+		}
+		op, err := p.parseImageOperation(params)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i+1, err)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func (p *EnhancedImageProcessor) parseImageOperation(params map[string]interface{}) (imageOperation, error) {
+	name, err := utils.GetStringSetting(params, "op", "")
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "resize":
+		return parseResizeOperation(params)
+	case "crop":
+		return parseCropOperation(params)
+	case "rotate":
+		return parseRotateOperation(params)
+	case "flip":
+		direction, err := utils.GetStringSetting(params, "direction", "horizontal")
+		if err != nil {
+			return nil, err
+		}
+		if direction != "horizontal" && direction != "vertical" {
+			return nil, fmt.Errorf("flip direction must be horizontal or vertical")
+		}
+		return flipOperation{vertical: direction == "vertical"}, nil
+	case "blur", "sharpen":
+		sigma, err := utils.GetFloatSetting(params, "sigma", 1)
+		if err != nil {
+			return nil, err
+		}
+		if sigma <= 0 || sigma > 50 {
+			return nil, fmt.Errorf("%s sigma must be between 0 and 50", name)
+		}
+		return filterOperation{sharpen: name == "sharpen", sigma: sigma}, nil
+	case "grayscale":
+		return grayscaleOperation{}, nil
+	case "brightness", "contrast":
+		percentage, err := utils.GetFloatSetting(params, "percentage", 0)
+		if err != nil {
+			return nil, err
+		}
+		if percentage < -100 || percentage > 100 {
+			return nil, fmt.Errorf("%s percentage must be between -100 and 100", name)
+		}
+		return adjustOperation{contrast: name == "contrast", percentage: percentage}, nil
+	case "watermark":
+		return p.parseWatermarkOperation(params)
+	case "":
+		return nil, fmt.Errorf("missing op")
+	default:
+		return nil, fmt.Errorf("unknown op: %s", name)
+	}
+}
+
+// legacyImageOperations maps the pre-pipeline settings onto operations, in the order they used to run.
+// Their sizes are held to the same limits as the pipeline's.
+func legacyImageOperations(settings map[string]interface{}) ([]imageOperation, error) {
+	var ops []imageOperation
+	if _, ok := settings["width"].(float64); ok {
+		if _, ok := settings["height"].(float64); ok {
+			width, err := getSize(settings, "width")
+			if err != nil {
+				return nil, err
+			}
+			height, err := getSize(settings, "height")
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, resizeOperation{mode: "stretch", width: width, height: height})
+		}
+	}
+	if hasFloatSettings(settings, "crop_width", "crop_height", "crop_x", "crop_y") {
+		cropWidth, err := getSize(settings, "crop_width")
+		if err != nil {
+			return nil, err
+		}
+		cropHeight, err := getSize(settings, "crop_height")
+		if err != nil {
+			return nil, err
+		}
+		cropX, err := getDimension(settings, "crop_x")
+		if err != nil {
+			return nil, err
+		}
+		cropY, err := getDimension(settings, "crop_y")
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, cropOperation{rect: image.Rect(cropX, cropY, cropX+cropWidth, cropY+cropHeight)})
+	}
+	if rotate, ok := settings["rotate"].(float64); ok && (rotate == 90 || rotate == 180 || rotate == 270) {
+		ops = append(ops, rotateOperation{angle: rotate})
+	}
+	return ops, nil
+}
+
+// hasFloatSettings reports whether every key is set to a number, as the legacy settings needed to take effect
+func hasFloatSettings(settings map[string]interface{}, keys ...string) bool {
+	for _, key := range keys {
+		if _, ok := settings[key].(float64); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// getDimension reads an optional pixel size or offset, 0 when absent; for a size, 0 leaves it to the operation
+func getDimension(params map[string]interface{}, key string) (int, error) {
+	value, err := utils.GetIntSetting(params, key, 0)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 || value > maxOperationDimension {
+		return 0, fmt.Errorf("%s must be between 0 and %d", key, maxOperationDimension)
+	}
+	return value, nil
+}
+
+// getSize reads a pixel size that must be given
+func getSize(params map[string]interface{}, key string) (int, error) {
+	value, err := utils.GetIntSetting(params, key, 0)
+	if err != nil {
+		return 0, err
+	}
+	if value < 1 || value > maxOperationDimension {
+		return 0, fmt.Errorf("%s must be between 1 and %d", key, maxOperationDimension)
+	}
+	return value, nil
+}
+
+func getAnchor(params map[string]interface{}, key, defaultValue string) (string, imaging.Anchor, error) {
+	name, err := utils.GetStringSetting(params, key, defaultValue)
+	if err != nil {
+		return "", 0, err
+	}
+	anchor, exists := imageAnchors[name]
+	if !exists {
+		return "", 0, fmt.Errorf("unknown %s: %s", key, name)
+	}
+	return name, anchor, nil
+}
+
+type resizeOperation struct {
+	mode          string
+	width, height int
+	anchor        imaging.Anchor
+}
+
+func parseResizeOperation(params map[string]interface{}) (imageOperation, error) {
+	op := resizeOperation{}
+	var err error
+	if op.mode, err = utils.GetStringSetting(params, "mode", "fit"); err != nil {
+		return nil, err
+	}
+	if op.width, err = getDimension(params, "width"); err != nil {
+		return nil, err
+	}
+	if op.height, err = getDimension(params, "height"); err != nil {
+		return nil, err
+	}
+	if _, op.anchor, err = getAnchor(params, "anchor", "center"); err != nil {
+		return nil, err
+	}
+
+	switch op.mode {
+	case "fit", "thumbnail":
+		// A single dimension scales proportionally, so one of the two may be omitted
+		if op.width == 0 && op.height == 0 {
+			return nil, fmt.Errorf("resize needs width or height")
+		}
+	case "fill", "stretch":
+		if op.width == 0 || op.height == 0 {
+			return nil, fmt.Errorf("resize mode %s needs both width and height", op.mode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown resize mode: %s (use fit, fill, thumbnail or stretch)", op.mode)
+	}
+	return op, nil
+}
+
+// apply resizes: fit scales to fit inside the box, fill covers it and crops at the anchor,
+// thumbnail is fit that never enlarges, and stretch ignores the aspect ratio
+func (op resizeOperation) apply(img image.Image) image.Image {
+	switch op.mode {
+	case "fill":
+		return imaging.Fill(img, op.width, op.height, op.anchor, imaging.Lanczos)
+	case "stretch":
+		return imaging.Resize(img, op.width, op.height, imaging.Lanczos)
+	}
+
+	srcWidth, srcHeight := img.Bounds().Dx(), img.Bounds().Dy()
+	if srcWidth == 0 || srcHeight == 0 {
+		return img
+	}
+
+	// Scale by the tighter side; an omitted side sets no limit beyond the dimension cap
+	scale := math.Min(float64(maxOperationDimension)/float64(srcWidth), float64(maxOperationDimension)/float64(srcHeight))
+	if op.width > 0 {
+		scale = math.Min(scale, float64(op.width)/float64(srcWidth))
+	}
+	if op.height > 0 {
+		scale = math.Min(scale, float64(op.height)/float64(srcHeight))
+	}
+	if op.mode == "thumbnail" && scale >= 1 {
+		return img
+	}
+
+	width := int(math.Max(1, math.Round(float64(srcWidth)*scale)))
+	height := int(math.Max(1, math.Round(float64(srcHeight)*scale)))
+	return imaging.Resize(img, width, height, imaging.Lanczos)
+}
+
+type cropOperation struct {
+	rect          image.Rectangle
+	width, height int
+	anchor        imaging.Anchor
+	anchored      bool
+}
+
+// parseCropOperation accepts either an explicit x/y rectangle or a size cut at an anchor
+func parseCropOperation(params map[string]interface{}) (imageOperation, error) {
+	width, err := getDimension(params, "width")
+	if err != nil {
+		return nil, err
+	}
+	height, err := getDimension(params, "height")
+	if err != nil {
+		return nil, err
+	}
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("crop needs width and height")
+	}
+
+	_, hasX := params["x"]
+	_, hasY := params["y"]
+	if !hasX && !hasY {
+		_, anchor, err := getAnchor(params, "anchor", "center")
+		if err != nil {
+			return nil, err
+		}
+		return cropOperation{width: width, height: height, anchor: anchor, anchored: true}, nil
+	}
+
+	x, err := getDimension(params, "x")
+	if err != nil {
+		return nil, err
+	}
+	y, err := getDimension(params, "y")
+	if err != nil {
+		return nil, err
+	}
+	return cropOperation{rect: image.Rect(x, y, x+width, y+height)}, nil
+}
+
+func (op cropOperation) apply(img image.Image) image.Image {
+	if op.anchored {
+		return imaging.CropAnchor(img, op.width, op.height, op.anchor)
+	}
+	return imaging.Crop(img, op.rect)
+}
+
+type rotateOperation struct {
+	angle      float64
+	background color.Color
+}
+
+func parseRotateOperation(params map[string]interface{}) (imageOperation, error) {
+	angle, err := utils.GetFloatSetting(params, "angle", 0)
+	if err != nil {
+		return nil, err
+	}
+	background, err := utils.GetStringSetting(params, "background", "#00000000")
+	if err != nil {
+		return nil, err
+	}
+	bg, err := parseHexColor(background)
+	if err != nil {
+		return nil, err
+	}
+	return rotateOperation{angle: angle, background: bg}, nil
+}
+
+// apply rotates counter-clockwise, as imaging does; right angles stay lossless
+func (op rotateOperation) apply(img image.Image) image.Image {
+	switch op.angle {
+	case 0, 360, -360:
+		return img
+	case 90, -270:
+		return imaging.Rotate90(img)
+	case 180, -180:
+		return imaging.Rotate180(img)
+	case 270, -90:
+		return imaging.Rotate270(img)
+	default:
+		bg := op.background
+		if bg == nil {
+			bg = color.Transparent
+		}
+		return imaging.Rotate(img, op.angle, bg)
+	}
+}
+
+type flipOperation struct {
+	vertical bool
+}
+
+func (op flipOperation) apply(img image.Image) image.Image {
+	if op.vertical {
+		return imaging.FlipV(img)
+	}
+	return imaging.FlipH(img)
+}
+
+type filterOperation struct {
+	sharpen bool
+	sigma   float64
+}
+
+func (op filterOperation) apply(img image.Image) image.Image {
+	if op.sharpen {
+		return imaging.Sharpen(img, op.sigma)
+	}
+	return imaging.Blur(img, op.sigma)
+}
+
+type grayscaleOperation struct{}
+
+func (grayscaleOperation) apply(img image.Image) image.Image {
+	return imaging.Grayscale(img)
+}
+
+type adjustOperation struct {
+	contrast   bool
+	percentage float64
+}
+
+func (op adjustOperation) apply(img image.Image) image.Image {
+	if op.contrast {
+		return imaging.AdjustContrast(img, op.percentage)
+	}
+	return imaging.AdjustBrightness(img, op.percentage)
+}
+
+type watermarkOperation struct {
+	mark     image.Image
+	position imaging.Anchor
+	opacity  float64
+	margin   int
+}
+
+// parseWatermarkOperation renders a text mark or loads an image mark from the watermark directory
+func (p *EnhancedImageProcessor) parseWatermarkOperation(params map[string]interface{}) (imageOperation, error) {
+	op := watermarkOperation{}
+	var err error
+	if _, op.position, err = getAnchor(params, "position", "bottom-right"); err != nil {
+		return nil, err
+	}
+	if op.opacity, err = utils.GetFloatSetting(params, "opacity", 0.5); err != nil {
+		return nil, err
+	}
+	if op.opacity <= 0 || op.opacity > 1 {
+		return nil, fmt.Errorf("watermark opacity must be between 0 and 1")
+	}
+	if op.margin, err = getDimension(params, "margin"); err != nil {
+		return nil, err
+	}
+	if _, exists := params["margin"]; !exists {
+		op.margin = 16
+	}
+
+	text, err := utils.GetStringSetting(params, "text", "")
+	if err != nil {
+		return nil, err
+	}
+	imageName, err := utils.GetStringSetting(params, "image", "")
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case text != "" && imageName != "":
+		return nil, fmt.Errorf("watermark takes either text or image, not both")
+	case text != "":
+		op.mark, err = p.renderWatermarkText(text, params)
+	case imageName != "":
+		op.mark, err = p.loadWatermarkImage(imageName, params)
+	default:
+		return nil, fmt.Errorf("watermark needs text or image")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (op watermarkOperation) apply(img image.Image) image.Image {
+	bounds := img.Bounds()
+	mark := op.mark.Bounds()
+
+	var x, y int
+	switch op.position {
+	case imaging.TopLeft, imaging.Left, imaging.BottomLeft:
+		x = op.margin
+	case imaging.TopRight, imaging.Right, imaging.BottomRight:
+		x = bounds.Dx() - mark.Dx() - op.margin
+	default:
+		x = (bounds.Dx() - mark.Dx()) / 2
+	}
+	switch op.position {
+	case imaging.TopLeft, imaging.Top, imaging.TopRight:
+		y = op.margin
+	case imaging.BottomLeft, imaging.Bottom, imaging.BottomRight:
+		y = bounds.Dy() - mark.Dy() - op.margin
+	default:
+		y = (bounds.Dy() - mark.Dy()) / 2
+	}
+
+	return imaging.Overlay(img, op.mark, image.Pt(bounds.Min.X+x, bounds.Min.Y+y), op.opacity)
+}
+
+// renderWatermarkText draws text onto a transparent canvas sized to fit it
+func (p *EnhancedImageProcessor) renderWatermarkText(text string, params map[string]interface{}) (image.Image, error) {
+	size, err := utils.GetFloatSetting(params, "font_size", 32)
+	if err != nil {
+		return nil, err
+	}
+	if size < 4 || size > 500 {
+		return nil, fmt.Errorf("watermark font_size must be between 4 and 500")
+	}
+	colorValue, err := utils.GetStringSetting(params, "color", "#ffffff")
+	if err != nil {
+		return nil, err
+	}
+	textColor, err := parseHexColor(colorValue)
+	if err != nil {
+		return nil, err
+	}
+
+	face, err := p.loadWatermarkFace(size)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	width := font.MeasureString(face, text).Ceil()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	if width == 0 || width > maxOperationDimension {
+		return nil, fmt.Errorf("watermark text does not fit")
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	drawer := font.Drawer{
+		Dst:  canvas,
+		Src:  image.NewUniform(textColor),
+		Face: face,
+		Dot:  fixed.Point26_6{X: 0, Y: metrics.Ascent},
+	}
+	drawer.DrawString(text)
+	return canvas, nil
+}
+
+// loadWatermarkFace prefers the DejaVu font used for PDFs and falls back to the embedded Go font
+func (p *EnhancedImageProcessor) loadWatermarkFace(size float64) (font.Face, error) {
+	fontDir := p.config.FontDir
+	if fontDir == "" {
+		fontDir = defaultFontDir
+	}
+
+	data, err := os.ReadFile(filepath.Join(fontDir, "DejaVuSans.ttf"))
+	if err != nil {
+		data = goregular.TTF
+	}
+	parsed, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse watermark font: %w", err)
+	}
+	return opentype.NewFace(parsed, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// loadWatermarkImage opens a mark from WATERMARK_DIR; only bare file names are accepted
+func (p *EnhancedImageProcessor) loadWatermarkImage(name string, params map[string]interface{}) (image.Image, error) {
+	if p.config.WatermarkDir == "" {
+		return nil, fmt.Errorf("image watermarks are not configured")
+	}
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid watermark image name: %s", name)
+	}
+
+	mark, err := imaging.Open(filepath.Join(p.config.WatermarkDir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load watermark image: %w", err)
+	}
+
+	width, err := getDimension(params, "width")
+	if err != nil {
+		return nil, err
+	}
+	if width > 0 {
+		mark = imaging.Resize(mark, width, 0, imaging.Lanczos)
+	}
+	return mark, nil
+}
+
+// parseHexColor accepts #rgb, #rrggbb and #rrggbbaa
+func parseHexColor(value string) (color.Color, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return nil, fmt.Errorf("invalid colour: %s", value)
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid colour: %s", value)
+	}
+	return color.NRGBA{R: uint8(n >> 24), G: uint8(n >> 16), B: uint8(n >> 8), A: uint8(n)}, nil
+}
+
+// applyImageOperations runs the pipeline in order
+func applyImageOperations(img image.Image, ops []imageOperation) image.Image {
+	for _, op := range ops {
+		img = op.apply(img)
+	}
+	return img
+}
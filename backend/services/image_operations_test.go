@@ -0,0 +1,191 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/qoal/file-processor/config"
+)
+
+func newTestImageProcessor(t *testing.T) *EnhancedImageProcessor {
+	t.Helper()
+	return NewEnhancedImageProcessor(&config.Config{FontDir: t.TempDir(), WatermarkDir: t.TempDir()})
+}
+
+// operations builds an "operations" setting as it arrives decoded from JSON
+func operations(steps ...map[string]interface{}) map[string]interface{} {
+	list := make([]interface{}, len(steps))
+	for i, step := range steps {
+		list[i] = step
+	}
+	return map[string]interface{}{"operations": list}
+}
+
+func TestParseImageOperationsValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		wantErr  string
+	}{
+		{"not a list", map[string]interface{}{"operations": "resize"}, "invalid type for setting operations"},
+		{"step not an object", map[string]interface{}{"operations": []interface{}{"resize"}}, "operation 1: must be an object"},
+		{"missing op", operations(map[string]interface{}{}), "operation 1: missing op"},
+		{"unknown op", operations(map[string]interface{}{"op": "sepia"}), "unknown op: sepia"},
+		{"bad step is numbered", operations(map[string]interface{}{"op": "grayscale"}, map[string]interface{}{"op": "blur", "sigma": 0.0}), "operation 2: blur sigma"},
+		{"resize without size", operations(map[string]interface{}{"op": "resize"}), "resize needs width or height"},
+		{"fill needs both sides", operations(map[string]interface{}{"op": "resize", "mode": "fill", "width": 100.0}), "needs both width and height"},
+		{"unknown resize mode", operations(map[string]interface{}{"op": "resize", "mode": "squash", "width": 100.0}), "unknown resize mode"},
+		{"resize over the cap", operations(map[string]interface{}{"op": "resize", "width": 10001.0}), "width must be between 0 and 10000"},
+		{"negative resize", operations(map[string]interface{}{"op": "resize", "height": -1.0}), "height must be between 0 and 10000"},
+		{"unknown anchor", operations(map[string]interface{}{"op": "resize", "width": 10.0, "anchor": "middle"}), "unknown anchor: middle"},
+		{"crop without height", operations(map[string]interface{}{"op": "crop", "width": 10.0}), "crop needs width and height"},
+		{"crop offset over the cap", operations(map[string]interface{}{"op": "crop", "width": 10.0, "height": 10.0, "x": 20000.0}), "x must be between 0 and 10000"},
+		{"flip direction", operations(map[string]interface{}{"op": "flip", "direction": "diagonal"}), "flip direction"},
+		{"sharpen sigma", operations(map[string]interface{}{"op": "sharpen", "sigma": 51.0}), "sharpen sigma"},
+		{"contrast range", operations(map[string]interface{}{"op": "contrast", "percentage": -101.0}), "contrast percentage"},
+		{"rotate background", operations(map[string]interface{}{"op": "rotate", "angle": 45.0, "background": "blue"}), "invalid colour: blue"},
+		{"watermark without mark", operations(map[string]interface{}{"op": "watermark"}), "watermark needs text or image"},
+		{"watermark with both", operations(map[string]interface{}{"op": "watermark", "text": "a", "image": "b.png"}), "either text or image"},
+		{"watermark opacity", operations(map[string]interface{}{"op": "watermark", "text": "a", "opacity": 0.0}), "watermark opacity"},
+		{"watermark font size", operations(map[string]interface{}{"op": "watermark", "text": "a", "font_size": 1.0}), "font_size"},
+		{"watermark image path", operations(map[string]interface{}{"op": "watermark", "image": "../secret.png"}), "invalid watermark image name"},
+		{"watermark hidden image", operations(map[string]interface{}{"op": "watermark", "image": ".mark.png"}), "invalid watermark image name"},
+		{"legacy resize over the cap", map[string]interface{}{"width": 20000.0, "height": 100.0}, "width must be between 1 and 10000"},
+		{"legacy zero resize", map[string]interface{}{"width": 100.0, "height": 0.0}, "height must be between 1 and 10000"},
+		{"legacy crop over the cap", map[string]interface{}{"crop_width": 100.0, "crop_height": 20000.0, "crop_x": 0.0, "crop_y": 0.0}, "crop_height must be between 1 and 10000"},
+		{"legacy negative crop offset", map[string]interface{}{"crop_width": 100.0, "crop_height": 100.0, "crop_x": -5.0, "crop_y": 0.0}, "crop_x must be between 0 and 10000"},
+	}
+
+	p := newTestImageProcessor(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.parseImageOperations(tt.settings)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseImageOperations() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestImageOperationsGeometry(t *testing.T) {
+	tests := []struct {
+		name          string
+		settings      map[string]interface{}
+		width, height int
+	}{
+		{"no operations", map[string]interface{}{}, 400, 200},
+		{"fit by width", operations(map[string]interface{}{"op": "resize", "width": 100.0}), 100, 50},
+		{"fit in a box", operations(map[string]interface{}{"op": "resize", "width": 100.0, "height": 100.0}), 100, 50},
+		{"fit enlarges", operations(map[string]interface{}{"op": "resize", "height": 400.0}), 800, 400},
+		{"thumbnail never enlarges", operations(map[string]interface{}{"op": "resize", "mode": "thumbnail", "width": 800.0}), 400, 200},
+		{"thumbnail shrinks", operations(map[string]interface{}{"op": "resize", "mode": "thumbnail", "height": 50.0}), 100, 50},
+		{"fill", operations(map[string]interface{}{"op": "resize", "mode": "fill", "width": 100.0, "height": 100.0}), 100, 100},
+		{"stretch", operations(map[string]interface{}{"op": "resize", "mode": "stretch", "width": 30.0, "height": 90.0}), 30, 90},
+		{"anchored crop", operations(map[string]interface{}{"op": "crop", "width": 50.0, "height": 60.0, "anchor": "bottom-right"}), 50, 60},
+		{"crop past the edge", operations(map[string]interface{}{"op": "crop", "width": 100.0, "height": 100.0, "x": 350.0, "y": 0.0}), 50, 100},
+		{"right-angle rotate", operations(map[string]interface{}{"op": "rotate", "angle": 90.0}), 200, 400},
+		{"negative right angle", operations(map[string]interface{}{"op": "rotate", "angle": -270.0}), 200, 400},
+		{"full turn", operations(map[string]interface{}{"op": "rotate", "angle": 360.0}), 400, 200},
+		{"filters keep the size", operations(
+			map[string]interface{}{"op": "blur"},
+			map[string]interface{}{"op": "sharpen", "sigma": 2.0},
+			map[string]interface{}{"op": "grayscale"},
+			map[string]interface{}{"op": "brightness", "percentage": 10.0},
+			map[string]interface{}{"op": "flip", "direction": "vertical"},
+			map[string]interface{}{"op": "watermark", "text": "draft"},
+		), 400, 200},
+		{"steps run in order", operations(
+			map[string]interface{}{"op": "rotate", "angle": 90.0},
+			map[string]interface{}{"op": "resize", "width": 100.0},
+		), 100, 200},
+		{"legacy resize, crop and rotate", map[string]interface{}{
+			"width": 300.0, "height": 300.0,
+			"crop_width": 100.0, "crop_height": 50.0, "crop_x": 10.0, "crop_y": 10.0,
+			"rotate": 270.0,
+		}, 50, 100},
+		{"legacy crop needs every field", map[string]interface{}{"crop_width": 100.0, "crop_height": 50.0}, 400, 200},
+		{"legacy rotate ignores other angles", map[string]interface{}{"rotate": 45.0}, 400, 200},
+	}
+
+	p := newTestImageProcessor(t)
+	src := imaging.New(400, 200, color.NRGBA{R: 200, A: 255})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, err := p.parseImageOperations(tt.settings)
+			if err != nil {
+				t.Fatalf("parseImageOperations() error = %v", err)
+			}
+			got := applyImageOperations(src, ops).Bounds()
+			if got.Dx() != tt.width || got.Dy() != tt.height {
+				t.Errorf("result is %dx%d, want %dx%d", got.Dx(), got.Dy(), tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestWatermarkImage(t *testing.T) {
+	p := newTestImageProcessor(t)
+	if err := imaging.Save(imaging.New(40, 20, color.White), filepath.Join(p.config.WatermarkDir, "mark.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := p.parseImageOperations(operations(map[string]interface{}{
+		"op": "watermark", "image": "mark.png", "width": 20.0, "position": "top-left", "margin": 0.0, "opacity": 1.0,
+	}))
+	if err != nil {
+		t.Fatalf("parseImageOperations() error = %v", err)
+	}
+	if mark := ops[0].(watermarkOperation).mark.Bounds(); mark.Dx() != 20 || mark.Dy() != 10 {
+		t.Errorf("mark is %dx%d, want 20x10", mark.Dx(), mark.Dy())
+	}
+
+	out := imaging.Clone(applyImageOperations(imaging.New(100, 100, color.Black), ops))
+	if got := out.NRGBAAt(5, 5); got != (color.NRGBA{255, 255, 255, 255}) {
+		t.Errorf("pixel under the mark = %v, want white", got)
+	}
+	if got := out.NRGBAAt(50, 50); got != (color.NRGBA{0, 0, 0, 255}) {
+		t.Errorf("pixel outside the mark = %v, want black", got)
+	}
+
+	if _, err := p.parseImageOperations(operations(map[string]interface{}{"op": "watermark", "image": "missing.png"})); err == nil {
+		t.Error("parseImageOperations() accepted a missing watermark image")
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    color.Color
+		wantErr bool
+	}{
+		{"#fff", color.NRGBA{255, 255, 255, 255}, false},
+		{"#102030", color.NRGBA{0x10, 0x20, 0x30, 255}, false},
+		{"10203080", color.NRGBA{0x10, 0x20, 0x30, 0x80}, false},
+		{"#00000000", color.NRGBA{}, false},
+		{"#12", nil, true},
+		{"#gggggg", nil, true},
+		{"", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseHexColor(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseHexColor(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestCropOperationRect(t *testing.T) {
+	op, err := parseCropOperation(map[string]interface{}{"width": 10.0, "height": 20.0, "x": 5.0, "y": 0.0})
+	if err != nil {
+		t.Fatalf("parseCropOperation() error = %v", err)
+	}
+	if got := op.(cropOperation).rect; got != image.Rect(5, 0, 15, 20) {
+		t.Errorf("rect = %v, want %v", got, image.Rect(5, 0, 15, 20))
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/qoal/file-processor/config"
 	"github.com/qoal/file-processor/models"
@@ -12,12 +13,14 @@ import (
 )
 
 type EnhancedImageProcessor struct {
-	config *config.Config
+	config   *config.Config
+	executor *utils.SecureCommandExecutor
 }
 
 func NewEnhancedImageProcessor(cfg *config.Config) *EnhancedImageProcessor {
 	return &EnhancedImageProcessor{
-		config: cfg,
+		config:   cfg,
+		executor: utils.NewSecureCommandExecutor(5 * time.Minute),
 	}
 }
 
@@ -52,7 +55,14 @@ func (p *EnhancedImageProcessor) ProcessImage(job *models.ProcessingJob) error {
 }
 
 func (p *EnhancedImageProcessor) executeImageConversion(inputFile string, job *models.ProcessingJob) (string, error) {
-	ext, err := utils.GetImageExtension(job.TargetFormat)
+	getExtension := utils.GetImageExtension
+	_, profileErr := GetVideoCodecProfile(job.TargetFormat)
+	toVideo := profileErr == nil
+	if toVideo {
+		// Animated GIFs can be re-encoded as much smaller video
+		getExtension = utils.GetVideoExtension
+	}
+	ext, err := getExtension(job.TargetFormat)
 	if err != nil {
 		return "", fmt.Errorf("failed to get target extension: %w", err)
 	}
@@ -61,6 +71,115 @@ func (p *EnhancedImageProcessor) executeImageConversion(inputFile string, job *m
 	os.MkdirAll(p.config.OutputDir, 0755)
 	outputFile := filepath.Join(p.config.OutputDir, job.JobID+"_output"+ext)
 
+	if toVideo {
+		return p.convertAnimationToVideo(inputFile, outputFile, job)
+	}
+
+	policy, err := getMetadataPolicy(job.Settings)
+	if err != nil {
+		return "", err
+	}
+	autoOrient, err := utils.GetBoolSetting(job.Settings, "auto_orient", true)
+	if err != nil {
+		return "", err
+	}
+
+	frames, err := animationFrameCount(inputFile)
+	if err != nil {
+		return "", err
+	}
+	if frames > 1 {
+		target := strings.ToLower(job.TargetFormat)
+		if target == "gif" || target == "webp" {
+			return p.convertAnimation(inputFile, outputFile, job)
+		}
+	}
+
+	// Metadata comes from the original file, before any intermediate replaces it
+	meta, err := p.readImageMetadata(inputFile)
+	if err != nil {
+		return "", err
+	}
+
+	if frames > 1 {
+		// Still targets get a single frame, the first unless "frame" picks another
+		index, err := utils.GetIntSetting(job.Settings, "frame", 0)
+		if err != nil {
+			return "", err
+		}
+		still, err := p.extractFrame(inputFile, index, frames)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(still)
+		inputFile = still
+	}
+
+	ops, err := p.parseImageOperations(job.Settings)
+	if err != nil {
+		return "", err
+	}
+	colorOpts, err := getColorOptions(job.Settings)
+	if err != nil {
+		return "", err
+	}
+
+	// The stdlib decoders apply no colour management, so CMYK (always) and other ICC-tagged
+	// sources (on request) are converted to sRGB through a real colour engine first
+	src := detectSourceColor(inputFile, meta.ICC)
+	icc := meta.ICC
+	if src.CMYK || (colorOpts.ToSRGB && len(icc) > 0 && !isSRGBProfile(icc)) {
+		managed, err := p.convertToSRGB(inputFile, icc, src, colorOpts)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(managed)
+		inputFile = managed
+
+		if srgbPath, err := p.iccProfilePath("srgb.icc"); err == nil {
+			icc, _ = os.ReadFile(srgbPath)
+		}
+	}
+	keepDeep := colorOpts.BitDepth == 16
+
+	// The stdlib decoders ignore EXIF orientation, so phone photos are turned upright before the pipeline runs
+	orientation := 1
+	if autoOrient && meta.Orientation > 1 {
+		orientation = meta.Orientation
+	}
+	reduceDepth := src.Deep && !keepDeep && supportsDeepColor(job.TargetFormat)
+	if orientation > 1 || len(ops) > 0 || reduceDepth {
+		transformed, err := p.transformImage(inputFile, orientation, ops, keepDeep)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(transformed)
+		inputFile = transformed
+
+		// imaging hands back RGB, which a greyscale profile can't describe
+		if len(ops) > 0 && iccColorSpace(icc) == "GRAY" {
+			icc = nil
+		}
+	}
+	oriented := orientation > 1
+
+	if _, err := p.executeStillConversion(inputFile, outputFile, job); err != nil {
+		return "", err
+	}
+
+	final := meta.applyPolicy(policy, oriented)
+	final.ICC = nil
+	if colorOpts.EmbedICC {
+		final.ICC = icc
+	}
+	if err := p.writeImageMetadata(outputFile, final); err != nil {
+		return "", fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	return outputFile, nil
+}
+
+func (p *EnhancedImageProcessor) executeStillConversion(inputFile, outputFile string, job *models.ProcessingJob) (string, error) {
 	conversionType := strings.ToUpper(job.SourceFormat) + "_TO_" + strings.ToUpper(job.TargetFormat)
 	switch conversionType {
 	case "JPEG_TO_PNG":
@@ -83,16 +202,8 @@ func (p *EnhancedImageProcessor) executeImageConversion(inputFile string, job *m
 		return p.convertGIFtoJPEG(inputFile, outputFile, job)
 	case "GIF_TO_PNG":
 		return p.convertGIFtoPNG(inputFile, outputFile, job)
-	case "PNG_TO_WEBP", "JPEG_TO_WEBP":
-		// For now, fall back to generic conversion for WebP since we need additional libraries
-		return p.genericImageConversion(inputFile, outputFile, job)
-	case "WEBP_TO_JPEG", "WEBP_TO_PNG":
-		// For now, fall back to generic conversion for WebP since we need additional libraries
-		return p.genericImageConversion(inputFile, outputFile, job)
-	case "HEIC_TO_JPEG":
-		// These formats require special handling, use generic for now
-		return p.genericImageConversion(inputFile, outputFile, job)
 	default:
+		// WebP and HEIC/HEIF go through here too; the generic path hands those codecs to ImageMagick
 		return p.genericImageConversion(inputFile, outputFile, job)
 	}
 }
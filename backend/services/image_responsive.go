@@ -0,0 +1,314 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/qoal/file-processor/models"
+	"github.com/qoal/file-processor/utils"
+)
+
+// maxResponsiveVariants caps widths × formats so a single job can't fan out without bound
+const maxResponsiveVariants = 50
+
+// responsiveNamePattern keeps the variant file names safe inside the zip and in srcset attributes
+var responsiveNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ResponsiveOptions selects the variants a responsive job renders
+type ResponsiveOptions struct {
+	Widths    []int    // ascending and de-duplicated
+	Formats   []string // target formats, lower case
+	Name      string   // file name stem for every variant
+	URLPrefix string   // prepended to file names in the srcset strings
+}
+
+func getResponsiveOptions(settings map[string]interface{}, sourceFormat string) (ResponsiveOptions, error) {
+	opts := ResponsiveOptions{}
+
+	widths, err := utils.GetIntSliceSetting(settings, "widths")
+	if err != nil {
+		return opts, err
+	}
+	if len(widths) == 0 {
+		return opts, fmt.Errorf("widths must list at least one width")
+	}
+	seen := make(map[int]bool)
+	for _, width := range widths {
+		if width < 1 || width > maxOperationDimension {
+			return opts, fmt.Errorf("width must be between 1 and %d, got %d", maxOperationDimension, width)
+		}
+		if !seen[width] {
+			seen[width] = true
+			opts.Widths = append(opts.Widths, width)
+		}
+	}
+	sort.Ints(opts.Widths)
+
+	formats, err := utils.GetStringSliceSetting(settings, "formats")
+	if err != nil {
+		return opts, err
+	}
+	if len(formats) == 0 {
+		formats = []string{sourceFormat}
+	}
+	for _, format := range formats {
+		format = strings.ToLower(format)
+		if _, err := utils.GetImageExtension(format); err != nil {
+			return opts, err
+		}
+		opts.Formats = append(opts.Formats, format)
+	}
+
+	if n := len(opts.Widths) * len(opts.Formats); n > maxResponsiveVariants {
+		return opts, fmt.Errorf("too many variants: %d widths × %d formats exceeds %d", len(opts.Widths), len(opts.Formats), maxResponsiveVariants)
+	}
+
+	if opts.Name, err = utils.GetStringSetting(settings, "name", "image"); err != nil {
+		return opts, err
+	}
+	if !responsiveNamePattern.MatchString(opts.Name) {
+		return opts, fmt.Errorf("name may only contain letters, digits, '-' and '_'")
+	}
+
+	if opts.URLPrefix, err = utils.GetStringSetting(settings, "url_prefix", ""); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// ProcessResponsiveImages decodes one master image and renders it at every requested width and format,
+// packing the variants and a manifest.json into a zip. The manifest is also left on the job for its status.
+func (p *EnhancedImageProcessor) ProcessResponsiveImages(job *models.ProcessingJob) error {
+	job.Status = "processing"
+	job.Progress = 10
+
+	opts, err := getResponsiveOptions(job.Settings, job.SourceFormat)
+	if err != nil {
+		return fmt.Errorf("responsive image set failed: %w", err)
+	}
+
+	os.MkdirAll(p.config.OutputDir, 0755)
+	outputFile := filepath.Join(p.config.OutputDir, job.JobID+"_output.zip")
+
+	manifest, err := p.renderResponsiveSet(job.InputPath, outputFile, opts, job)
+	if err != nil {
+		os.Remove(outputFile)
+		return fmt.Errorf("responsive image set failed: %w", err)
+	}
+
+	job.OutputPath = outputFile
+	job.Manifest = manifest
+	job.Status = "completed"
+	job.Progress = 100
+
+	return nil
+}
+
+func (p *EnhancedImageProcessor) renderResponsiveSet(inputFile, outputFile string, opts ResponsiveOptions, job *models.ProcessingJob) (*models.Manifest, error) {
+	policy, err := getMetadataPolicy(job.Settings)
+	if err != nil {
+		return nil, err
+	}
+	autoOrient, err := utils.GetBoolSetting(job.Settings, "auto_orient", true)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := p.parseImageOperations(job.Settings)
+	if err != nil {
+		return nil, err
+	}
+	colorOpts, err := getColorOptions(job.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := p.readImageMetadata(inputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	frames, err := animationFrameCount(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	if frames > 1 {
+		still, err := p.extractFrame(inputFile, 0, frames)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(still)
+		inputFile = still
+	}
+
+	// Same colour handling as a single conversion, so the variants match what a convert job would produce
+	src := detectSourceColor(inputFile, meta.ICC)
+	icc := meta.ICC
+	if src.CMYK || (colorOpts.ToSRGB && len(icc) > 0 && !isSRGBProfile(icc)) {
+		managed, err := p.convertToSRGB(inputFile, icc, src, colorOpts)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(managed)
+		inputFile = managed
+
+		if srgbPath, err := p.iccProfilePath("srgb.icc"); err == nil {
+			icc, _ = os.ReadFile(srgbPath)
+		}
+	}
+	// Resampling yields RGB, which a greyscale profile can't describe
+	if iccColorSpace(icc) == "GRAY" {
+		icc = nil
+	}
+
+	// Decode once; every variant is resampled from these pixels
+	img, format, err := p.decodeImage(inputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	orientation := 1
+	if autoOrient && meta.Orientation > 1 {
+		orientation = meta.Orientation
+	}
+	if img, err = renderImage(img, orientation, ops, false); err != nil {
+		return nil, err
+	}
+
+	final := meta.applyPolicy(policy, orientation > 1)
+	final.ICC = nil
+	if colorOpts.EmbedICC {
+		final.ICC = icc
+	}
+
+	bounds := img.Bounds()
+	manifest := &models.Manifest{
+		Source: models.ManifestImage{Width: bounds.Dx(), Height: bounds.Dy(), Format: strings.ToLower(job.SourceFormat)},
+		Srcset: make(map[string]string),
+	}
+
+	widths, skipped := responsiveWidths(opts.Widths, bounds.Dx())
+	manifest.SkippedWidths = skipped
+
+	workDir, err := os.MkdirTemp(p.config.TempDir, "responsive_*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create working directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	total := len(widths) * len(opts.Formats)
+	srcsets := make(map[string][]string)
+	for i, width := range widths {
+		variant := img
+		if width != bounds.Dx() {
+			variant = imaging.Resize(img, width, 0, imaging.Lanczos)
+		}
+
+		for j, target := range opts.Formats {
+			ext, _ := utils.GetImageExtension(target)
+			name := fmt.Sprintf("%s-%d%s", opts.Name, width, ext)
+			path := filepath.Join(workDir, name)
+
+			if err := p.encodeImage(variant, format, path, job.Settings); err != nil {
+				return nil, fmt.Errorf("variant %s: %w", name, err)
+			}
+			if err := p.writeImageMetadata(path, final); err != nil {
+				return nil, fmt.Errorf("variant %s: failed to write metadata: %w", name, err)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("variant %s: %w", name, err)
+			}
+
+			vb := variant.Bounds()
+			manifest.Variants = append(manifest.Variants, models.ManifestVariant{
+				File:   name,
+				Format: target,
+				Width:  vb.Dx(),
+				Height: vb.Dy(),
+				Bytes:  info.Size(),
+			})
+			srcsets[target] = append(srcsets[target], fmt.Sprintf("%s%s %dw", opts.URLPrefix, name, vb.Dx()))
+
+			job.Progress = 30 + 50*(i*len(opts.Formats)+j+1)/total
+		}
+	}
+
+	for target, entries := range srcsets {
+		manifest.Srcset[target] = strings.Join(entries, ", ")
+	}
+
+	if err := writeResponsiveZip(outputFile, workDir, manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// responsiveWidths picks the widths to render from a source sourceWidth pixels wide. Upscaling only adds bytes,
+// so widths beyond the source are skipped; a source narrower than every requested width still yields one
+// full-size variant.
+func responsiveWidths(requested []int, sourceWidth int) (widths, skipped []int) {
+	for _, width := range requested {
+		if width > sourceWidth {
+			skipped = append(skipped, width)
+			continue
+		}
+		widths = append(widths, width)
+	}
+	if len(widths) == 0 {
+		widths = []int{sourceWidth}
+	}
+	return widths, skipped
+}
+
+// writeResponsiveZip packs the rendered variants and the manifest into outputFile
+func writeResponsiveZip(outputFile, workDir string, manifest *models.Manifest) error {
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+
+	for _, variant := range manifest.Variants {
+		// The image codecs already compressed these; deflating again only costs time
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: variant.File, Method: zip.Store})
+		if err != nil {
+			return fmt.Errorf("failed to add %s to zip: %w", variant.File, err)
+		}
+		f, err := os.Open(filepath.Join(workDir, variant.File))
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", variant.File, err)
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to add %s to zip: %w", variant.File, err)
+		}
+	}
+
+	w, err := zw.Create("manifest.json")
+	if err != nil {
+		return fmt.Errorf("failed to add manifest to zip: %w", err)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write zip: %w", err)
+	}
+	return out.Close()
+}
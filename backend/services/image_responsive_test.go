@@ -0,0 +1,191 @@
+package services
+
+import (
+	"archive/zip"
+	"image/color"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/qoal/file-processor/config"
+	"github.com/qoal/file-processor/models"
+)
+
+// widthList builds a "widths" setting as it arrives decoded from JSON
+func widthList(widths ...float64) []interface{} {
+	list := make([]interface{}, len(widths))
+	for i, width := range widths {
+		list[i] = width
+	}
+	return list
+}
+
+func TestGetResponsiveOptions(t *testing.T) {
+	manyWidths := make([]float64, 26)
+	for i := range manyWidths {
+		manyWidths[i] = float64(100 + i)
+	}
+	// 25 widths listed twice make 50 variants in two formats only once duplicates are dropped
+	twice := append(append([]float64{}, manyWidths[:25]...), manyWidths[:25]...)
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     ResponsiveOptions
+		wantErr  string
+	}{
+		{
+			name:     "defaults",
+			settings: map[string]interface{}{"widths": widthList(640)},
+			want:     ResponsiveOptions{Widths: []int{640}, Formats: []string{"png"}, Name: "image"},
+		},
+		{
+			name:     "duplicates dropped and sorted",
+			settings: map[string]interface{}{"widths": widthList(1280, 320, 640, 320, 1280)},
+			want:     ResponsiveOptions{Widths: []int{320, 640, 1280}, Formats: []string{"png"}, Name: "image"},
+		},
+		{
+			name: "formats lower-cased, name and prefix kept",
+			settings: map[string]interface{}{
+				"widths":     widthList(320),
+				"formats":    []interface{}{"WebP", "jpg"},
+				"name":       "hero_2x-v1",
+				"url_prefix": "https://cdn.example.com/img/",
+			},
+			want: ResponsiveOptions{Widths: []int{320}, Formats: []string{"webp", "jpg"}, Name: "hero_2x-v1", URLPrefix: "https://cdn.example.com/img/"},
+		},
+		{
+			name:     "widths at the cap",
+			settings: map[string]interface{}{"widths": widthList(1, 10000)},
+			want:     ResponsiveOptions{Widths: []int{1, 10000}, Formats: []string{"png"}, Name: "image"},
+		},
+		{
+			name:     "duplicates count once toward the variant cap",
+			settings: map[string]interface{}{"widths": widthList(twice...), "formats": []interface{}{"png", "jpg"}},
+			want:     ResponsiveOptions{Widths: intRange(100, 25), Formats: []string{"png", "jpg"}, Name: "image"},
+		},
+		{name: "widths missing", settings: map[string]interface{}{}, wantErr: "at least one width"},
+		{name: "widths empty", settings: map[string]interface{}{"widths": []interface{}{}}, wantErr: "at least one width"},
+		{name: "widths not a list", settings: map[string]interface{}{"widths": 640.0}, wantErr: "invalid type"},
+		{name: "width of the wrong type", settings: map[string]interface{}{"widths": []interface{}{"640"}}, wantErr: "invalid type"},
+		{name: "zero width", settings: map[string]interface{}{"widths": widthList(0)}, wantErr: "between 1 and 10000, got 0"},
+		{name: "width over the cap", settings: map[string]interface{}{"widths": widthList(320, 10001)}, wantErr: "between 1 and 10000, got 10001"},
+		{name: "unknown format", settings: map[string]interface{}{"widths": widthList(320), "formats": []interface{}{"svg"}}, wantErr: "unsupported image format: svg"},
+		{
+			name:     "too many variants",
+			settings: map[string]interface{}{"widths": widthList(manyWidths...), "formats": []interface{}{"png", "jpg"}},
+			wantErr:  "26 widths × 2 formats exceeds 50",
+		},
+		{name: "name with a path", settings: map[string]interface{}{"widths": widthList(320), "name": "../hero"}, wantErr: "name may only contain"},
+		{name: "name too long", settings: map[string]interface{}{"widths": widthList(320), "name": strings.Repeat("a", 65)}, wantErr: "name may only contain"},
+		{name: "empty name", settings: map[string]interface{}{"widths": widthList(320), "name": ""}, wantErr: "name may only contain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getResponsiveOptions(tt.settings, "png")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("getResponsiveOptions() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getResponsiveOptions() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getResponsiveOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func intRange(start, n int) []int {
+	out := make([]int, n)
+	for i := range out {
+		out[i] = start + i
+	}
+	return out
+}
+
+func TestResponsiveWidths(t *testing.T) {
+	tests := []struct {
+		name        string
+		requested   []int
+		sourceWidth int
+		widths      []int
+		skipped     []int
+	}{
+		{"all fit", []int{320, 640}, 1000, []int{320, 640}, nil},
+		{"source width is kept", []int{320, 1000}, 1000, []int{320, 1000}, nil},
+		{"wider ones skipped", []int{320, 640, 1280}, 800, []int{320, 640}, []int{1280}},
+		{"source narrower than every width", []int{320, 640}, 200, []int{200}, []int{320, 640}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			widths, skipped := responsiveWidths(tt.requested, tt.sourceWidth)
+			if !reflect.DeepEqual(widths, tt.widths) || !reflect.DeepEqual(skipped, tt.skipped) {
+				t.Errorf("responsiveWidths() = %v, %v, want %v, %v", widths, skipped, tt.widths, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestProcessResponsiveImagesNarrowSource(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "source.png")
+	if err := imaging.Save(imaging.New(200, 100, color.NRGBA{B: 255, A: 255}), input); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewEnhancedImageProcessor(&config.Config{TempDir: dir, OutputDir: filepath.Join(dir, "out")})
+	job := &models.ProcessingJob{
+		JobID:        "narrow",
+		InputPath:    input,
+		SourceFormat: "png",
+		Settings: map[string]interface{}{
+			"widths":     widthList(640, 320),
+			"formats":    []interface{}{"png", "jpg"},
+			"name":       "hero",
+			"url_prefix": "/img/",
+		},
+	}
+	if err := p.ProcessResponsiveImages(job); err != nil {
+		t.Fatalf("ProcessResponsiveImages() error = %v", err)
+	}
+
+	manifest := job.Manifest
+	if !reflect.DeepEqual(manifest.SkippedWidths, []int{320, 640}) {
+		t.Errorf("skipped widths = %v, want [320 640]", manifest.SkippedWidths)
+	}
+	if len(manifest.Variants) != 2 {
+		t.Fatalf("got %d variants, want 2: %+v", len(manifest.Variants), manifest.Variants)
+	}
+	for _, variant := range manifest.Variants {
+		if variant.Width != 200 || variant.Height != 100 || variant.Bytes == 0 {
+			t.Errorf("variant = %+v, want a full-size 200x100 image", variant)
+		}
+	}
+	if got := manifest.Srcset["jpg"]; got != "/img/hero-200.jpg 200w" {
+		t.Errorf("jpg srcset = %q", got)
+	}
+
+	archive, err := zip.OpenReader(job.OutputPath)
+	if err != nil {
+		t.Fatalf("failed to open output: %v", err)
+	}
+	defer archive.Close()
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"hero-200.jpg", "hero-200.png", "manifest.json"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("zip holds %v, want %v", names, want)
+	}
+}
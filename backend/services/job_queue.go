@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/qoal/file-processor/models"
+)
+
+// Tasks are delivered at least once. Taking a task moves it from the queue onto the worker's own processing
+// list, where it stays until the worker acknowledges it. Workers keep a heartbeat key alive while they run;
+// once a worker's heartbeat lapses, its unacknowledged tasks go back on the queue for another worker.
+const (
+	queueKey      = "conversion_queue"
+	delayedKey    = queueKey + ":delayed" // retries waiting out their backoff, scored by when they're due
+	deadLetterKey = queueKey + ":dead"    // tasks that failed on every attempt
+	workersKey    = "conversion_workers"  // set of worker IDs that may hold tasks
+	queuePollWait = 5 * time.Second       // how long a worker blocks on an empty queue before checking for shutdown
+
+	// A task stays invisible to other workers for as long as its worker's heartbeat lives
+	HeartbeatInterval = 10 * time.Second
+	heartbeatTTL      = 3 * HeartbeatInterval
+)
+
+func processingKey(workerID string) string {
+	return queueKey + ":processing:" + workerID
+}
+
+func heartbeatKey(workerID string) string {
+	return "conversion_worker:" + workerID
+}
+
+// NewWorkerID returns an ID for a worker process that is unique across hosts and restarts
+func NewWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.New().String()[:8])
+}
+
+// Heartbeat registers the worker and marks it alive for another heartbeatTTL; call it every HeartbeatInterval
+func (s *JobService) Heartbeat(ctx context.Context, workerID string) error {
+	pipe := s.redisClient.TxPipeline()
+	pipe.SAdd(ctx, workersKey, workerID)
+	pipe.Set(ctx, heartbeatKey(workerID), time.Now().Unix(), heartbeatTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to send worker heartbeat: %w", err)
+	}
+	return nil
+}
+
+// GetNextJobFromQueue takes the next task off the queue onto the worker's processing list. It returns
+// redis.Nil when nothing arrives within the poll wait, so callers can check for shutdown between polls.
+func (s *JobService) GetNextJobFromQueue(ctx context.Context, workerID string) (*JobTask, error) {
+	data, err := s.redisClient.BLMove(ctx, queueKey, processingKey(workerID), "RIGHT", "LEFT", queuePollWait).Result()
+	if err == redis.Nil {
+		return nil, redis.Nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job from queue: %w", err)
+	}
+
+	var task JobTask
+	if err := json.Unmarshal([]byte(data), &task); err != nil {
+		// Acknowledged straight away, since no worker could ever process it
+		s.redisClient.LRem(ctx, processingKey(workerID), 1, data)
+		return nil, fmt.Errorf("failed to unmarshal job task: %w", err)
+	}
+	task.payload = data
+
+	return &task, nil
+}
+
+// AckJob removes a task the worker has finished with, successfully or not, from its processing list
+func (s *JobService) AckJob(ctx context.Context, workerID string, task *JobTask) error {
+	if err := s.redisClient.LRem(ctx, processingKey(workerID), 1, task.payload).Err(); err != nil {
+		return fmt.Errorf("failed to acknowledge job: %w", err)
+	}
+	return nil
+}
+
+// StartJob marks a delivered job as processing and counts the attempt on task. It reports false when the job
+// shouldn't run: it finished before a redelivery, was deleted while queued, or has had its last attempt.
+func (s *JobService) StartJob(ctx context.Context, task *JobTask) (bool, error) {
+	runnable := []string{string(models.StatusPending), string(models.StatusProcessing), string(models.StatusRetrying)}
+	result := s.db.Model(&models.Job{}).
+		Where("job_id = ? AND status IN ?", task.JobID, runnable).
+		Updates(map[string]interface{}{
+			"status":        string(models.StatusProcessing),
+			"attempts":      gorm.Expr("attempts + 1"),
+			"next_retry_at": nil,
+			"updated_at":    time.Now(),
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to update job status to processing: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	var job models.Job
+	if err := s.db.Select("attempts").Where("job_id = ?", task.JobID).First(&job).Error; err != nil {
+		return false, fmt.Errorf("failed to get job attempts: %w", err)
+	}
+	task.Attempt = job.Attempts
+
+	// Only a job whose worker died on every attempt gets here, having never recorded a failure
+	if task.Attempt > MaxJobAttempts {
+		task.Attempt = MaxJobAttempts
+		return false, s.FailJob(ctx, task, Retryable(fmt.Errorf("worker stopped during each of %d attempts", MaxJobAttempts)))
+	}
+	return true, nil
+}
+
+// RequeueStalledJobs puts the unacknowledged tasks of every worker whose heartbeat has lapsed back on the
+// queue, and returns how many it moved. Any worker may run it; each task moves atomically, so it moves once.
+func (s *JobService) RequeueStalledJobs(ctx context.Context) (int, error) {
+	workerIDs, err := s.redisClient.SMembers(ctx, workersKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list workers: %w", err)
+	}
+
+	requeued := 0
+	for _, workerID := range workerIDs {
+		alive, err := s.redisClient.Exists(ctx, heartbeatKey(workerID)).Result()
+		if err != nil {
+			return requeued, fmt.Errorf("failed to check worker heartbeat: %w", err)
+		}
+		if alive > 0 {
+			continue
+		}
+
+		for {
+			// Onto the end of the queue workers take from next, the oldest ending up first in line
+			data, err := s.redisClient.LMove(ctx, processingKey(workerID), queueKey, "LEFT", "RIGHT").Result()
+			if err == redis.Nil {
+				break
+			}
+			if err != nil {
+				return requeued, fmt.Errorf("failed to requeue job: %w", err)
+			}
+			requeued++
+
+			var task JobTask
+			if err := json.Unmarshal([]byte(data), &task); err == nil {
+				log.Printf("Requeued job %s from stalled worker %s", task.JobID, workerID)
+				s.db.Model(&models.Job{}).
+					Where("job_id = ? AND status = ?", task.JobID, string(models.StatusProcessing)).
+					Update("status", string(models.StatusPending))
+			}
+		}
+		s.redisClient.SRem(ctx, workersKey, workerID)
+	}
+
+	return requeued, nil
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewWorkerID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := NewWorkerID()
+		if seen[id] {
+			t.Fatalf("NewWorkerID() repeated %s", id)
+		}
+		seen[id] = true
+		if strings.ContainsAny(id, " \n") {
+			t.Errorf("NewWorkerID() = %q, which won't make a clean key", id)
+		}
+	}
+}
+
+func TestWorkerKeysAreDistinct(t *testing.T) {
+	keys := map[string]bool{
+		queueKey:           true,
+		delayedKey:         true,
+		deadLetterKey:      true,
+		workersKey:         true,
+		processingKey("a"): true,
+		processingKey("b"): true,
+		heartbeatKey("a"):  true,
+		heartbeatKey("b"):  true,
+	}
+	if len(keys) != 8 {
+		t.Errorf("queue keys collide: %v", keys)
+	}
+}
+
+func TestHeartbeatOutlivesItsInterval(t *testing.T) {
+	// A live worker whose heartbeat lapsed between beats would have its tasks handed to another worker
+	if heartbeatTTL < 2*HeartbeatInterval {
+		t.Errorf("heartbeatTTL %s leaves no room for a late heartbeat every %s", heartbeatTTL, HeartbeatInterval)
+	}
+}
+
+func TestJobTaskQueuedWithoutAttempt(t *testing.T) {
+	task := JobTask{JobID: "job", UserID: "user", TargetFormat: "png", Settings: map[string]interface{}{"width": 100}, Attempt: 2}
+	data, err := json.Marshal(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "ttempt") || strings.Contains(string(data), "payload") {
+		t.Errorf("queued task carries delivery state: %s", data)
+	}
+
+	var delivered JobTask
+	if err := json.Unmarshal(data, &delivered); err != nil {
+		t.Fatal(err)
+	}
+	if delivered.JobID != task.JobID || delivered.UserID != task.UserID || delivered.Attempt != 0 {
+		t.Errorf("delivered task = %+v", delivered)
+	}
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/qoal/file-processor/models"
+)
+
+// A job that fails for a reason that may pass, such as a storage timeout, is run again after a backoff,
+// up to MaxJobAttempts times in all. One that still fails goes to the dead-letter list for an admin to
+// inspect and replay; one that fails for good, such as on a corrupt input, just fails.
+const (
+	MaxJobAttempts = 5
+
+	retryBaseDelay = 30 * time.Second
+	retryMaxDelay  = 30 * time.Minute
+)
+
+// retryableError marks a failure as worth another attempt
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable marks err as a failure that may not happen again, for errors IsRetryable can't recognize
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+// IsRetryable reports whether a job that failed with err might succeed if run again: timeouts, dropped
+// connections, a full disk, throttling and server errors from S3, and errors marked Retryable. Anything
+// else, such as an unsupported or corrupt input, would fail the same way every time.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var marked *retryableError
+	if errors.As(err, &marked) {
+		return true
+	}
+
+	var requestFailure awserr.RequestFailure
+	if errors.As(err, &requestFailure) && (requestFailure.StatusCode() >= 500 || requestFailure.StatusCode() == 429) {
+		return true
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && (request.IsErrorRetryable(awsErr) || request.IsErrorThrottle(awsErr)) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ENOSPC) ||
+		errors.Is(err, driver.ErrBadConn)
+}
+
+// retryDelay returns how long to wait before the attempt after the given one: the delay doubles with each
+// attempt up to retryMaxDelay, and a random half of it is jitter so failed jobs don't all return at once
+func retryDelay(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 16 {
+		delay = min(retryBaseDelay<<max(attempt-1, 0), retryMaxDelay)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// FailJob records a failed attempt at a job. A retryable failure with attempts to spare schedules the task
+// to run again after a backoff; otherwise the job fails, and a job that ran out of attempts is dead-lettered.
+func (s *JobService) FailJob(ctx context.Context, task *JobTask, jobErr error) error {
+	retryable := IsRetryable(jobErr)
+	if retryable && task.Attempt < MaxJobAttempts {
+		return s.scheduleRetry(ctx, task, jobErr)
+	}
+
+	if err := s.db.Model(&models.Job{}).Where("job_id = ?", task.JobID).Updates(map[string]interface{}{
+		"status":        string(models.StatusFailed),
+		"error":         jobErr.Error(),
+		"next_retry_at": nil,
+		"updated_at":    time.Now(),
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update job status to failed: %w", err)
+	}
+
+	if retryable {
+		return s.deadLetter(ctx, task, jobErr)
+	}
+	return nil
+}
+
+func (s *JobService) scheduleRetry(ctx context.Context, task *JobTask, jobErr error) error {
+	retryAt := time.Now().Add(retryDelay(task.Attempt))
+	if err := s.db.Model(&models.Job{}).Where("job_id = ?", task.JobID).Updates(map[string]interface{}{
+		"status":        string(models.StatusRetrying),
+		"error":         jobErr.Error(),
+		"next_retry_at": retryAt,
+		"updated_at":    time.Now(),
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update job status to retrying: %w", err)
+	}
+
+	payload, err := task.queuePayload()
+	if err != nil {
+		return err
+	}
+	if err := s.redisClient.ZAdd(ctx, delayedKey, &redis.Z{Score: float64(retryAt.UnixMilli()), Member: payload}).Err(); err != nil {
+		return fmt.Errorf("failed to schedule job retry: %w", err)
+	}
+
+	log.Printf("Job %s failed on attempt %d of %d, retrying at %s: %v", task.JobID, task.Attempt, MaxJobAttempts, retryAt.Format(time.RFC3339), jobErr)
+	return nil
+}
+
+// promoteDueScript moves a batch of due retries onto the queue atomically, so each moves once however many
+// workers run it
+var promoteDueScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, task in ipairs(due) do
+	redis.call('ZREM', KEYS[1], task)
+	redis.call('LPUSH', KEYS[2], task)
+end
+return #due
+`)
+
+// PromoteDueRetries puts retries whose backoff has passed back on the queue and returns how many it moved
+func (s *JobService) PromoteDueRetries(ctx context.Context) (int, error) {
+	promoted := 0
+	for {
+		n, err := promoteDueScript.Run(ctx, s.redisClient, []string{delayedKey, queueKey}, time.Now().UnixMilli()).Int()
+		if err != nil {
+			return promoted, fmt.Errorf("failed to requeue due retries: %w", err)
+		}
+		promoted += n
+		if n < 100 {
+			return promoted, nil
+		}
+	}
+}
+
+// queuePayload returns the task as it was queued, or as it would be
+func (t *JobTask) queuePayload() (string, error) {
+	if t.payload != "" {
+		return t.payload, nil
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal job task: %w", err)
+	}
+	return string(data), nil
+}
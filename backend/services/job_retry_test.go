@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain error", errors.New("unsupported format"), false},
+		{"missing file", &fs.PathError{Op: "open", Path: "in.png", Err: fs.ErrNotExist}, false},
+		{"marked retryable", Retryable(errors.New("flaky")), true},
+		{"marked retryable and wrapped", fmt.Errorf("job processing failed: %w", Retryable(errors.New("flaky"))), true},
+		{"S3 server error", awserr.NewRequestFailure(awserr.New("InternalError", "oops", nil), 500, "req"), true},
+		{"S3 slow down", awserr.NewRequestFailure(awserr.New("SlowDown", "slow down", nil), 503, "req"), true},
+		{"S3 throttled", awserr.NewRequestFailure(awserr.New("Throttling", "rate exceeded", nil), 400, "req"), true},
+		{"S3 too many requests", awserr.NewRequestFailure(awserr.New("TooManyRequests", "busy", nil), 429, "req"), true},
+		{"S3 missing key", awserr.NewRequestFailure(awserr.New("NoSuchKey", "not found", nil), 404, "req"), false},
+		{"S3 access denied", awserr.NewRequestFailure(awserr.New("AccessDenied", "denied", nil), 403, "req"), false},
+		{"network timeout", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, true},
+		{"deadline", fmt.Errorf("ffmpeg: %w", context.DeadlineExceeded), true},
+		{"cancelled", context.Canceled, false},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"connection reset", fmt.Errorf("upload: %w", syscall.ECONNRESET), true},
+		{"broken pipe", os.NewSyscallError("write", syscall.EPIPE), true},
+		{"disk full", &fs.PathError{Op: "write", Path: "out.mp4", Err: syscall.ENOSPC}, true},
+		{"bad database connection", fmt.Errorf("save: %w", driver.ErrBadConn), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryableKeepsTheError(t *testing.T) {
+	if Retryable(nil) != nil {
+		t.Error("Retryable(nil) isn't nil")
+	}
+	cause := errors.New("flaky")
+	err := Retryable(cause)
+	if !errors.Is(err, cause) || err.Error() != "flaky" {
+		t.Errorf("Retryable(%v) = %v, which hides the cause", cause, err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		full    time.Duration // the delay before jitter takes up to half of it away
+	}{
+		{0, retryBaseDelay},
+		{1, retryBaseDelay},
+		{2, 2 * retryBaseDelay},
+		{3, 4 * retryBaseDelay},
+		{4, 8 * retryBaseDelay},
+		{6, 32 * retryBaseDelay},
+		{7, retryMaxDelay},
+		{MaxJobAttempts, 16 * retryBaseDelay},
+		{20, retryMaxDelay},
+		{100, retryMaxDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			want := min(tt.full, retryMaxDelay)
+			for i := 0; i < 100; i++ {
+				delay := retryDelay(tt.attempt)
+				if delay < want/2 || delay > want {
+					t.Fatalf("retryDelay(%d) = %s, want between %s and %s", tt.attempt, delay, want/2, want)
+				}
+			}
+		})
+	}
+}
+
+func TestQueuePayload(t *testing.T) {
+	task := &JobTask{JobID: "job", UserID: "user", TargetFormat: "png", Attempt: 3}
+	payload, err := task.queuePayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The attempt is counted on the job, so a retried task is queued as it was first queued
+	task.Attempt = 4
+	again, err := task.queuePayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload != again {
+		t.Errorf("payload changed with the attempt: %s, then %s", payload, again)
+	}
+
+	// A delivered task is acknowledged and requeued by the payload it was delivered as
+	delivered := &JobTask{JobID: "job", payload: `{"job_id":"job","extra":true}`}
+	if got, _ := delivered.queuePayload(); got != delivered.payload {
+		t.Errorf("queuePayload() = %s, want the delivered payload", got)
+	}
+}
@@ -10,43 +10,96 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/qoal/file-processor/models"
+	"github.com/qoal/file-processor/storage"
 )
 
 type JobService struct {
 	db          *gorm.DB
 	redisClient *redis.Client
+	storage     storage.Backend
 }
 
-func NewJobService(db *gorm.DB, redisClient *redis.Client) *JobService {
+func NewJobService(db *gorm.DB, redisClient *redis.Client, backend storage.Backend) *JobService {
 	return &JobService{
 		db:          db,
 		redisClient: redisClient,
+		storage:     backend,
 	}
 }
 
 type JobTask struct {
 	JobID        string                 `json:"job_id"`
 	UserID       string                 `json:"user_id"`
+	JobType      string                 `json:"job_type"`
 	InputPath    string                 `json:"input_path"`
+	InputPaths   []string               `json:"input_paths,omitempty"`
+	InputDigest  string                 `json:"input_digest,omitempty"`
 	OutputPath   string                 `json:"output_path"`
 	SourceFormat string                 `json:"source_format"`
 	TargetFormat string                 `json:"target_format"`
 	Settings     map[string]interface{} `json:"settings"`
 	CreatedAt    time.Time              `json:"created_at"`
+
+	Attempt int    `json:"-"` // counted on the job when a worker starts it
+	payload string // as queued, to acknowledge it by
 }
 
-// CreateJob creates a new processing job
+// CreateJob creates a new processing job. A job identical to one that has already run completes at once
+// with that job's output instead of being queued.
 func (s *JobService) CreateJob(ctx context.Context, job *models.Job, settings map[string]interface{}) error {
+	if job.JobType == "" {
+		job.JobType = models.JobTypeConvert
+	}
+
+	if job.InputDigest == "" && len(job.InputPaths) == 0 {
+		digest, err := s.blobDigest(job.UserID, job.InputPath)
+		if err != nil {
+			return err
+		}
+		job.InputDigest = digest
+	}
+
+	// The job holds a reference to each stored file it uses until it's deleted
+	held, err := s.acquireBlobs(ctx, job.UserID, jobInputs(job))
+	if err != nil {
+		return err
+	}
+	job.HeldBlobs = held
+
+	cached, err := s.cachedResult(ctx, job, settings)
+	if err != nil {
+		s.releaseBlobs(ctx, job.UserID, held)
+		return err
+	}
+	if cached != nil {
+		held = append(held, cached.OutputPath)
+		now := time.Now()
+		job.Status = string(models.StatusCompleted)
+		job.TargetFormat = cached.TargetFormat
+		job.OutputPath = cached.OutputPath
+		job.Manifest = cached.Manifest
+		job.Listing = cached.Listing
+		job.CompletedAt = &now
+	}
+
 	// Create job in database
 	if err := s.db.Create(job).Error; err != nil {
+		s.releaseBlobs(ctx, job.UserID, held)
 		return fmt.Errorf("failed to create job: %w", err)
 	}
 
+	if cached != nil {
+		return nil
+	}
+
 	// Create job task for queue
 	task := JobTask{
 		JobID:        job.JobID,
 		UserID:       job.UserID,
+		JobType:      job.JobType,
 		InputPath:    job.InputPath,
+		InputPaths:   job.InputPaths,
+		InputDigest:  job.InputDigest,
 		OutputPath:   job.OutputPath,
 		SourceFormat: job.SourceFormat,
 		TargetFormat: job.TargetFormat,
@@ -60,7 +113,7 @@ func (s *JobService) CreateJob(ctx context.Context, job *models.Job, settings ma
 		return fmt.Errorf("failed to marshal job task: %w", err)
 	}
 
-	if err := s.redisClient.LPush(ctx, "conversion_queue", taskData).Err(); err != nil {
+	if err := s.redisClient.LPush(ctx, queueKey, taskData).Err(); err != nil {
 		return fmt.Errorf("failed to add job to queue: %w", err)
 	}
 
@@ -105,6 +158,31 @@ func (s *JobService) UpdateJobStatus(ctx context.Context, jobID string, status m
 	return nil
 }
 
+// jobResultUpdates returns the columns for what a job produced beyond its output file: the manifest of a
+// responsive job, the listing of an archive listing job, and the target format an extract job settled on
+func jobResultUpdates(job *models.ProcessingJob) (map[string]interface{}, error) {
+	updates := map[string]interface{}{
+		"target_format": job.TargetFormat,
+	}
+
+	// Column updates bypass the fields' serializer, so store the JSON the model reads back
+	if job.Manifest != nil {
+		data, err := json.Marshal(job.Manifest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal job manifest: %w", err)
+		}
+		updates["manifest"] = string(data)
+	}
+	if job.Listing != nil {
+		data, err := json.Marshal(job.Listing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal job listing: %w", err)
+		}
+		updates["listing"] = string(data)
+	}
+	return updates, nil
+}
+
 // GetUserJobs retrieves all jobs for a user with pagination
 func (s *JobService) GetUserJobs(ctx context.Context, userID string, page, limit int) ([]models.Job, int64, error) {
 	var jobs []models.Job
@@ -128,26 +206,25 @@ func (s *JobService) GetUserJobs(ctx context.Context, userID string, page, limit
 	return jobs, total, nil
 }
 
-// GetNextJobFromQueue retrieves the next job from the Redis queue
-func (s *JobService) GetNextJobFromQueue(ctx context.Context) (*JobTask, error) {
-	result, err := s.redisClient.BRPop(ctx, 0, "conversion_queue").Result()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get job from queue: %w", err)
-	}
-
-	if len(result) < 2 {
-		return nil, fmt.Errorf("invalid queue result format")
+// jobInputs returns the storage keys a job reads
+func jobInputs(job *models.Job) []string {
+	if len(job.InputPaths) > 0 {
+		return job.InputPaths
 	}
+	return []string{job.InputPath}
+}
 
-	var task JobTask
-	if err := json.Unmarshal([]byte(result[1]), &task); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal job task: %w", err)
+// jobBlobs returns the keys of the stored files a job holds a reference to: the inputs it took one to when it was
+// created and, once completed, its output
+func jobBlobs(job *models.Job) []string {
+	keys := append([]string(nil), job.HeldBlobs...)
+	if job.Status == string(models.StatusCompleted) && job.OutputPath != "" {
+		keys = append(keys, job.OutputPath)
 	}
-
-	return &task, nil
+	return keys
 }
 
-// DeleteJob deletes a job and its associated files
+// DeleteJob deletes a job and releases its files, which are deleted once no other job uses them
 func (s *JobService) DeleteJob(ctx context.Context, jobID string, userID string) error {
 	var job models.Job
 	if err := s.db.Where("job_id = ? AND user_id = ?", jobID, userID).First(&job).Error; err != nil {
@@ -157,10 +234,11 @@ func (s *JobService) DeleteJob(ctx context.Context, jobID string, userID string)
 		return fmt.Errorf("failed to get job: %w", err)
 	}
 
-	// Delete from database
-	if err := s.db.Delete(&job).Error; err != nil {
-		return fmt.Errorf("failed to delete job: %w", err)
-	}
-
-	return nil
+	// The row goes with its references, so a failure leaves the job and everything it holds in place
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&job).Error; err != nil {
+			return fmt.Errorf("failed to delete job: %w", err)
+		}
+		return s.releaseBlobsIn(tx, userID, jobBlobs(&job))
+	})
 }
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/qoal/file-processor/models"
+)
+
+func TestJobBlobs(t *testing.T) {
+	tests := []struct {
+		name string
+		job  models.Job
+		want []string
+	}{
+		{
+			"pending job holds its inputs only",
+			models.Job{Status: string(models.StatusPending), InputPath: "blobs/u/aa/a.pdf", HeldBlobs: []string{"blobs/u/aa/a.pdf"}},
+			[]string{"blobs/u/aa/a.pdf"},
+		},
+		{
+			"inputs it couldn't take a reference to aren't released",
+			models.Job{Status: string(models.StatusPending), InputPaths: []string{"blobs/u/aa/a.pdf", "blobs/other/bb/b.pdf"}, HeldBlobs: []string{"blobs/u/aa/a.pdf"}},
+			[]string{"blobs/u/aa/a.pdf"},
+		},
+		{
+			"completed job holds its output",
+			models.Job{Status: string(models.StatusCompleted), InputPath: "uploads/x.png", OutputPath: "processed/j.webp"},
+			[]string{"processed/j.webp"},
+		},
+		{
+			"failed job's output path isn't its own",
+			models.Job{Status: string(models.StatusFailed), OutputPath: "processed/j.webp", HeldBlobs: []string{"blobs/u/aa/a.png"}},
+			[]string{"blobs/u/aa/a.png"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jobBlobs(&tt.job); !slices.Equal(got, tt.want) {
+				t.Errorf("jobBlobs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/qoal/file-processor/models"
+)
+
+// KeyService hands out per-user data keys for envelope encryption. Each key is random, stored sealed under
+// the master key with the user's ID as associated data, and kept unwrapped in memory once used.
+type KeyService struct {
+	db     *gorm.DB
+	master cipher.AEAD
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+// NewKeyService takes the master key as base64 of 32 random bytes
+func NewKeyService(db *gorm.DB, masterKey string) (*KeyService, error) {
+	key, err := base64.StdEncoding.DecodeString(masterKey)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, base64 encoded")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	master, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+
+	return &KeyService{db: db, master: master, cache: make(map[string][]byte)}, nil
+}
+
+// DataKey returns the user's data key, creating and storing one the first time
+func (s *KeyService) DataKey(userID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, exists := s.cache[userID]; exists {
+		return key, nil
+	}
+
+	var record models.UserKey
+	err := s.db.Where("user_id = ?", userID).First(&record).Error
+	if err == gorm.ErrRecordNotFound {
+		if record, err = s.createKey(userID); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get data key: %w", err)
+	}
+
+	nonceSize := s.master.NonceSize()
+	if len(record.WrappedKey) < nonceSize {
+		return nil, fmt.Errorf("stored data key is malformed")
+	}
+	key, err := s.master.Open(nil, record.WrappedKey[:nonceSize], record.WrappedKey[nonceSize:], []byte(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: wrong master key?")
+	}
+
+	s.cache[userID] = key
+	return key, nil
+}
+
+// createKey stores a new data key; if another instance got there first, its key wins and is returned
+func (s *KeyService) createKey(userID string) (models.UserKey, error) {
+	key := make([]byte, 32)
+	nonce := make([]byte, s.master.NonceSize())
+	if _, err := rand.Read(key); err != nil {
+		return models.UserKey{}, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return models.UserKey{}, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	record := models.UserKey{UserID: userID, WrappedKey: s.master.Seal(nonce, nonce, key, []byte(userID))}
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record).Error; err != nil {
+		return models.UserKey{}, fmt.Errorf("failed to store data key: %w", err)
+	}
+
+	var stored models.UserKey
+	if err := s.db.Where("user_id = ?", userID).First(&stored).Error; err != nil {
+		return models.UserKey{}, fmt.Errorf("failed to get data key: %w", err)
+	}
+	return stored, nil
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/qoal/file-processor/models"
+)
+
+// officeFamilies groups formats LibreOffice can convert between, keyed by the application that opens them
+var officeFamilies = map[string][]string{
+	"writer":  {"doc", "docx", "odt", "rtf", "txt"},
+	"calc":    {"xls", "xlsx", "ods", "csv"},
+	"impress": {"ppt", "pptx", "odp"},
+}
+
+// officeExportFilters pins the soffice --convert-to filter where the bare extension is ambiguous
+var officeExportFilters = map[string]string{
+	"docx": "docx:MS Word 2007 XML",
+	"doc":  "doc:MS Word 97",
+	"odt":  "odt:writer8",
+	"rtf":  "rtf:Rich Text Format",
+	"txt":  "txt:Text (encoded):UTF8",
+	"xlsx": "xlsx:Calc MS Excel 2007 XML",
+	"xls":  "xls:MS Excel 97",
+	"ods":  "ods:calc8",
+	"csv":  "csv:Text - txt - csv (StarCalc):44,34,76", // comma, double quote, UTF-8
+	"pptx": "pptx:Impress MS PowerPoint 2007 XML",
+	"ppt":  "ppt:MS PowerPoint 97",
+	"odp":  "odp:impress8",
+}
+
+// officeFamily returns the LibreOffice application family for a format, or "" if none
+func officeFamily(format string) string {
+	format = strings.ToLower(format)
+	for family, formats := range officeFamilies {
+		for _, f := range formats {
+			if f == format {
+				return family
+			}
+		}
+	}
+	return ""
+}
+
+// CanConvertWithOffice reports whether the LibreOffice backend handles source to target
+func CanConvertWithOffice(source, target string) bool {
+	source, target = strings.ToLower(source), strings.ToLower(target)
+	if source == target {
+		return false
+	}
+
+	sourceFamily := officeFamily(source)
+	switch {
+	case source == "pdf":
+		// PDFs are imported through Writer, which only round-trips to text documents
+		return officeFamily(target) == "writer"
+	case sourceFamily == "":
+		return false
+	case target == "pdf":
+		return true
+	default:
+		return officeFamily(target) == sourceFamily
+	}
+}
+
+// convertWithLibreOffice runs headless soffice with a throwaway user profile so parallel jobs don't share state
+func (p *EnhancedDocumentProcessor) convertWithLibreOffice(input, output string, job *models.ProcessingJob) (string, error) {
+	target := strings.ToLower(job.TargetFormat)
+
+	workDir, err := os.MkdirTemp(p.config.TempDir, job.JobID+"_soffice_")
+	if err != nil {
+		return "", fmt.Errorf("failed to create LibreOffice work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	profileDir, err := filepath.Abs(filepath.Join(workDir, "profile"))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve LibreOffice profile path: %w", err)
+	}
+	outDir := filepath.Join(workDir, "out")
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create LibreOffice output directory: %w", err)
+	}
+
+	filter, exists := officeExportFilters[target]
+	if !exists {
+		filter = target
+	}
+
+	profileURL := url.URL{Scheme: "file", Path: filepath.ToSlash(profileDir)}
+	args := []string{
+		"-env:UserInstallation=" + profileURL.String(),
+		"--headless", "--invisible", "--nologo", "--nodefault", "--norestore", "--nolockcheck",
+	}
+	if strings.EqualFold(job.SourceFormat, "pdf") {
+		args = append(args, "--infilter=writer_pdf_import")
+	}
+	args = append(args, "--convert-to", filter, "--outdir", outDir, input)
+
+	if err := p.executor.ExecuteCommand("libreoffice", args); err != nil {
+		return "", fmt.Errorf("LibreOffice conversion failed: %w", err)
+	}
+
+	// soffice names the result after the input; it exits 0 even when the export fails
+	converted := filepath.Join(outDir, strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))+"."+target)
+	if _, err := os.Stat(converted); err != nil {
+		return "", fmt.Errorf("LibreOffice produced no %s output", target)
+	}
+
+	if err := moveFile(converted, output); err != nil {
+		return "", fmt.Errorf("failed to move converted document: %w", err)
+	}
+
+	return output, nil
+}
+
+// moveFile renames src to dst, copying when they sit on different filesystems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qoal/file-processor/models"
+	"github.com/qoal/file-processor/utils"
+	"github.com/unidoc/unioffice/spreadsheet"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// CSVOptions controls how CSV files are read and written
+type CSVOptions struct {
+	Delimiter  rune
+	Encoding   encoding.Encoding
+	UseCRLF    bool
+	InferTypes bool
+}
+
+// csvNumberPattern matches plain decimal numbers; ParseFloat alone would also accept "NaN", "Inf" and hex
+var csvNumberPattern = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?$`)
+
+// csvDateLayouts are the unambiguous date formats promoted to date cells
+var csvDateLayouts = []struct {
+	layout   string
+	withTime bool
+}{
+	{"2006-01-02", false},
+	{"2006/01/02", false},
+	{"2006-01-02 15:04:05", true},
+	{"2006-01-02T15:04:05", true},
+	{time.RFC3339, true},
+}
+
+func getCSVOptions(settings map[string]interface{}) (CSVOptions, error) {
+	opts := CSVOptions{}
+
+	delimiter, err := utils.GetStringSetting(settings, "delimiter", ",")
+	if err != nil {
+		return opts, err
+	}
+	switch delimiter {
+	case "tab", `\t`:
+		delimiter = "\t"
+	}
+	runes := []rune(delimiter)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' {
+		return opts, fmt.Errorf("invalid CSV delimiter: %q", delimiter)
+	}
+	opts.Delimiter = runes[0]
+
+	encodingName, err := utils.GetStringSetting(settings, "encoding", "utf-8")
+	if err != nil {
+		return opts, err
+	}
+	if opts.Encoding, err = htmlindex.Get(encodingName); err != nil {
+		return opts, fmt.Errorf("unsupported CSV encoding: %s", encodingName)
+	}
+
+	lineEnding, err := utils.GetStringSetting(settings, "line_ending", "crlf")
+	if err != nil {
+		return opts, err
+	}
+	switch strings.ToLower(lineEnding) {
+	case "crlf":
+		opts.UseCRLF = true
+	case "lf":
+		opts.UseCRLF = false
+	default:
+		return opts, fmt.Errorf("unsupported line ending: %s", lineEnding)
+	}
+
+	if opts.InferTypes, err = utils.GetBoolSetting(settings, "infer_types", true); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+func (p *EnhancedDocumentProcessor) convertXlsxToCSV(input, output string, job *models.ProcessingJob) (string, error) {
+	opts, err := getCSVOptions(job.Settings)
+	if err != nil {
+		return "", err
+	}
+
+	ss, err := spreadsheet.Open(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to open XLSX: %w", err)
+	}
+	defer ss.Close()
+
+	sheet, err := selectSheet(ss, job.Settings)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return "", fmt.Errorf("failed to create CSV file: %w", err)
+	}
+	defer out.Close()
+
+	if err := writeSheetCSV(sheet, out, opts); err != nil {
+		return "", err
+	}
+
+	return output, nil
+}
+
+// convertXlsxToZip exports every sheet as its own CSV inside a zip
+func (p *EnhancedDocumentProcessor) convertXlsxToZip(input, output string, job *models.ProcessingJob) (string, error) {
+	opts, err := getCSVOptions(job.Settings)
+	if err != nil {
+		return "", err
+	}
+
+	ss, err := spreadsheet.Open(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to open XLSX: %w", err)
+	}
+	defer ss.Close()
+
+	out, err := os.Create(output)
+	if err != nil {
+		return "", fmt.Errorf("failed to create zip file: %w", err)
+	}
+	defer out.Close()
+
+	archive := zip.NewWriter(out)
+	used := make(map[string]int)
+	for i, sheet := range ss.Sheets() {
+		name := csvFileName(sheet.Name(), i+1, used)
+		entry, err := archive.Create(name)
+		if err != nil {
+			return "", fmt.Errorf("failed to add %s to zip: %w", name, err)
+		}
+		if err := writeSheetCSV(sheet, entry, opts); err != nil {
+			return "", err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return "", fmt.Errorf("failed to finalize zip file: %w", err)
+	}
+
+	return output, nil
+}
+
+func (p *EnhancedDocumentProcessor) convertCSVToXlsx(input, output string, job *models.ProcessingJob) (string, error) {
+	opts, err := getCSVOptions(job.Settings)
+	if err != nil {
+		return "", err
+	}
+
+	sheetName, err := utils.GetStringSetting(job.Settings, "sheet_name", "Sheet1")
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to read CSV file: %w", err)
+	}
+	defer file.Close()
+
+	// A byte order mark overrides the configured encoding
+	decoder := unicode.BOMOverride(opts.Encoding.NewDecoder())
+	reader := csv.NewReader(transform.NewReader(file, decoder))
+	reader.Comma = opts.Delimiter
+	reader.FieldsPerRecord = -1
+
+	ss := spreadsheet.New()
+	defer ss.Close()
+
+	sheet := ss.AddSheet()
+	sheet.SetName(sheetName)
+
+	dateStyle := ss.StyleSheet.GetOrCreateStandardNumberFormat(spreadsheet.StandardFormat14)
+	dateTimeStyle := ss.StyleSheet.GetOrCreateStandardNumberFormat(spreadsheet.StandardFormat22)
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse CSV: %w", err)
+		}
+
+		row := sheet.AddRow()
+		for _, value := range record {
+			cell := row.AddCell()
+			if !opts.InferTypes {
+				cell.SetString(value)
+				continue
+			}
+			setInferredCellValue(cell, value, dateStyle, dateTimeStyle)
+		}
+	}
+
+	if err := ss.SaveToFile(output); err != nil {
+		return "", fmt.Errorf("failed to save XLSX file: %w", err)
+	}
+
+	return output, nil
+}
+
+// selectSheet picks the sheet named or numbered (1-based) by the "sheet" setting, defaulting to the first
+func selectSheet(ss *spreadsheet.Workbook, settings map[string]interface{}) (spreadsheet.Sheet, error) {
+	sheets := ss.Sheets()
+	if len(sheets) == 0 {
+		return spreadsheet.Sheet{}, fmt.Errorf("workbook has no sheets")
+	}
+
+	switch v := settings["sheet"].(type) {
+	case nil:
+		return sheets[0], nil
+	case float64, int:
+		index, _ := utils.GetIntSetting(settings, "sheet", 1)
+		if index < 1 || index > len(sheets) {
+			return spreadsheet.Sheet{}, fmt.Errorf("sheet %d out of range (workbook has %d)", index, len(sheets))
+		}
+		return sheets[index-1], nil
+	case string:
+		for _, sheet := range sheets {
+			if sheet.Name() == v {
+				return sheet, nil
+			}
+		}
+		if index, err := strconv.Atoi(v); err == nil && index >= 1 && index <= len(sheets) {
+			return sheets[index-1], nil
+		}
+		return spreadsheet.Sheet{}, fmt.Errorf("sheet not found: %s", v)
+	default:
+		return spreadsheet.Sheet{}, fmt.Errorf("invalid type for setting sheet")
+	}
+}
+
+// writeSheetCSV writes a sheet through encoding/csv, keeping empty cells and rows so columns line up
+func writeSheetCSV(sheet spreadsheet.Sheet, w io.Writer, opts CSVOptions) error {
+	encoded := transform.NewWriter(w, opts.Encoding.NewEncoder())
+	writer := csv.NewWriter(encoded)
+	writer.Comma = opts.Delimiter
+	writer.UseCRLF = opts.UseCRLF
+
+	maxCol := sheet.MaxColumnIdx()
+	width := int(maxCol) + 1
+	nextRow := uint32(1)
+
+	for _, row := range sheet.Rows() {
+		for ; nextRow < row.RowNumber(); nextRow++ {
+			if err := writer.Write(make([]string, width)); err != nil {
+				return fmt.Errorf("failed to write CSV row: %w", err)
+			}
+		}
+		nextRow = row.RowNumber() + 1
+
+		cells := row.CellsWithEmpty(maxCol)
+		record := make([]string, width)
+		for i := 0; i < len(cells) && i < width; i++ {
+			record[i] = cells[i].GetFormattedValue()
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV row: %w", err)
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV file: %w", err)
+	}
+	// The encoder holds back a partial rune until it is closed, and reports one that can't be encoded
+	if err := encoded.Close(); err != nil {
+		return fmt.Errorf("failed to encode CSV file: %w", err)
+	}
+	return nil
+}
+
+// setInferredCellValue stores value as a number, boolean or date when it unambiguously is one
+func setInferredCellValue(cell spreadsheet.Cell, value string, dateStyle, dateTimeStyle spreadsheet.CellStyle) {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		if value != "" {
+			cell.SetString(value)
+		}
+		return
+	}
+
+	if csvNumberPattern.MatchString(trimmed) && !hasSignificantLeadingZero(trimmed) && countDigits(trimmed) <= 15 {
+		if number, err := strconv.ParseFloat(trimmed, 64); err == nil {
+			cell.SetNumber(number)
+			return
+		}
+	}
+
+	switch strings.ToLower(trimmed) {
+	case "true":
+		cell.SetBool(true)
+		return
+	case "false":
+		cell.SetBool(false)
+		return
+	}
+
+	for _, candidate := range csvDateLayouts {
+		if t, err := time.Parse(candidate.layout, trimmed); err == nil {
+			cell.SetDate(t)
+			if candidate.withTime {
+				cell.SetStyle(dateTimeStyle)
+			} else {
+				cell.SetStyle(dateStyle)
+			}
+			return
+		}
+	}
+
+	cell.SetString(value)
+}
+
+// hasSignificantLeadingZero spots identifiers like "007" or ZIP codes that must stay text
+func hasSignificantLeadingZero(s string) bool {
+	s = strings.TrimLeft(s, "+-")
+	return len(s) > 1 && s[0] == '0' && s[1] != '.'
+}
+
+// countDigits counts mantissa digits; Excel keeps 15 significant digits, so longer values stay text
+func countDigits(s string) int {
+	if idx := strings.IndexAny(s, "eE"); idx != -1 {
+		s = s[:idx]
+	}
+	count := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			count++
+		}
+	}
+	return count
+}
+
+// csvFileName turns a sheet name into a unique, filesystem-safe zip entry name
+func csvFileName(sheetName string, index int, used map[string]int) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(sheetName))
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", index)
+	}
+
+	used[name]++
+	if used[name] > 1 {
+		name = fmt.Sprintf("%s_%d", name, used[name])
+	}
+	return name + ".csv"
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/unidoc/unioffice/spreadsheet"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestGetCSVOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		settings  map[string]interface{}
+		delimiter rune
+		encoding  string
+		crlf      bool
+		infer     bool
+		wantErr   bool
+	}{
+		{"defaults", nil, ',', "utf-8", true, true, false},
+		{"tab by name", map[string]interface{}{"delimiter": "tab"}, '\t', "utf-8", true, true, false},
+		{"escaped tab", map[string]interface{}{"delimiter": `\t`}, '\t', "utf-8", true, true, false},
+		{"semicolon, latin-1, lf, no inference", map[string]interface{}{"delimiter": ";", "encoding": "latin1", "line_ending": "LF", "infer_types": false}, ';', "windows-1252", false, false, false},
+		{"multi-character delimiter", map[string]interface{}{"delimiter": "::"}, 0, "", false, false, true},
+		{"quote delimiter", map[string]interface{}{"delimiter": `"`}, 0, "", false, false, true},
+		{"newline delimiter", map[string]interface{}{"delimiter": "\n"}, 0, "", false, false, true},
+		{"empty delimiter", map[string]interface{}{"delimiter": ""}, 0, "", false, false, true},
+		{"unknown encoding", map[string]interface{}{"encoding": "klingon"}, 0, "", false, false, true},
+		{"unknown line ending", map[string]interface{}{"line_ending": "cr"}, 0, "", false, false, true},
+		{"infer_types not a bool", map[string]interface{}{"infer_types": "yes"}, 0, "", false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := getCSVOptions(tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getCSVOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if opts.Delimiter != tt.delimiter || opts.UseCRLF != tt.crlf || opts.InferTypes != tt.infer {
+				t.Errorf("getCSVOptions() = %+v", opts)
+			}
+			if name, _ := htmlindex.Name(opts.Encoding); name != tt.encoding {
+				t.Errorf("encoding = %s, want %s", name, tt.encoding)
+			}
+		})
+	}
+}
+
+func TestSetInferredCellValue(t *testing.T) {
+	ss := spreadsheet.New()
+	defer ss.Close()
+	sheet := ss.AddSheet()
+	row := sheet.AddRow()
+	dateStyle := ss.StyleSheet.GetOrCreateStandardNumberFormat(spreadsheet.StandardFormat14)
+	dateTimeStyle := ss.StyleSheet.GetOrCreateStandardNumberFormat(spreadsheet.StandardFormat22)
+
+	tests := []struct {
+		value string
+		want  string // number, bool, date or string
+	}{
+		{"42", "number"},
+		{"-3.5", "number"},
+		{" 1e3 ", "number"},
+		{".5", "number"},
+		{"0.25", "number"},
+		{"0", "number"},
+		{"007", "string"},
+		{"02134", "string"},
+		{"1234567890123456", "string"},
+		{"NaN", "string"},
+		{"Inf", "string"},
+		{"0x1F", "string"},
+		{"TRUE", "bool"},
+		{"false", "bool"},
+		{"2024-03-01", "date"},
+		{"2024/03/01", "date"},
+		{"2024-03-01 10:30:00", "date"},
+		{"2024-03-01T10:30:00Z", "date"},
+		{"03/01/2024", "string"},
+		{"hello", "string"},
+		{"   ", "string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			cell := row.AddCell()
+			setInferredCellValue(cell, tt.value, dateStyle, dateTimeStyle)
+
+			var got string
+			switch {
+			case cell.IsBool():
+				got = "bool"
+			case cell.IsNumber() && cell.X().SAttr != nil:
+				got = "date"
+			case cell.IsNumber():
+				got = "number"
+			default:
+				got = "string"
+				if s := cell.GetString(); s != tt.value {
+					t.Errorf("string cell = %q, want %q", s, tt.value)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("setInferredCellValue(%q) stored a %s, want a %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasSignificantLeadingZero(t *testing.T) {
+	tests := map[string]bool{
+		"007":   true,
+		"-007":  true,
+		"+01":   true,
+		"0":     false,
+		"0.5":   false,
+		"-0.5":  false,
+		"10":    false,
+		"100.0": false,
+	}
+	for value, want := range tests {
+		if got := hasSignificantLeadingZero(value); got != want {
+			t.Errorf("hasSignificantLeadingZero(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestCountDigits(t *testing.T) {
+	tests := map[string]int{
+		"123":      3,
+		"-1.25":    3,
+		"1.5e300":  2,
+		"+.5E-3":   1,
+		"12345678": 8,
+	}
+	for value, want := range tests {
+		if got := countDigits(value); got != want {
+			t.Errorf("countDigits(%q) = %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestCSVFileName(t *testing.T) {
+	used := make(map[string]int)
+	tests := []struct {
+		sheet string
+		index int
+		want  string
+	}{
+		{"Sales", 1, "Sales.csv"},
+		{"Q1/Q2: \"Totals\"", 2, "Q1_Q2_ _Totals_.csv"},
+		{"   ", 3, "Sheet3.csv"},
+		{"Sales", 4, "Sales_2.csv"},
+		{"Sales", 5, "Sales_3.csv"},
+		{"tab\there", 6, "tab_here.csv"},
+		{`..\..\evil`, 7, ".._.._evil.csv"},
+	}
+
+	for _, tt := range tests {
+		if got := csvFileName(tt.sheet, tt.index, used); got != tt.want {
+			t.Errorf("csvFileName(%q) = %q, want %q", tt.sheet, got, tt.want)
+		}
+	}
+}
+
+func TestWriteSheetCSV(t *testing.T) {
+	ss := spreadsheet.New()
+	defer ss.Close()
+	sheet := ss.AddSheet()
+	sheet.Cell("A1").SetString("name")
+	sheet.Cell("B1").SetString("city")
+	sheet.Cell("A3").SetString("Zoë")
+	sheet.Cell("B3").SetString("Zürich")
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     []byte
+	}{
+		{"utf-8 with crlf and a blank row kept", nil, []byte("name,city\r\n,\r\nZoë,Zürich\r\n")},
+		{"semicolons and lf", map[string]interface{}{"delimiter": ";", "line_ending": "lf"}, []byte("name;city\n;\nZoë;Zürich\n")},
+		{"latin-1", map[string]interface{}{"encoding": "latin1", "line_ending": "lf"}, []byte("name,city\n,\nZo\xeb,Z\xfcrich\n")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := getCSVOptions(tt.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := writeSheetCSV(sheet, &out, opts); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), tt.want) {
+				t.Errorf("writeSheetCSV() = %q, want %q", out.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteSheetCSVUnencodable(t *testing.T) {
+	ss := spreadsheet.New()
+	defer ss.Close()
+	sheet := ss.AddSheet()
+	sheet.Cell("A1").SetString("東京")
+
+	opts, err := getCSVOptions(map[string]interface{}{"encoding": "latin1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSheetCSV(sheet, io.Discard, opts); err == nil {
+		t.Error("text latin-1 can't hold was written without error")
+	}
+}
+
+func TestWriteSheetCSVUTF16(t *testing.T) {
+	ss := spreadsheet.New()
+	defer ss.Close()
+	sheet := ss.AddSheet()
+	sheet.Cell("A1").SetString("ok")
+
+	opts, err := getCSVOptions(map[string]interface{}{"encoding": "utf-16le", "line_ending": "lf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := writeSheetCSV(sheet, &out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder().Bytes(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(decoded), "ok\n") {
+		t.Errorf("decoded output = %q", decoded)
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/qoal/file-processor/storage"
+)
+
+// pendingUploadsKey is a sorted set of the uploads of unclaimed sessions, scored by when each can be cleaned up.
+// S3 keeps, and bills for, an object sent by a single PUT, or the parts of a multipart upload, until it is
+// deleted or the upload is aborted.
+const pendingUploadsKey = "upload_session:pending"
+
+// uploadCleanupMargin delays cleanup past a session's expiry, so a completion that got the session just before
+// it expired finishes before its object is deleted
+const uploadCleanupMargin = time.Minute
+
+// UploadSession remembers what a client was cleared to upload directly to S3, so completing the upload
+// can check the object against it before a job is created
+type UploadSession struct {
+	ID               string                 `json:"id"`
+	UserID           string                 `json:"user_id"`
+	Key              string                 `json:"key"`
+	MultipartID      string                 `json:"multipart_id,omitempty"` // S3 upload ID when sent in parts
+	OriginalFilename string                 `json:"original_filename"`
+	Size             int64                  `json:"size"`
+	MD5              string                 `json:"md5,omitempty"` // hex; signed into a single-part upload, so S3 checks it
+	SourceFormat     string                 `json:"source_format"`
+	TargetFormat     string                 `json:"target_format"`
+	Settings         map[string]interface{} `json:"settings"`
+	ExpiresAt        time.Time              `json:"expires_at"`
+}
+
+// pendingUpload identifies the object, and the multipart upload if sent in parts, to clean up if its session expires
+type pendingUpload struct {
+	Key         string `json:"key"`
+	MultipartID string `json:"multipart_id,omitempty"`
+}
+
+func uploadSessionKey(id string) string {
+	return "upload_session:" + id
+}
+
+// pendingUploadMember returns the session's entry in pendingUploadsKey
+func (session *UploadSession) pendingUploadMember() (string, error) {
+	data, err := json.Marshal(pendingUpload{Key: session.Key, MultipartID: session.MultipartID})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal pending upload: %w", err)
+	}
+	return string(data), nil
+}
+
+// SaveUploadSession stores a session until it expires; whatever was uploaded is cleaned up if it expires unclaimed
+func (s *JobService) SaveUploadSession(ctx context.Context, session *UploadSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload session: %w", err)
+	}
+	member, err := session.pendingUploadMember()
+	if err != nil {
+		return err
+	}
+
+	pipe := s.redisClient.TxPipeline()
+	pipe.Set(ctx, uploadSessionKey(session.ID), data, time.Until(session.ExpiresAt))
+	cleanupAt := session.ExpiresAt.Add(uploadCleanupMargin)
+	pipe.ZAdd(ctx, pendingUploadsKey, &redis.Z{Score: float64(cleanupAt.Unix()), Member: member})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save upload session: %w", err)
+	}
+	return nil
+}
+
+// GetUploadSession returns a user's unexpired session
+func (s *JobService) GetUploadSession(ctx context.Context, id, userID string) (*UploadSession, error) {
+	data, err := s.redisClient.Get(ctx, uploadSessionKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("upload session not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload session: %w", err)
+	}
+
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal upload session: %w", err)
+	}
+	if session.UserID != userID {
+		return nil, fmt.Errorf("upload session not found")
+	}
+	return &session, nil
+}
+
+// ClaimUploadSession ends a session so it can't be completed twice; only one caller can claim it.
+// Its object then belongs to a job, or is deleted by the caller, so it is no longer one to clean up.
+func (s *JobService) ClaimUploadSession(ctx context.Context, session *UploadSession) error {
+	deleted, err := s.redisClient.Del(ctx, uploadSessionKey(session.ID)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to claim upload session: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("upload session already completed")
+	}
+
+	member, err := session.pendingUploadMember()
+	if err != nil {
+		return err
+	}
+	if err := s.redisClient.ZRem(ctx, pendingUploadsKey, member).Err(); err != nil {
+		return fmt.Errorf("failed to claim upload session: %w", err)
+	}
+	return nil
+}
+
+// CleanupExpiredUploads deletes what was sent for sessions that expired unclaimed, aborting a multipart upload
+// and deleting its object, and returns how many uploads it cleaned up. Each is cleaned up once however many
+// workers run it.
+func (s *JobService) CleanupExpiredUploads(ctx context.Context) (int, error) {
+	uploader, ok := s.storage.(storage.DirectUploader)
+	if !ok {
+		return 0, nil
+	}
+
+	expired, err := s.redisClient.ZRangeByScoreWithScores(ctx, pendingUploadsKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get expired uploads: %w", err)
+	}
+
+	cleaned := 0
+	for _, entry := range expired {
+		member, _ := entry.Member.(string)
+		// Whoever removes the entry cleans up the upload
+		removed, err := s.redisClient.ZRem(ctx, pendingUploadsKey, member).Result()
+		if err != nil {
+			return cleaned, fmt.Errorf("failed to claim expired upload: %w", err)
+		}
+		if removed == 0 {
+			continue
+		}
+
+		var pending pendingUpload
+		if err := json.Unmarshal([]byte(member), &pending); err != nil {
+			return cleaned, fmt.Errorf("failed to unmarshal pending upload: %w", err)
+		}
+		if err := cleanupUpload(uploader, s.storage, pending); err != nil {
+			// Put it back for the next sweep
+			s.redisClient.ZAdd(ctx, pendingUploadsKey, &entry)
+			return cleaned, err
+		}
+		cleaned++
+	}
+	return cleaned, nil
+}
+
+// cleanupUpload aborts an unfinished multipart upload, deleting its parts, and deletes the object a single PUT,
+// or a multipart upload completed before its session expired, left behind
+func cleanupUpload(uploader storage.DirectUploader, store storage.Backend, pending pendingUpload) error {
+	if pending.MultipartID != "" {
+		if err := uploader.AbortMultipartUpload(pending.Key, pending.MultipartID); err != nil {
+			return err
+		}
+	}
+	return store.Delete(pending.Key)
+}
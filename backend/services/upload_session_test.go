@@ -0,0 +1,65 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/qoal/file-processor/storage"
+)
+
+// recordingUploader records the cleanup calls made on it; any other call panics on the nil interfaces
+type recordingUploader struct {
+	storage.Backend
+	storage.DirectUploader
+	abortErr error
+	calls    []string
+}
+
+func (u *recordingUploader) AbortMultipartUpload(key, uploadID string) error {
+	u.calls = append(u.calls, "abort "+key+" "+uploadID)
+	return u.abortErr
+}
+
+func (u *recordingUploader) Delete(key string) error {
+	u.calls = append(u.calls, "delete "+key)
+	return nil
+}
+
+func TestCleanupUpload(t *testing.T) {
+	tests := []struct {
+		name     string
+		pending  pendingUpload
+		abortErr error
+		calls    []string
+		wantErr  bool
+	}{
+		{"single PUT", pendingUpload{Key: "uploads/a.pdf"}, nil, []string{"delete uploads/a.pdf"}, false},
+		{"multipart", pendingUpload{Key: "uploads/b.mp4", MultipartID: "mp"}, nil, []string{"abort uploads/b.mp4 mp", "delete uploads/b.mp4"}, false},
+		{"abort fails", pendingUpload{Key: "uploads/b.mp4", MultipartID: "mp"}, errors.New("unavailable"), []string{"abort uploads/b.mp4 mp"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploader := &recordingUploader{abortErr: tt.abortErr}
+			err := cleanupUpload(uploader, uploader, tt.pending)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("cleanupUpload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(uploader.calls, tt.calls) {
+				t.Errorf("calls = %q, want %q", uploader.calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestPendingUploadMember(t *testing.T) {
+	single, _ := (&UploadSession{ID: "s1", Key: "uploads/a.pdf"}).pendingUploadMember()
+	if single != `{"key":"uploads/a.pdf"}` {
+		t.Errorf("single PUT member = %s", single)
+	}
+	multipart, _ := (&UploadSession{ID: "s2", Key: "uploads/b.mp4", MultipartID: "mp"}).pendingUploadMember()
+	if multipart != `{"key":"uploads/b.mp4","multipart_id":"mp"}` {
+		t.Errorf("multipart member = %s", multipart)
+	}
+}
@@ -3,83 +3,173 @@ package services
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/qoal/file-processor/models"
+	"github.com/qoal/file-processor/utils"
 )
 
-// Video conversion functions using simple file operations
-// In production, these would use proper video processing libraries like FFmpeg
-
-func (p *EnhancedVideoProcessor) convertMP4toAVI(input, output string, job *models.ProcessingJob) (string, error) {
-	// For now, just copy the file with AVI extension
-	// Real implementation would require video transcoding
-	return p.copyVideoFile(input, output)
+// VideoCodecProfile describes the encoders and container flags ffmpeg should use for a target format
+type VideoCodecProfile struct {
+	VideoCodec   string
+	AudioCodec   string
+	AudioBitrate string
+	ExtraArgs    []string
 }
 
-func (p *EnhancedVideoProcessor) convertAVItoMP4(input, output string, job *models.ProcessingJob) (string, error) {
-	// For now, just copy the file with MP4 extension
-	// Real implementation would require video transcoding
-	return p.copyVideoFile(input, output)
+// videoCodecProfiles maps each output container to codecs it can carry
+var videoCodecProfiles = map[string]VideoCodecProfile{
+	"mp4": {
+		VideoCodec: "libx264", AudioCodec: "aac", AudioBitrate: "128k",
+		ExtraArgs: []string{"-pix_fmt", "yuv420p", "-movflags", "+faststart"},
+	},
+	"mov": {
+		VideoCodec: "libx264", AudioCodec: "aac", AudioBitrate: "128k",
+		ExtraArgs: []string{"-pix_fmt", "yuv420p", "-movflags", "+faststart"},
+	},
+	"mkv": {
+		VideoCodec: "libx264", AudioCodec: "aac", AudioBitrate: "128k",
+		ExtraArgs: []string{"-pix_fmt", "yuv420p"},
+	},
+	"webm": {
+		VideoCodec: "libvpx-vp9", AudioCodec: "libopus", AudioBitrate: "96k",
+		ExtraArgs: []string{"-row-mt", "1", "-deadline", "good", "-cpu-used", "4"},
+	},
+	"avi": {
+		VideoCodec: "mpeg4", AudioCodec: "libmp3lame", AudioBitrate: "128k",
+		ExtraArgs: []string{"-vtag", "xvid"},
+	},
+	"flv": {
+		VideoCodec: "libx264", AudioCodec: "aac", AudioBitrate: "128k",
+		ExtraArgs: []string{"-pix_fmt", "yuv420p", "-ar", "44100"},
+	},
+	"wmv": {
+		VideoCodec: "wmv2", AudioCodec: "wmav2", AudioBitrate: "128k",
+	},
 }
 
-func (p *EnhancedVideoProcessor) convertMP4toMOV(input, output string, job *models.ProcessingJob) (string, error) {
-	// For now, just copy the file with MOV extension
-	// Real implementation would require video transcoding
-	return p.copyVideoFile(input, output)
+// GetVideoCodecProfile returns the codec profile for a target container
+func GetVideoCodecProfile(targetFormat string) (VideoCodecProfile, error) {
+	profile, exists := videoCodecProfiles[strings.ToLower(targetFormat)]
+	if !exists {
+		return VideoCodecProfile{}, fmt.Errorf("no codec profile for video format: %s", targetFormat)
+	}
+	return profile, nil
 }
 
-func (p *EnhancedVideoProcessor) convertMOVtoMP4(input, output string, job *models.ProcessingJob) (string, error) {
-	// For now, just copy the file with MP4 extension
-	// Real implementation would require video transcoding
-	return p.copyVideoFile(input, output)
-}
+// transcodeVideo re-encodes input into the target container through ffmpeg
+func (p *EnhancedVideoProcessor) transcodeVideo(input, output string, job *models.ProcessingJob) (string, error) {
+	profile, err := GetVideoCodecProfile(job.TargetFormat)
+	if err != nil {
+		return "", err
+	}
 
-func (p *EnhancedVideoProcessor) convertMP4toWEBM(input, output string, job *models.ProcessingJob) (string, error) {
-	// For now, just copy the file with WEBM extension
-	// Real implementation would require video transcoding
-	return p.copyVideoFile(input, output)
-}
+	args := p.buildTranscodeArgs(input, output, profile, p.getVideoPreset(job.Settings))
+	if err := p.executor.ExecuteCommand("ffmpeg", args); err != nil {
+		return "", fmt.Errorf("ffmpeg transcode failed: %w", err)
+	}
 
-func (p *EnhancedVideoProcessor) convertWEBMtoMP4(input, output string, job *models.ProcessingJob) (string, error) {
-	// For now, just copy the file with MP4 extension
-	// Real implementation would require video transcoding
-	return p.copyVideoFile(input, output)
+	return output, nil
 }
 
-func (p *EnhancedVideoProcessor) convertMP4toMKV(input, output string, job *models.ProcessingJob) (string, error) {
-	// For now, just copy the file with MKV extension
-	// Real implementation would require video transcoding
-	return p.copyVideoFile(input, output)
-}
+// buildTranscodeArgs assembles the ffmpeg argument list for a profile and preset
+func (p *EnhancedVideoProcessor) buildTranscodeArgs(input, output string, profile VideoCodecProfile, preset VideoPreset) []string {
+	// Fit inside the preset box without upscaling or distorting, and keep
+	// dimensions even since most encoders reject odd sizes
+	scaleFilter := fmt.Sprintf(
+		"scale=w='min(%d,iw)':h='min(%d,ih)':force_original_aspect_ratio=decrease,scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		preset.Width, preset.Height,
+	)
+
+	args := []string{
+		"-hide_banner", "-nostdin", "-loglevel", "error", "-y",
+		"-i", input,
+		"-map", "0:v:0", "-map", "0:a?",
+		"-vf", scaleFilter,
+		"-c:v", profile.VideoCodec,
+		"-b:v", preset.Bitrate,
+		"-c:a", profile.AudioCodec,
+		"-b:a", profile.AudioBitrate,
+	}
+	args = append(args, profile.ExtraArgs...)
 
-func (p *EnhancedVideoProcessor) convertMKVtoMP4(input, output string, job *models.ProcessingJob) (string, error) {
-	// For now, just copy the file with MP4 extension
-	// Real implementation would require video transcoding
-	return p.copyVideoFile(input, output)
+	return append(args, output)
 }
 
-// copyVideoFile is a helper function to copy video files
-func (p *EnhancedVideoProcessor) copyVideoFile(input, output string) (string, error) {
-	inputFile, err := os.Open(input)
+// convertVideoToGIF renders a clip as an animated GIF with a palette generated from the clip itself
+func (p *EnhancedVideoProcessor) convertVideoToGIF(input, output string, job *models.ProcessingJob) (string, error) {
+	fps, err := utils.GetIntSetting(job.Settings, "fps", 12)
+	if err != nil {
+		return "", err
+	}
+	if fps < 1 || fps > 50 {
+		return "", fmt.Errorf("fps must be between 1 and 50, got %d", fps)
+	}
+
+	width, err := utils.GetIntSetting(job.Settings, "width", 480)
 	if err != nil {
-		return "", fmt.Errorf("failed to open input video file: %w", err)
+		return "", err
+	}
+	if width < 2 {
+		return "", fmt.Errorf("invalid GIF width: %d", width)
 	}
-	defer inputFile.Close()
 
-	outputFile, err := os.Create(output)
+	loop, err := utils.GetIntSetting(job.Settings, "loop", 0)
+	if err != nil {
+		return "", err
+	}
+	if loop < 0 {
+		return "", fmt.Errorf("loop must be 0 (forever) or a positive count, got %d", loop)
+	}
+
+	startTime, err := utils.GetFloatSetting(job.Settings, "start_time", 0)
+	if err != nil {
+		return "", err
+	}
+	duration, err := utils.GetFloatSetting(job.Settings, "duration", 0)
 	if err != nil {
-		return "", fmt.Errorf("failed to create output video file: %w", err)
+		return "", err
 	}
-	defer outputFile.Close()
 
-	// Copy file contents
-	if _, err := inputFile.Seek(0, 0); err != nil {
-		return "", fmt.Errorf("failed to seek input file: %w", err)
+	// The graph goes in a script file: the executor strips the ';' that separates its chains from arguments
+	filterScript := output + ".filtergraph"
+	if err := os.WriteFile(filterScript, []byte(gifFilterGraph(fps, width)), 0600); err != nil {
+		return "", fmt.Errorf("failed to write GIF filter graph: %w", err)
 	}
+	defer os.Remove(filterScript)
 
-	if _, err := outputFile.ReadFrom(inputFile); err != nil {
-		return "", fmt.Errorf("failed to copy video file: %w", err)
+	args := gifEncodeArgs(input, output, filterScript, startTime, duration, loop)
+	if err := p.executor.ExecuteCommand("ffmpeg", args); err != nil {
+		return "", fmt.Errorf("ffmpeg GIF encode failed: %w", err)
 	}
 
 	return output, nil
 }
+
+// gifFilterGraph returns a single-pass palettegen/paletteuse graph, which avoids the banding of ffmpeg's
+// default 256-colour palette
+func gifFilterGraph(fps, width int) string {
+	return fmt.Sprintf(
+		"[0:v:0]fps=%d,scale='min(%d,iw)':-2:flags=lanczos,split[s0][s1];[s0]palettegen=stats_mode=diff[p];[s1][p]paletteuse=dither=bayer:bayer_scale=5:diff_mode=rectangle",
+		fps, width,
+	)
+}
+
+// gifEncodeArgs returns the ffmpeg arguments for a GIF encode reading its graph from filterScript
+func gifEncodeArgs(input, output, filterScript string, startTime, duration float64, loop int) []string {
+	args := []string{"-hide_banner", "-nostdin", "-loglevel", "error", "-y"}
+	if startTime > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(startTime, 'f', 3, 64))
+	}
+	if duration > 0 {
+		args = append(args, "-t", strconv.FormatFloat(duration, 'f', 3, 64))
+	}
+
+	return append(args,
+		"-i", input,
+		"-filter_complex_script", filterScript,
+		"-loop", strconv.Itoa(loop),
+		output,
+	)
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qoal/file-processor/utils"
+)
+
+func TestGIFEncodeArgsSurviveSanitizing(t *testing.T) {
+	executor := utils.NewSecureCommandExecutor(time.Minute)
+
+	tests := []struct {
+		name      string
+		startTime float64
+		duration  float64
+		loop      int
+		want      []string
+	}{
+		{
+			name: "whole clip",
+			want: []string{
+				"-hide_banner", "-nostdin", "-loglevel", "error", "-y",
+				"-i", "/tmp/in.mp4",
+				"-filter_complex_script", "/tmp/out.gif.filtergraph",
+				"-loop", "0",
+				"/tmp/out.gif",
+			},
+		},
+		{
+			name:      "trimmed, looping three times",
+			startTime: 1.5,
+			duration:  4,
+			loop:      3,
+			want: []string{
+				"-hide_banner", "-nostdin", "-loglevel", "error", "-y",
+				"-ss", "1.500", "-t", "4.000",
+				"-i", "/tmp/in.mp4",
+				"-filter_complex_script", "/tmp/out.gif.filtergraph",
+				"-loop", "3",
+				"/tmp/out.gif",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := gifEncodeArgs("/tmp/in.mp4", "/tmp/out.gif", "/tmp/out.gif.filtergraph", tt.startTime, tt.duration, tt.loop)
+			// ExecuteCommand hands exec the sanitized arguments
+			if got := executor.SanitizeArgs(args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("argv = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGIFFilterGraphKeepsChainSeparators(t *testing.T) {
+	graph := gifFilterGraph(12, 480)
+
+	chains := strings.Split(graph, ";")
+	if len(chains) != 3 {
+		t.Fatalf("graph has %d chains, want 3: %s", len(chains), graph)
+	}
+	if !strings.HasSuffix(chains[0], "split[s0][s1]") || !strings.HasPrefix(chains[1], "[s0]palettegen") || !strings.HasPrefix(chains[2], "[s1][p]paletteuse") {
+		t.Errorf("unexpected chains: %q", chains)
+	}
+	if !strings.Contains(chains[0], "fps=12,scale='min(480,iw)'") {
+		t.Errorf("fps and width not applied: %s", chains[0])
+	}
+}
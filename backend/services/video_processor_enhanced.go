@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/qoal/file-processor/config"
 	"github.com/qoal/file-processor/models"
@@ -18,12 +20,14 @@ type VideoPreset struct {
 }
 
 type EnhancedVideoProcessor struct {
-	config *config.Config
+	config   *config.Config
+	executor *utils.SecureCommandExecutor
 }
 
 func NewEnhancedVideoProcessor(cfg *config.Config) *EnhancedVideoProcessor {
 	return &EnhancedVideoProcessor{
-		config: cfg,
+		config:   cfg,
+		executor: utils.NewSecureCommandExecutor(30 * time.Minute),
 	}
 }
 
@@ -58,33 +62,27 @@ func (p *EnhancedVideoProcessor) ProcessVideo(job *models.ProcessingJob) error {
 }
 
 func (p *EnhancedVideoProcessor) executeVideoConversion(inputFile string, job *models.ProcessingJob) (string, error) {
-	ext, err := utils.GetVideoExtension(job.TargetFormat)
+	toGIF := strings.EqualFold(job.TargetFormat, "gif")
+	getExtension := utils.GetVideoExtension
+	if toGIF {
+		getExtension = utils.GetImageExtension
+	}
+	ext, err := getExtension(job.TargetFormat)
 	if err != nil {
 		return "", fmt.Errorf("failed to get target extension: %w", err)
 	}
 	outputFile := filepath.Join(p.config.OutputDir, job.JobID+"_output"+ext)
 
-	conversionType := job.SourceFormat + "_TO_" + job.TargetFormat
-	switch conversionType {
-	case "MP4_TO_AVI":
-		return p.convertMP4toAVI(inputFile, outputFile, job)
-	case "AVI_TO_MP4":
-		return p.convertAVItoMP4(inputFile, outputFile, job)
-	case "MP4_TO_MOV":
-		return p.convertMP4toMOV(inputFile, outputFile, job)
-	case "MOV_TO_MP4":
-		return p.convertMOVtoMP4(inputFile, outputFile, job)
-	case "MP4_TO_WEBM":
-		return p.convertMP4toWEBM(inputFile, outputFile, job)
-	case "WEBM_TO_MP4":
-		return p.convertWEBMtoMP4(inputFile, outputFile, job)
-	case "MP4_TO_MKV":
-		return p.convertMP4toMKV(inputFile, outputFile, job)
-	case "MKV_TO_MP4":
-		return p.convertMKVtoMP4(inputFile, outputFile, job)
-	default:
+	// Any supported source can be transcoded into any container that has a codec profile
+	if _, err := utils.GetVideoExtension(job.SourceFormat); err != nil {
+		conversionType := strings.ToUpper(job.SourceFormat) + "_TO_" + strings.ToUpper(job.TargetFormat)
 		return "", fmt.Errorf("unsupported video conversion: %s", conversionType)
 	}
+
+	if toGIF {
+		return p.convertVideoToGIF(inputFile, outputFile, job)
+	}
+	return p.transcodeVideo(inputFile, outputFile, job)
 }
 
 func (p *EnhancedVideoProcessor) getVideoPreset(settings map[string]interface{}) VideoPreset {
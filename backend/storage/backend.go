@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/qoal/file-processor/config"
+)
+
+// ErrNotSupported is returned by backends that can't perform an optional operation, such as presigning
+var ErrNotSupported = errors.New("operation not supported by this storage backend")
+
+// Backend stores uploads and processed files under slash-separated keys such as "uploads/2024/01/02/a_1234abcd.pdf".
+// Handlers and workers only ever hold keys, so jobs don't depend on which backend wrote them.
+type Backend interface {
+	// Save writes r to key; size is the expected length, or -1 when unknown
+	Save(key string, r io.Reader, size int64, contentType string) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	Stat(key string) (*ObjectInfo, error)
+	// PresignGet returns a time-limited URL that downloads key without credentials, or ErrNotSupported
+	PresignGet(key string, expiration time.Duration) (string, error)
+	// List returns the objects whose keys start with prefix
+	List(prefix string) ([]ObjectInfo, error)
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key        string
+	Size       int64
+	ETag       string // unquoted; for single-part S3 uploads the hex MD5 of the content, unless SSE-KMS encrypted it
+	ModTime    time.Time
+	Encryption string // the server-side encryption S3 applied, such as "AES256" or "aws:kms"; empty for local files
+}
+
+// LocalFiles is implemented by backends whose objects are plain files on this machine, so workers can
+// process them in place
+type LocalFiles interface {
+	LocalPath(key string) (string, error)
+}
+
+// RangeDownloader is implemented by backends that can fetch an object in parallel ranged parts
+type RangeDownloader interface {
+	DownloadToFile(ctx context.Context, key string, writer io.WriterAt) error
+}
+
+// DirectUploader is implemented by backends that clients can upload to directly through presigned URLs
+type DirectUploader interface {
+	PresignPut(key, contentType, contentMD5 string, expiration time.Duration) (string, map[string]string, error)
+	CreateMultipartUpload(key, contentType string) (string, error)
+	PresignUploadPart(key, uploadID string, partNumber int64, expiration time.Duration) (string, error)
+	CompleteMultipartUpload(key, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(key, uploadID string) error
+}
+
+// New returns the backend selected by cfg.StorageDriver: "local", "s3", or "minio" for any S3-compatible
+// service at cfg.S3Endpoint. Without a driver, S3 is used when a bucket is configured and local files otherwise.
+func New(cfg *config.Config) (Backend, error) {
+	driver := strings.ToLower(cfg.StorageDriver)
+	if driver == "" {
+		driver = "local"
+		if cfg.S3Bucket != "" {
+			driver = "s3"
+		}
+	}
+
+	opts := S3Options{
+		Region:         cfg.AWSRegion,
+		Bucket:         cfg.S3Bucket,
+		AccessKey:      cfg.AWSAccessKey,
+		SecretKey:      cfg.AWSSecretKey,
+		Endpoint:       cfg.S3Endpoint,
+		ForcePathStyle: cfg.S3PathStyle,
+		SSE:            cfg.S3SSE,
+		KMSKeyID:       cfg.S3KMSKeyID,
+	}
+
+	switch driver {
+	case "local":
+		dir := cfg.StorageDir
+		if dir == "" {
+			dir = "./storage"
+		}
+		return newBackend(NewLocalStorage(dir))
+	case "s3":
+		return newBackend(NewS3Storage(opts))
+	case "minio":
+		if opts.Endpoint == "" {
+			return nil, fmt.Errorf("the minio storage driver needs S3_ENDPOINT")
+		}
+		// S3-compatible servers rarely resolve bucket subdomains, so address buckets by path
+		opts.ForcePathStyle = true
+		if opts.Region == "" {
+			opts.Region = "us-east-1"
+		}
+		return newBackend(NewS3Storage(opts))
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %s", cfg.StorageDriver)
+	}
+}
+
+// newBackend keeps a failed constructor's nil pointer from turning into a non-nil Backend
+func newBackend[T Backend](backend T, err error) (Backend, error) {
+	if err != nil {
+		return nil, err
+	}
+	return backend, nil
+}
+
+// NewUploadKey returns a fresh key under uploads/ for a file, keeping its extension
+func NewUploadKey(filename string) string {
+	ext := FileExtension(filename)
+	baseName := filename[:len(filename)-len(ext)]
+	cleanBaseName := strings.ReplaceAll(baseName, " ", "_")
+	cleanBaseName = strings.ReplaceAll(cleanBaseName, "..", "_")
+	cleanBaseName = strings.ReplaceAll(cleanBaseName, "/", "_")
+
+	uniqueID := uuid.New().String()
+	return fmt.Sprintf("uploads/%s/%s_%s%s", time.Now().Format("2006/01/02"), cleanBaseName, uniqueID[:8], ext)
+}
+
+// BlobKey returns the key a user's upload is stored under by its content's SHA-256 digest, so the same
+// file uploaded again lands on the same key
+func BlobKey(userID, digest, filename string) string {
+	return fmt.Sprintf("blobs/%s/%s/%s%s", userID, digest[:2], digest, FileExtension(filename))
+}
+
+// OutputKey returns the key under processed/ for a job's output
+func OutputKey(jobID, targetFormat string) string {
+	return fmt.Sprintf("processed/%s/%s.%s", time.Now().Format("2006/01/02"), jobID, targetFormat)
+}
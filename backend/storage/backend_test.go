@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewUploadKey(t *testing.T) {
+	tests := []struct {
+		filename string
+		prefix   string
+		ext      string
+	}{
+		{"holiday photo.png", "holiday_photo_", ".png"},
+		{"site.tar.gz", "site_", ".tar.gz"},
+		{"../../etc/passwd.txt", "____etc_passwd_", ".txt"},
+	}
+
+	for _, tt := range tests {
+		key := NewUploadKey(tt.filename)
+		name := key[strings.LastIndex(key, "/")+1:]
+		if !strings.HasPrefix(key, "uploads/") || strings.Contains(key, "..") {
+			t.Errorf("NewUploadKey(%q) = %q, outside uploads/", tt.filename, key)
+		}
+		if !strings.HasPrefix(name, tt.prefix) || !strings.HasSuffix(name, tt.ext) {
+			t.Errorf("NewUploadKey(%q) = %q, want a name like %s<id>%s", tt.filename, key, tt.prefix, tt.ext)
+		}
+	}
+	if NewUploadKey("a.png") == NewUploadKey("a.png") {
+		t.Error("NewUploadKey() gave the same key twice")
+	}
+}
@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Encrypted objects start with encryptedMagic, then the object's own key sealed under the owner's data key.
+// The body follows in chunks of encryptedChunkSize plaintext bytes, each sealed on its own so objects stream
+// through in constant memory. A chunk's nonce is its index plus a final-chunk flag, so chunks can't be
+// reordered, and a truncated object fails to open rather than reading short.
+const (
+	encryptedMagic     = "QFE1"
+	encryptedChunkSize = 64 << 10
+
+	keySize        = 32
+	wrappedKeySize = 12 + keySize + 16 // nonce, sealed key, tag
+	headerSize     = len(encryptedMagic) + wrappedKeySize
+)
+
+// ErrNoUser is returned when an EncryptedBackend is used without ForUser
+var ErrNoUser = errors.New("encrypted storage needs the owning user; call storage.ForUser")
+
+// KeyProvider returns a user's 32-byte data key, creating it on first use
+type KeyProvider interface {
+	DataKey(userID string) ([]byte, error)
+}
+
+// UserScoped is implemented by backends that store each user's files differently
+type UserScoped interface {
+	ForUser(userID string) Backend
+}
+
+// ForUser returns the view of backend for userID's files; backends that don't care return themselves
+func ForUser(backend Backend, userID string) Backend {
+	if scoped, ok := backend.(UserScoped); ok {
+		return scoped.ForUser(userID)
+	}
+	return backend
+}
+
+// EncryptedBackend envelope-encrypts objects with AES-GCM before they reach the backend underneath, which
+// only ever holds ciphertext. Objects written before encryption was turned on still open as they are.
+//
+// Direct uploads can't pass through it and presigned downloads would serve ciphertext, so it offers neither.
+type EncryptedBackend struct {
+	inner  Backend
+	keys   KeyProvider
+	userID string
+}
+
+func NewEncryptedBackend(inner Backend, keys KeyProvider) *EncryptedBackend {
+	return &EncryptedBackend{inner: inner, keys: keys}
+}
+
+func (e *EncryptedBackend) ForUser(userID string) Backend {
+	return &EncryptedBackend{inner: e.inner, keys: e.keys, userID: userID}
+}
+
+func (e *EncryptedBackend) userKey() (cipher.AEAD, error) {
+	if e.userID == "" {
+		return nil, ErrNoUser
+	}
+	key, err := e.keys.DataKey(e.userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data key: %w", err)
+	}
+	return newGCM(key)
+}
+
+func (e *EncryptedBackend) Save(key string, r io.Reader, size int64, contentType string) error {
+	userKey, err := e.userKey()
+	if err != nil {
+		return err
+	}
+
+	objectKey := make([]byte, keySize)
+	if _, err := rand.Read(objectKey); err != nil {
+		return fmt.Errorf("failed to generate object key: %w", err)
+	}
+	header, err := sealObjectKey(userKey, objectKey)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(objectKey)
+	if err != nil {
+		return err
+	}
+
+	encryptedSize := int64(-1)
+	if size >= 0 {
+		chunks := max((size+encryptedChunkSize-1)/encryptedChunkSize, 1)
+		encryptedSize = int64(headerSize) + size + int64(aead.Overhead())*chunks
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encryptStream(pw, r, aead, header))
+	}()
+	err = e.inner.Save(key, pr, encryptedSize, contentType)
+	// Unblock the encrypting goroutine if the backend gave up early
+	pr.CloseWithError(io.ErrClosedPipe)
+	return err
+}
+
+func (e *EncryptedBackend) Open(key string) (io.ReadCloser, error) {
+	rc, err := e.inner.Open(key)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(rc, encryptedChunkSize+64)
+	magic, err := br.Peek(len(encryptedMagic))
+	if err != nil && err != io.EOF {
+		rc.Close()
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if string(magic) != encryptedMagic {
+		// Stored before encryption was enabled
+		return readCloser{br, rc}, nil
+	}
+
+	userKey, err := e.userKey()
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	objectKey, err := userKey.Open(nil, header[len(encryptedMagic):len(encryptedMagic)+12], header[len(encryptedMagic)+12:], []byte(encryptedMagic))
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("failed to unwrap file key: not encrypted for this user")
+	}
+	aead, err := newGCM(objectKey)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return readCloser{&decryptReader{src: br, aead: aead, header: header}, rc}, nil
+}
+
+func (e *EncryptedBackend) Delete(key string) error {
+	return e.inner.Delete(key)
+}
+
+// Stat and List report stored sizes, which include the encryption overhead
+func (e *EncryptedBackend) Stat(key string) (*ObjectInfo, error) {
+	return e.inner.Stat(key)
+}
+
+func (e *EncryptedBackend) List(prefix string) ([]ObjectInfo, error) {
+	return e.inner.List(prefix)
+}
+
+func (e *EncryptedBackend) PresignGet(key string, expiration time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealObjectKey returns the object header: the magic and the object key sealed under the user's key
+func sealObjectKey(userKey cipher.AEAD, objectKey []byte) ([]byte, error) {
+	nonce := make([]byte, userKey.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	header := append([]byte(encryptedMagic), nonce...)
+	return userKey.Seal(header, nonce, objectKey, []byte(encryptedMagic)), nil
+}
+
+// chunkNonce numbers chunks so each is sealed under a distinct nonce; the object key is never reused
+func chunkNonce(index uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], index)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func encryptStream(w io.Writer, r io.Reader, aead cipher.AEAD, header []byte) error {
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, encryptedChunkSize)
+	plain := make([]byte, encryptedChunkSize)
+	sealed := make([]byte, 0, encryptedChunkSize+aead.Overhead())
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(br, plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// A full chunk is only final if nothing follows it
+		final := n < encryptedChunkSize
+		if !final {
+			if _, peekErr := br.Peek(1); peekErr == io.EOF {
+				final = true
+			}
+		}
+
+		sealed = aead.Seal(sealed[:0], chunkNonce(index, final), plain[:n], header)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// decryptReader opens an encrypted body chunk by chunk
+type decryptReader struct {
+	src    *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	index  uint64
+	buf    []byte
+	plain  bytes.Reader
+	done   bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for d.plain.Len() == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	return d.plain.Read(p)
+}
+
+func (d *decryptReader) next() error {
+	if d.buf == nil {
+		d.buf = make([]byte, encryptedChunkSize+d.aead.Overhead())
+	}
+	n, err := io.ReadFull(d.src, d.buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return fmt.Errorf("encrypted file is truncated")
+		}
+		return err
+	}
+	final := n < len(d.buf)
+	if !final {
+		if _, peekErr := d.src.Peek(1); peekErr == io.EOF {
+			final = true
+		}
+	}
+
+	plain, err := d.aead.Open(d.buf[:0], chunkNonce(d.index, final), d.buf[:n], d.header)
+	if err != nil {
+		return fmt.Errorf("encrypted file is corrupt or truncated")
+	}
+	d.index++
+	d.done = final
+	d.plain.Reset(plain)
+	return nil
+}
+
+// readCloser reads through r and closes the underlying object
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// staticKeys gives each user a fixed data key
+type staticKeys map[string][]byte
+
+func (k staticKeys) DataKey(userID string) ([]byte, error) {
+	return k[userID], nil
+}
+
+func newTestEncryptedBackend(t *testing.T) (*LocalStorage, Backend) {
+	t.Helper()
+	local, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := staticKeys{"alice": bytes.Repeat([]byte{1}, keySize), "bob": bytes.Repeat([]byte{2}, keySize)}
+	return local, NewEncryptedBackend(local, keys)
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readAll(backend Backend, key string) ([]byte, error) {
+	rc, err := backend.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func TestEncryptedBackendRoundTrip(t *testing.T) {
+	local, backend := newTestEncryptedBackend(t)
+	alice := ForUser(backend, "alice")
+
+	sizes := []int{0, 1, encryptedChunkSize - 1, encryptedChunkSize, encryptedChunkSize + 1, 3 * encryptedChunkSize}
+	for _, size := range sizes {
+		data := randomBytes(t, size)
+		if err := alice.Save("file", bytes.NewReader(data), int64(size), "application/octet-stream"); err != nil {
+			t.Fatalf("size %d: Save: %v", size, err)
+		}
+
+		stored, err := readAll(local, "file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size > 0 && bytes.Contains(stored, data) {
+			t.Errorf("size %d: plaintext stored as is", size)
+		}
+		info, err := alice.Stat("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks := max((size+encryptedChunkSize-1)/encryptedChunkSize, 1)
+		if want := int64(headerSize + size + 16*chunks); info.Size != want {
+			t.Errorf("size %d: stored %d bytes, want %d", size, info.Size, want)
+		}
+
+		got, err := readAll(alice, "file")
+		if err != nil {
+			t.Fatalf("size %d: Open: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("size %d: round trip changed the content", size)
+		}
+	}
+}
+
+func TestEncryptedBackendDetectsTampering(t *testing.T) {
+	data := randomBytes(t, 2*encryptedChunkSize+100)
+	firstChunkEnd := headerSize + encryptedChunkSize + 16
+
+	tests := []struct {
+		name   string
+		user   string
+		tamper func([]byte) []byte
+	}{
+		{"flipped body byte", "alice", func(b []byte) []byte { b[headerSize+10] ^= 1; return b }},
+		{"flipped wrapped key byte", "alice", func(b []byte) []byte { b[len(encryptedMagic)+20] ^= 1; return b }},
+		{"truncated last chunk", "alice", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"dropped last chunk", "alice", func(b []byte) []byte { return b[:firstChunkEnd+encryptedChunkSize+16] }},
+		{"truncated at a chunk boundary", "alice", func(b []byte) []byte { return b[:firstChunkEnd] }},
+		{"swapped chunks", "alice", func(b []byte) []byte {
+			first := append([]byte(nil), b[headerSize:firstChunkEnd]...)
+			copy(b[headerSize:], b[firstChunkEnd:2*firstChunkEnd-headerSize])
+			copy(b[firstChunkEnd:], first)
+			return b
+		}},
+		{"other user", "bob", func(b []byte) []byte { return b }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, backend := newTestEncryptedBackend(t)
+			if err := ForUser(backend, "alice").Save("file", bytes.NewReader(data), int64(len(data)), ""); err != nil {
+				t.Fatal(err)
+			}
+			path, err := local.LocalPath("file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.tamper(stored), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := readAll(ForUser(backend, tt.user), "file"); err == nil {
+				t.Errorf("read %d bytes of a tampered file without error", len(got))
+			}
+		})
+	}
+}
+
+func TestEncryptedBackendReadsPlainFiles(t *testing.T) {
+	local, backend := newTestEncryptedBackend(t)
+	if err := local.Save("old/file.txt", strings.NewReader("stored before encryption"), -1, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readAll(ForUser(backend, "alice"), "old/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "stored before encryption" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestEncryptedBackendNeedsUser(t *testing.T) {
+	_, backend := newTestEncryptedBackend(t)
+	if err := backend.Save("file", strings.NewReader("data"), 4, ""); err != ErrNoUser {
+		t.Errorf("Save without a user: err = %v, want ErrNoUser", err)
+	}
+}
@@ -3,121 +3,187 @@ package storage
 import (
 	"fmt"
 	"io"
+	"io/fs"
 	"mime"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
-
-	"github.com/google/uuid"
 )
 
-// LocalStorage manages local file storage for uploads and processed files
+// LocalStorage keeps files under a root directory, each at the path its key names
 type LocalStorage struct {
-	UploadDir    string
-	ProcessedDir string
+	root string
 }
 
-// NewLocalStorage creates a new local storage instance
-func NewLocalStorage(uploadDir, processedDir string) *LocalStorage {
-	// Create directories if they don't exist
-	os.MkdirAll(uploadDir, 0755)
-	os.MkdirAll(processedDir, 0755)
-
-	return &LocalStorage{
-		UploadDir:    uploadDir,
-		ProcessedDir: processedDir,
+// NewLocalStorage creates a local storage rooted at dir
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage directory: %w", err)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
 	}
-}
-
-// SaveFile saves an uploaded file and returns the saved path
-func (ls *LocalStorage) SaveFile(file io.Reader, filename string, fileSize int64) (string, error) {
-	// Generate unique filename
-	ext := filepath.Ext(filename)
-	baseName := strings.TrimSuffix(filename, ext)
-	cleanBaseName := strings.ReplaceAll(baseName, " ", "_")
-	cleanBaseName = strings.ReplaceAll(cleanBaseName, "..", "_")
-
-	uniqueID := uuid.New().String()
-	savedFilename := fmt.Sprintf("%s_%s%s", cleanBaseName, uniqueID[:8], ext)
 
-	// Create subdirectory based on date for better organization
-	dateDir := time.Now().Format("2006/01/02")
-	uploadPath := filepath.Join(ls.UploadDir, dateDir)
+	return &LocalStorage{root: root}, nil
+}
 
-	// Ensure directory exists
-	if err := os.MkdirAll(uploadPath, 0755); err != nil {
-		return "", fmt.Errorf("failed to create upload directory: %v", err)
+// LocalPath returns the file behind key; it refuses keys that would resolve outside the root
+func (ls *LocalStorage) LocalPath(key string) (string, error) {
+	filePath := filepath.Join(ls.root, filepath.FromSlash(key))
+	if filePath != ls.root && !strings.HasPrefix(filePath, ls.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("access denied: file outside storage directory")
 	}
+	return filePath, nil
+}
 
-	fullPath := filepath.Join(uploadPath, savedFilename)
+// Save writes r to key, replacing any file already there
+func (ls *LocalStorage) Save(key string, r io.Reader, size int64, contentType string) error {
+	filePath, err := ls.LocalPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create storage directory: %w", err)
+	}
 
-	// Create file
-	out, err := os.Create(fullPath)
+	// Written beside the target and renamed over it, so readers never see a partial file
+	out, err := os.CreateTemp(filepath.Dir(filePath), ".save-*")
 	if err != nil {
-		return "", fmt.Errorf("failed to create file: %v", err)
+		return fmt.Errorf("failed to create file: %w", err)
 	}
+	defer os.Remove(out.Name()) // Clean up on error
 	defer out.Close()
 
-	// Copy file content
-	written, err := io.Copy(out, file)
+	written, err := io.Copy(out, r)
 	if err != nil {
-		os.Remove(fullPath) // Clean up on error
-		return "", fmt.Errorf("failed to save file: %v", err)
+		return fmt.Errorf("failed to save file: %w", err)
 	}
 
 	// Verify file size
-	if written != fileSize {
-		os.Remove(fullPath) // Clean up on error
-		return "", fmt.Errorf("file size mismatch: expected %d, got %d", fileSize, written)
+	if size >= 0 && written != size {
+		return fmt.Errorf("file size mismatch: expected %d, got %d", size, written)
 	}
 
-	return fullPath, nil
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+	if err := os.Chmod(out.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+	if err := os.Rename(out.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+	return nil
 }
 
-// GetFile retrieves a file by path
-func (ls *LocalStorage) GetFile(filePath string) (*os.File, error) {
-	// Security check: ensure file is within our directories
-	absPath, err := filepath.Abs(filePath)
+func (ls *LocalStorage) Open(key string) (io.ReadCloser, error) {
+	filePath, err := ls.LocalPath(key)
 	if err != nil {
-		return nil, fmt.Errorf("invalid file path: %v", err)
+		return nil, err
 	}
+	return os.Open(filePath)
+}
 
-	uploadAbs, _ := filepath.Abs(ls.UploadDir)
-	processedAbs, _ := filepath.Abs(ls.ProcessedDir)
+// Delete removes key; as on S3, a key that's already gone isn't an error
+func (ls *LocalStorage) Delete(key string) error {
+	filePath, err := ls.LocalPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
 
-	if !strings.HasPrefix(absPath, uploadAbs) && !strings.HasPrefix(absPath, processedAbs) {
-		return nil, fmt.Errorf("access denied: file outside allowed directories")
+func (ls *LocalStorage) Stat(key string) (*ObjectInfo, error) {
+	filePath, err := ls.LocalPath(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("failed to stat file: %s is a directory", key)
 	}
 
-	return os.Open(filePath)
+	return &ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
 }
 
-// DeleteFile removes a file
-func (ls *LocalStorage) DeleteFile(filePath string) error {
-	return os.Remove(filePath)
+// PresignGet isn't possible without a server to sign for; local files are served through the API
+func (ls *LocalStorage) PresignGet(key string, expiration time.Duration) (string, error) {
+	return "", ErrNotSupported
 }
 
-// GetOutputPath generates a path for processed files
-func (ls *LocalStorage) GetOutputPath(jobID string, targetFormat string) string {
-	dateDir := time.Now().Format("2006/01/02")
-	outputPath := filepath.Join(ls.ProcessedDir, dateDir)
-	os.MkdirAll(outputPath, 0755)
+// List walks the files under prefix, which like an S3 prefix need not end at a directory boundary
+func (ls *LocalStorage) List(prefix string) ([]ObjectInfo, error) {
+	dir, err := ls.LocalPath(path.Dir(prefix + "x"))
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []ObjectInfo
+	err = filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(ls.root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	return objects, nil
+}
+
+// compoundExtensions are multi-part extensions that filepath.Ext would cut down to their last part
+var compoundExtensions = []string{".tar.gz", ".tar.bz2", ".tar.xz"}
+
+// FileExtension returns the lower-cased extension of filename, keeping compound ones like ".tar.gz" whole
+func FileExtension(filename string) string {
+	lower := strings.ToLower(filename)
+	for _, ext := range compoundExtensions {
+		if strings.HasSuffix(lower, ext) && len(lower) > len(ext) {
+			return ext
+		}
+	}
+	return strings.ToLower(filepath.Ext(filename))
+}
 
-	return filepath.Join(outputPath, fmt.Sprintf("%s.%s", jobID, targetFormat))
+// FileFormat returns the source format of filename without the leading dot, e.g. "pdf" or "tar.gz"
+func FileFormat(filename string) string {
+	return strings.TrimPrefix(FileExtension(filename), ".")
 }
 
 // ValidateFileType checks if the file type is supported
 func ValidateFileType(filename string) (string, error) {
-	ext := strings.ToLower(filepath.Ext(filename))
+	ext := FileExtension(filename)
 
 	// Supported formats by category
 	supportedFormats := map[string][]string{
-		"image":    {".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".tiff", ".svg"},
+		"image":    {".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".tiff", ".svg", ".heic", ".heif"},
 		"video":    {".mp4", ".avi", ".mov", ".wmv", ".flv", ".mkv", ".webm", ".m4v"},
 		"audio":    {".mp3", ".wav", ".flac", ".aac", ".ogg", ".m4a", ".wma"},
-		"document": {".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".txt", ".rtf"},
-		"archive":  {".zip", ".rar", ".7z", ".tar", ".gz", ".bz2", ".xz"},
+		"document": {".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".txt", ".rtf", ".odt", ".ods", ".odp", ".csv", ".md"},
+		"archive":  {".zip", ".rar", ".7z", ".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz", ".gz", ".bz2", ".xz"},
 	}
 
 	for category, formats := range supportedFormats {
@@ -142,6 +208,9 @@ func GetMimeType(filename string) string {
 			".jpeg": "image/jpeg",
 			".png":  "image/png",
 			".gif":  "image/gif",
+			".webp": "image/webp",
+			".heic": "image/heic",
+			".heif": "image/heif",
 			".pdf":  "application/pdf",
 			".doc":  "application/msword",
 			".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
@@ -150,6 +219,11 @@ func GetMimeType(filename string) string {
 			".mp3":  "audio/mpeg",
 			".mp4":  "video/mp4",
 			".zip":  "application/zip",
+			".7z":   "application/x-7z-compressed",
+			".tar":  "application/x-tar",
+			".gz":   "application/gzip",
+			".bz2":  "application/x-bzip2",
+			".xz":   "application/x-xz",
 		}
 		if mimeType, ok := fallbacks[ext]; ok {
 			return mimeType
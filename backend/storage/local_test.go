@@ -0,0 +1,30 @@
+package storage
+
+import "testing"
+
+func TestFileExtension(t *testing.T) {
+	tests := []struct {
+		filename string
+		ext      string
+		format   string
+	}{
+		{"photo.JPG", ".jpg", "jpg"},
+		{"backup.tar.gz", ".tar.gz", "tar.gz"},
+		{"Backup.TAR.BZ2", ".tar.bz2", "tar.bz2"},
+		{"logs.tar.xz", ".tar.xz", "tar.xz"},
+		{"notes.gz", ".gz", "gz"},
+		{"archive.tgz", ".tgz", "tgz"},
+		{"my.report.v2.pdf", ".pdf", "pdf"},
+		{".tar.gz", ".gz", "gz"},
+		{"README", "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := FileExtension(tt.filename); got != tt.ext {
+			t.Errorf("FileExtension(%q) = %q, want %q", tt.filename, got, tt.ext)
+		}
+		if got := FileFormat(tt.filename); got != tt.format {
+			t.Errorf("FileFormat(%q) = %q, want %q", tt.filename, got, tt.format)
+		}
+	}
+}
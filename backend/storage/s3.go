@@ -1,11 +1,9 @@
 package storage
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -14,63 +12,128 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/google/uuid"
+)
+
+// Transfers move in parts of s3PartSize with s3Concurrency parts in flight, so a transfer holds at most
+// s3PartSize*s3Concurrency bytes in memory whatever the object's size
+const (
+	s3PartSize    = 16 << 20 // 16 MiB
+	s3Concurrency = 4
 )
 
 type S3Storage struct {
-	client   *s3.S3
-	uploader *s3manager.Uploader
-	bucket   string
+	client     *s3.S3
+	uploader   *s3manager.Uploader
+	downloader *s3manager.Downloader
+	bucket     string
+	sse        string
+	kmsKeyID   string
 }
 
-func NewS3Storage(region, bucket, accessKey, secretKey string) (*S3Storage, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(region),
-		Credentials: credentials.NewStaticCredentials(accessKey, secretKey, ""),
-	})
+// S3Options configures an S3Storage. Endpoint points it at an S3-compatible service such as MinIO, which
+// usually also needs ForcePathStyle.
+type S3Options struct {
+	Region         string
+	Bucket         string
+	AccessKey      string
+	SecretKey      string
+	Endpoint       string
+	ForcePathStyle bool
+	SSE            string // server-side encryption: "" (bucket default), "AES256" for SSE-S3 or "aws:kms" for SSE-KMS
+	KMSKeyID       string // SSE-KMS key; empty uses the account's default S3 key
+}
+
+func NewS3Storage(opts S3Options) (*S3Storage, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("S3 storage needs a bucket")
+	}
+	switch opts.SSE {
+	case "", s3.ServerSideEncryptionAes256:
+		if opts.KMSKeyID != "" {
+			return nil, fmt.Errorf("a KMS key ID needs aws:kms server-side encryption")
+		}
+	case s3.ServerSideEncryptionAwsKms:
+	default:
+		return nil, fmt.Errorf("unknown server-side encryption: %s", opts.SSE)
+	}
+
+	awsConfig := &aws.Config{
+		Region:           aws.String(opts.Region),
+		S3ForcePathStyle: aws.Bool(opts.ForcePathStyle),
+	}
+	// Without static keys the SDK falls back to its default chain: environment, shared config, instance role
+	if opts.AccessKey != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(opts.AccessKey, opts.SecretKey, "")
+	}
+	if opts.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(opts.Endpoint)
+	}
+
+	sess, err := session.NewSession(awsConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create AWS session: %w", err)
 	}
 
+	client := s3.New(sess)
 	return &S3Storage{
-		client:   s3.New(sess),
-		uploader: s3manager.NewUploader(sess),
-		bucket:   bucket,
+		client: client,
+		uploader: s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+			u.PartSize = s3PartSize
+			u.Concurrency = s3Concurrency
+		}),
+		downloader: s3manager.NewDownloaderWithClient(client, func(d *s3manager.Downloader) {
+			d.PartSize = s3PartSize
+			d.Concurrency = s3Concurrency
+		}),
+		bucket:   opts.Bucket,
+		sse:      opts.SSE,
+		kmsKeyID: opts.KMSKeyID,
 	}, nil
 }
 
-func (s *S3Storage) SaveFile(file io.Reader, filename string, fileSize int64) (string, error) {
-	ext := filepath.Ext(filename)
-	baseName := strings.TrimSuffix(filename, ext)
-	cleanBaseName := strings.ReplaceAll(baseName, " ", "_")
-	cleanBaseName = strings.ReplaceAll(cleanBaseName, "..", "_")
-
-	uniqueID := uuid.New().String()
-	key := fmt.Sprintf("uploads/%s/%s_%s%s", time.Now().Format("2006/01/02"), cleanBaseName, uniqueID[:8], ext)
+// encryption returns the server-side encryption fields for a write; nil leaves the bucket's default in force
+func (s *S3Storage) encryption() (sse, kmsKeyID *string) {
+	if s.sse == "" {
+		return nil, nil
+	}
+	if s.kmsKeyID != "" {
+		kmsKeyID = aws.String(s.kmsKeyID)
+	}
+	return aws.String(s.sse), kmsKeyID
+}
 
-	buf := new(bytes.Buffer)
-	_, err := io.Copy(buf, file)
-	if err != nil {
-		return "", fmt.Errorf("failed to read file: %w", err)
+// withPartSizeFor grows the part size when a known size would need more parts than S3 allows. Seekable
+// bodies are measured by the uploader itself; this covers readers that can only be streamed.
+func withPartSizeFor(size int64) func(*s3manager.Uploader) {
+	return func(u *s3manager.Uploader) {
+		if parts := size / u.PartSize; parts >= int64(u.MaxUploadParts) {
+			u.PartSize = size/int64(u.MaxUploadParts) + 1
+		}
 	}
+}
 
-	_, err = s.uploader.Upload(&s3manager.UploadInput{
+// Save streams r to S3 in parts; nothing beyond the parts in flight is held in memory. Passing an
+// *os.File lets the uploader read parts straight from disk instead of buffering them.
+func (s *S3Storage) Save(key string, r io.Reader, size int64, contentType string) error {
+	input := &s3manager.UploadInput{
 		Bucket:      aws.String(s.bucket),
 		Key:         aws.String(key),
-		Body:        bytes.NewReader(buf.Bytes()),
-		ContentType: aws.String(GetMimeType(filename)),
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to upload to S3: %w", err)
+		Body:        r,
+		ContentType: aws.String(contentType),
 	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = s.encryption()
 
-	return key, nil
+	_, err := s.uploader.Upload(input, withPartSizeFor(size))
+	if err != nil {
+		return fmt.Errorf("failed to upload to S3: %w", err)
+	}
+	return nil
 }
 
-func (s *S3Storage) GetFile(filePath string) (io.ReadCloser, error) {
+func (s *S3Storage) Open(key string) (io.ReadCloser, error) {
 	result, err := s.client.GetObject(&s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(filePath),
+		Key:    aws.String(key),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file from S3: %w", err)
@@ -79,10 +142,10 @@ func (s *S3Storage) GetFile(filePath string) (io.ReadCloser, error) {
 	return result.Body, nil
 }
 
-func (s *S3Storage) DeleteFile(filePath string) error {
+func (s *S3Storage) Delete(key string) error {
 	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(filePath),
+		Key:    aws.String(key),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to delete file from S3: %w", err)
@@ -91,36 +154,29 @@ func (s *S3Storage) DeleteFile(filePath string) error {
 	return nil
 }
 
-func (s *S3Storage) GetOutputPath(jobID string, targetFormat string) string {
-	return fmt.Sprintf("processed/%s/%s.%s", time.Now().Format("2006/01/02"), jobID, targetFormat)
-}
-
-func (s *S3Storage) SaveProcessedFile(file io.Reader, jobID, targetFormat string) (string, error) {
-	key := s.GetOutputPath(jobID, targetFormat)
-
-	buf := new(bytes.Buffer)
-	_, err := io.Copy(buf, file)
-	if err != nil {
-		return "", fmt.Errorf("failed to read file: %w", err)
-	}
-
-	_, err = s.uploader.Upload(&s3manager.UploadInput{
-		Bucket:      aws.String(s.bucket),
-		Key:         aws.String(key),
-		Body:        bytes.NewReader(buf.Bytes()),
-		ContentType: aws.String(GetMimeType("." + targetFormat)),
+// Stat reports the size, ETag and server-side encryption of the object at key
+func (s *S3Storage) Stat(key string) (*ObjectInfo, error) {
+	result, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to upload to S3: %w", err)
+		return nil, fmt.Errorf("failed to stat file in S3: %w", err)
 	}
 
-	return key, nil
+	return &ObjectInfo{
+		Key:        key,
+		Size:       aws.Int64Value(result.ContentLength),
+		ETag:       strings.Trim(aws.StringValue(result.ETag), `"`),
+		ModTime:    aws.TimeValue(result.LastModified),
+		Encryption: aws.StringValue(result.ServerSideEncryption),
+	}, nil
 }
 
-func (s *S3Storage) GetPresignedURL(filePath string, expiration time.Duration) (string, error) {
+func (s *S3Storage) PresignGet(key string, expiration time.Duration) (string, error) {
 	req, _ := s.client.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(filePath),
+		Key:    aws.String(key),
 	})
 
 	url, err := req.Presign(expiration)
@@ -131,9 +187,33 @@ func (s *S3Storage) GetPresignedURL(filePath string, expiration time.Duration) (
 	return url, nil
 }
 
+// List pages through every object under prefix
+func (s *S3Storage) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:     aws.StringValue(object.Key),
+				Size:    aws.Int64Value(object.Size),
+				ETag:    strings.Trim(aws.StringValue(object.ETag), `"`),
+				ModTime: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files in S3: %w", err)
+	}
+
+	return objects, nil
+}
+
+// DownloadToFile fetches an object with parallel ranged GETs, writing each part at its offset
 func (s *S3Storage) DownloadToFile(ctx context.Context, key string, writer io.WriterAt) error {
-	downloader := s3manager.NewDownloaderWithClient(s.client)
-	_, err := downloader.DownloadWithContext(ctx, writer, &s3.GetObjectInput{
+	_, err := s.downloader.DownloadWithContext(ctx, writer, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 	})
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// CompletedPart identifies an uploaded part of a multipart upload by the ETag S3 returned for it
+type CompletedPart struct {
+	PartNumber int64  `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+// PresignPut returns a URL that accepts one PUT of the object at key, and the headers the PUT must carry.
+// contentMD5 (base64, may be empty) is signed into the URL, so S3 rejects a body that doesn't match it;
+// so is the server-side encryption, so the upload can't skip it.
+func (s *S3Storage) PresignPut(key, contentType, contentMD5 string, expiration time.Duration) (string, map[string]string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	}
+	if contentMD5 != "" {
+		input.ContentMD5 = aws.String(contentMD5)
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = s.encryption()
+
+	req, _ := s.client.PutObjectRequest(input)
+	url, signed, err := req.PresignRequest(expiration)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate presigned upload URL: %w", err)
+	}
+
+	// The signer keys the headers in lower case, which http.Header.Get can't find
+	headers := make(map[string]string, len(signed))
+	for name, values := range signed {
+		if len(values) > 0 {
+			headers[http.CanonicalHeaderKey(name)] = values[0]
+		}
+	}
+	return url, headers, nil
+}
+
+// CreateMultipartUpload starts a multipart upload at key and returns its upload ID
+func (s *S3Storage) CreateMultipartUpload(key, contentType string) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = s.encryption()
+
+	result, err := s.client.CreateMultipartUpload(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	return aws.StringValue(result.UploadId), nil
+}
+
+// PresignUploadPart returns a URL that accepts one PUT of the given part of a multipart upload
+func (s *S3Storage) PresignUploadPart(key, uploadID string, partNumber int64, expiration time.Duration) (string, error) {
+	req, _ := s.client.UploadPartRequest(&s3.UploadPartInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(partNumber),
+	})
+	url, err := req.Presign(expiration)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate presigned part URL: %w", err)
+	}
+	return url, nil
+}
+
+// CompleteMultipartUpload assembles the parts into the object; S3 checks each ETag against the part it received
+func (s *S3Storage) CompleteMultipartUpload(key, uploadID string, parts []CompletedPart) error {
+	sorted := append([]CompletedPart(nil), parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].PartNumber < sorted[j].PartNumber })
+
+	completed := make([]*s3.CompletedPart, len(sorted))
+	for i, part := range sorted {
+		completed[i] = &s3.CompletedPart{PartNumber: aws.Int64(part.PartNumber), ETag: aws.String(part.ETag)}
+	}
+
+	_, err := s.client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and the parts already stored for it; an upload that was
+// already completed or aborted is left as it is
+func (s *S3Storage) AbortMultipartUpload(key, uploadID string) error {
+	_, err := s.client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchUpload {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestS3Storage returns an S3Storage that sends its requests to handler
+func newTestS3Storage(t *testing.T, handler http.HandlerFunc) *S3Storage {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	s, err := NewS3Storage(S3Options{
+		Region:         "us-east-1",
+		Bucket:         "bucket",
+		AccessKey:      "key",
+		SecretKey:      "secret",
+		Endpoint:       server.URL,
+		ForcePathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func s3Error(status int, code string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(status)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>` + code + `</Code><Message>test</Message></Error>`))
+	}
+}
+
+func TestAbortMultipartUpload(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantErr bool
+	}{
+		{"aborted", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodDelete || r.URL.Query().Get("uploadId") != "upload-1" {
+				t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}, false},
+		{"already completed or aborted", s3Error(http.StatusNotFound, "NoSuchUpload"), false},
+		{"denied", s3Error(http.StatusForbidden, "AccessDenied"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestS3Storage(t, tt.handler)
+			err := s.AbortMultipartUpload("uploads/file.bin", "upload-1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AbortMultipartUpload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewS3StorageEncryptionOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		sse     string
+		kmsKey  string
+		wantErr bool
+	}{
+		{"bucket default", "", "", false},
+		{"SSE-S3", "AES256", "", false},
+		{"SSE-KMS with the default key", "aws:kms", "", false},
+		{"SSE-KMS with a key", "aws:kms", "alias/files", false},
+		{"key without SSE-KMS", "AES256", "alias/files", true},
+		{"key without any SSE", "", "alias/files", true},
+		{"unknown", "aes128", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewS3Storage(S3Options{Region: "us-east-1", Bucket: "bucket", SSE: tt.sse, KMSKeyID: tt.kmsKey})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewS3Storage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
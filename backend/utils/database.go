@@ -26,6 +26,44 @@ func MigrateDatabase(db *gorm.DB) error {
 		return fmt.Errorf("failed to create User table: %w", err)
 	}
 
+	// Create UserKey table; it is never dropped, since files encrypted under a lost key can't be read
+	err = db.Exec(`CREATE TABLE IF NOT EXISTS qoal_user_key (
+		user_id UUID PRIMARY KEY,
+		wrapped_key BYTEA NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`).Error
+	if err != nil {
+		return fmt.Errorf("failed to create UserKey table: %w", err)
+	}
+
+	// Create Blob and conversion cache tables; they outlive the Job table, since they track stored files
+	err = db.Exec(`CREATE TABLE IF NOT EXISTS qoal_blob (
+		storage_key TEXT PRIMARY KEY,
+		user_id UUID NOT NULL,
+		digest VARCHAR(64),
+		size BIGINT NOT NULL DEFAULT 0,
+		ref_count INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`).Error
+	if err != nil {
+		return fmt.Errorf("failed to create Blob table: %w", err)
+	}
+
+	err = db.Exec(`CREATE TABLE IF NOT EXISTS qoal_conversion_cache (
+		cache_key VARCHAR(64) PRIMARY KEY,
+		user_id UUID NOT NULL,
+		input_digest VARCHAR(64) NOT NULL,
+		target_format VARCHAR(50) NOT NULL,
+		output_path TEXT NOT NULL,
+		manifest TEXT,
+		listing TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`).Error
+	if err != nil {
+		return fmt.Errorf("failed to create ConversionCache table: %w", err)
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_qoal_conversion_cache_output_path ON qoal_conversion_cache (output_path)`)
+
 	// Drop existing Job table if it exists with old schema
 	log.Println("Dropping existing Job table if it exists...")
 	db.Exec(`DROP TABLE IF EXISTS qoal_job`)
@@ -40,9 +78,17 @@ func MigrateDatabase(db *gorm.DB) error {
 		source_format VARCHAR(50) NOT NULL,
 		target_format VARCHAR(50) NOT NULL,
 		status VARCHAR(50) DEFAULT 'pending',
+		job_type VARCHAR(50) DEFAULT 'convert',
 		input_path TEXT NOT NULL,
+		input_paths TEXT,
+		input_digest VARCHAR(64),
+		held_blobs TEXT,
 		output_path TEXT,
 		error TEXT,
+		attempts INTEGER DEFAULT 0,
+		next_retry_at TIMESTAMP,
+		manifest TEXT,
+		listing TEXT,
 		completed_at TIMESTAMP,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
@@ -15,8 +15,13 @@ func GenerateFileHash(filePath string) (string, error) {
 	}
 	defer file.Close()
 
+	return HashReader(file)
+}
+
+// HashReader returns the hex SHA256 hash of everything read from r
+func HashReader(r io.Reader) (string, error) {
 	hasher := sha256.New()
-	if _, err := io.Copy(hasher, file); err != nil {
+	if _, err := io.Copy(hasher, r); err != nil {
 		return "", err
 	}
 
@@ -5,16 +5,42 @@ import (
 	"strings"
 )
 
+// archiveAliases maps the short and underscore spellings of compressed tarballs to their canonical format
+var archiveAliases = map[string]string{
+	"tgz":     "tar.gz",
+	"tar_gz":  "tar.gz",
+	"tbz":     "tar.bz2",
+	"tbz2":    "tar.bz2",
+	"tar_bz2": "tar.bz2",
+	"txz":     "tar.xz",
+	"tar_xz":  "tar.xz",
+}
+
+// NormalizeArchiveFormat returns the canonical name of an archive format, e.g. "tgz" becomes "tar.gz"
+func NormalizeArchiveFormat(format string) string {
+	format = strings.ToLower(strings.TrimPrefix(format, "."))
+	if canonical, exists := archiveAliases[format]; exists {
+		return canonical
+	}
+	return format
+}
+
 func GetArchiveExtension(format string) (string, error) {
-	switch strings.ToLower(format) {
+	switch NormalizeArchiveFormat(format) {
 	case "zip":
 		return ".zip", nil
 	case "7z":
 		return ".7z", nil
 	case "rar":
 		return ".rar", nil
+	case "tar":
+		return ".tar", nil
 	case "tar.gz":
 		return ".tar.gz", nil
+	case "tar.bz2":
+		return ".tar.bz2", nil
+	case "tar.xz":
+		return ".tar.xz", nil
 	default:
 		return "", fmt.Errorf("unsupported archive format: %s", format)
 	}
@@ -34,6 +60,8 @@ func GetAudioExtension(format string) (string, error) {
 		return ".m4a", nil
 	case "ogg":
 		return ".ogg", nil
+	case "wma":
+		return ".wma", nil
 	default:
 		return "", fmt.Errorf("unsupported audio format: %s", format)
 	}
@@ -63,6 +91,83 @@ func GetIntSetting(settings map[string]interface{}, key string, defaultValue int
 	return defaultValue, nil
 }
 
+func GetFloatSetting(settings map[string]interface{}, key string, defaultValue float64) (float64, error) {
+	if val, exists := settings[key]; exists {
+		switch v := val.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		default:
+			return 0, fmt.Errorf("invalid type for setting %s", key)
+		}
+	}
+	return defaultValue, nil
+}
+
+func GetBoolSetting(settings map[string]interface{}, key string, defaultValue bool) (bool, error) {
+	if val, exists := settings[key]; exists {
+		if boolVal, ok := val.(bool); ok {
+			return boolVal, nil
+		}
+		return false, fmt.Errorf("invalid type for setting %s", key)
+	}
+	return defaultValue, nil
+}
+
+func GetStringSliceSetting(settings map[string]interface{}, key string) ([]string, error) {
+	val, exists := settings[key]
+	if !exists {
+		return nil, nil
+	}
+
+	switch v := val.(type) {
+	case []string:
+		return v, nil
+	case []interface{}:
+		// Settings decoded from the queue arrive as []interface{}
+		result := make([]string, len(v))
+		for i, item := range v {
+			strVal, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid type for setting %s", key)
+			}
+			result[i] = strVal
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("invalid type for setting %s", key)
+	}
+}
+
+func GetIntSliceSetting(settings map[string]interface{}, key string) ([]int, error) {
+	val, exists := settings[key]
+	if !exists {
+		return nil, nil
+	}
+
+	switch v := val.(type) {
+	case []int:
+		return v, nil
+	case []interface{}:
+		// JSON numbers arrive as float64
+		result := make([]int, len(v))
+		for i, item := range v {
+			switch n := item.(type) {
+			case int:
+				result[i] = n
+			case float64:
+				result[i] = int(n)
+			default:
+				return nil, fmt.Errorf("invalid type for setting %s", key)
+			}
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("invalid type for setting %s", key)
+	}
+}
+
 func GetImageExtension(format string) (string, error) {
 	switch strings.ToLower(format) {
 	case "jpeg":
@@ -75,10 +180,14 @@ func GetImageExtension(format string) (string, error) {
 		return ".webp", nil
 	case "heic":
 		return ".heic", nil
+	case "heif":
+		return ".heif", nil
 	case "bmp":
 		return ".bmp", nil
 	case "tiff":
 		return ".tiff", nil
+	case "gif":
+		return ".gif", nil
 	default:
 		return "", fmt.Errorf("unsupported image format: %s", format)
 	}
@@ -92,12 +201,30 @@ func GetDocumentExtension(format string) (string, error) {
 		return ".docx", nil
 	case "doc":
 		return ".doc", nil
-	case "txt":
+	case "txt", "text":
 		return ".txt", nil
+	case "md":
+		return ".md", nil
+	case "html":
+		return ".html", nil
 	case "rtf":
 		return ".rtf", nil
 	case "odt":
 		return ".odt", nil
+	case "xlsx":
+		return ".xlsx", nil
+	case "xls":
+		return ".xls", nil
+	case "ods":
+		return ".ods", nil
+	case "csv":
+		return ".csv", nil
+	case "pptx":
+		return ".pptx", nil
+	case "ppt":
+		return ".ppt", nil
+	case "odp":
+		return ".odp", nil
 	default:
 		return "", fmt.Errorf("unsupported document format: %s", format)
 	}
@@ -0,0 +1,38 @@
+package utils
+
+import "testing"
+
+func TestNormalizeArchiveFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+		ext    string
+	}{
+		{"zip", "zip", ".zip"},
+		{".ZIP", "zip", ".zip"},
+		{"7z", "7z", ".7z"},
+		{"rar", "rar", ".rar"},
+		{"tgz", "tar.gz", ".tar.gz"},
+		{"tar_gz", "tar.gz", ".tar.gz"},
+		{"tbz2", "tar.bz2", ".tar.bz2"},
+		{"txz", "tar.xz", ".tar.xz"},
+		{"TAR.XZ", "tar.xz", ".tar.xz"},
+		{"tar", "tar", ".tar"},
+		{"cab", "cab", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if got := NormalizeArchiveFormat(tt.format); got != tt.want {
+				t.Errorf("NormalizeArchiveFormat(%q) = %q, want %q", tt.format, got, tt.want)
+			}
+			ext, err := GetArchiveExtension(tt.format)
+			if (err != nil) != (tt.ext == "") {
+				t.Fatalf("GetArchiveExtension(%q) error = %v", tt.format, err)
+			}
+			if ext != tt.ext {
+				t.Errorf("GetArchiveExtension(%q) = %q, want %q", tt.format, ext, tt.ext)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
 	"time"
@@ -29,20 +31,129 @@ func (e *SecureCommandExecutor) ExecuteCommand(name string, args []string) error
 	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
 	defer cancel()
 
-	// Execute command
+	// Execute command, keeping stderr so failures are diagnosable
 	cmd := exec.CommandContext(ctx, name, sanitizedArgs...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 
-	return cmd.Run()
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s timed out after %s", name, e.timeout)
+		}
+		return fmt.Errorf("%s failed: %w: %s", name, err, lastLines(stderr.String(), 5))
+	}
+
+	return nil
+}
+
+// ExecuteCommandOutput runs an allowed command and returns its standard output
+func (e *SecureCommandExecutor) ExecuteCommandOutput(name string, args []string) ([]byte, error) {
+	if !e.isAllowedCommand(name) {
+		return nil, fmt.Errorf("command not allowed: %s", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, e.SanitizeArgs(args)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s timed out after %s", name, e.timeout)
+		}
+		return nil, fmt.Errorf("%s failed: %w: %s", name, err, lastLines(stderr.String(), 5))
+	}
+
+	return output, nil
+}
+
+// StreamCommandOutput runs an allowed command and hands its standard output to read as it is produced.
+// If read returns an error the command is killed, so a reader that stops early never waits on it.
+//
+// Arguments are passed as given rather than sanitized: exec runs no shell, and callers use this for
+// arguments, such as archive entry names, that the command must receive byte for byte.
+func (e *SecureCommandExecutor) StreamCommandOutput(name string, args []string, read func(io.Reader) error) error {
+	if !e.isAllowedCommand(name) {
+		return fmt.Errorf("command not allowed: %s", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", name, err)
+	}
+
+	if readErr := read(stdout); readErr != nil {
+		cancel()
+		cmd.Wait()
+		return readErr
+	}
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s timed out after %s", name, e.timeout)
+		}
+		return fmt.Errorf("%s failed: %w: %s", name, err, lastLines(stderr.String(), 5))
+	}
+
+	return nil
+}
+
+// ExecuteCommandIn runs an allowed command with dir as its working directory. Like StreamCommandOutput it
+// passes arguments as given, for commands that take file names which must arrive unchanged.
+func (e *SecureCommandExecutor) ExecuteCommandIn(dir, name string, args []string) error {
+	if !e.isAllowedCommand(name) {
+		return fmt.Errorf("command not allowed: %s", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s timed out after %s", name, e.timeout)
+		}
+		return fmt.Errorf("%s failed: %w: %s", name, err, lastLines(stderr.String(), 5))
+	}
+
+	return nil
+}
+
+// lastLines returns the trailing n non-empty lines of command output
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, " | ")
 }
 
 func (e *SecureCommandExecutor) isAllowedCommand(name string) bool {
 	allowedCommands := map[string]bool{
 		"ffmpeg":      true,
+		"ffprobe":     true,
 		"convert":     true, // ImageMagick
 		"libreoffice": true,
 		"7z":          true,
 		"unzip":       true,
 		"clamscan":    true,
+		"qpdf":        true,
+		"gs":          true, // Ghostscript
 	}
 
 	// Extract base command name
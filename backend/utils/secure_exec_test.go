@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestZip(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "test.zip")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for path, data := range files {
+		entry, err := w.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestStreamCommandOutput(t *testing.T) {
+	if _, err := exec.LookPath("unzip"); err != nil {
+		t.Skip("unzip not installed")
+	}
+	large := bytes.Repeat([]byte("x"), 8<<20)
+	archive := writeTestZip(t, map[string][]byte{"a&b.txt": []byte("kept byte for byte"), "large.bin": large})
+	executor := NewSecureCommandExecutor(time.Minute)
+
+	t.Run("arguments are not sanitized", func(t *testing.T) {
+		var got []byte
+		err := executor.StreamCommandOutput("unzip", []string{"-p", archive, "a&b.txt"}, func(r io.Reader) error {
+			var err error
+			got, err = io.ReadAll(r)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "kept byte for byte" {
+			t.Errorf("got %q", got)
+		}
+	})
+
+	t.Run("reader error stops the command", func(t *testing.T) {
+		errStop := errors.New("stop")
+		err := executor.StreamCommandOutput("unzip", []string{"-p", archive, "large.bin"}, func(r io.Reader) error {
+			if _, err := io.ReadFull(r, make([]byte, 10)); err != nil {
+				return err
+			}
+			return errStop
+		})
+		if err != errStop {
+			t.Errorf("err = %v, want the reader's error", err)
+		}
+	})
+
+	t.Run("command failure", func(t *testing.T) {
+		err := executor.StreamCommandOutput("unzip", []string{"-p", filepath.Join(t.TempDir(), "missing.zip")}, func(r io.Reader) error {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		})
+		if err == nil {
+			t.Error("missing archive streamed without error")
+		}
+	})
+}
+
+func TestStreamCommandOutputRefusesUnlistedCommands(t *testing.T) {
+	executor := NewSecureCommandExecutor(time.Minute)
+	err := executor.StreamCommandOutput("sh", []string{"-c", "echo hi"}, func(r io.Reader) error {
+		t.Error("unlisted command ran")
+		return nil
+	})
+	if err == nil {
+		t.Error("unlisted command allowed")
+	}
+}
+
+func TestSanitizeArgs(t *testing.T) {
+	executor := NewSecureCommandExecutor(time.Minute)
+	got := executor.SanitizeArgs([]string{"a;b", "x&&y", "p|q", "`id`", "$HOME", "plain.txt"})
+	want := []string{"ab", "xy", "pq", "id", "HOME", "plain.txt"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("SanitizeArgs()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestExecuteCommandIn(t *testing.T) {
+	if _, err := exec.LookPath("unzip"); err != nil {
+		t.Skip("unzip not installed")
+	}
+	dir := t.TempDir()
+	archive := writeTestZip(t, map[string][]byte{"R&D $1.txt": []byte("kept")})
+	if err := os.Rename(archive, filepath.Join(dir, "R&D.zip")); err != nil {
+		t.Fatal(err)
+	}
+	executor := NewSecureCommandExecutor(time.Minute)
+
+	// A relative name resolves in dir, and neither name loses its & or $
+	if err := executor.ExecuteCommandIn(dir, "unzip", []string{"-o", "R&D.zip", "R&D $1.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "R&D $1.txt")); err != nil || string(data) != "kept" {
+		t.Errorf("extracted file = %q, %v", data, err)
+	}
+
+	if err := executor.ExecuteCommandIn(dir, "unzip", []string{"-o", "missing.zip"}); err == nil {
+		t.Error("missing archive extracted without error")
+	}
+	if err := executor.ExecuteCommandIn(dir, "sh", []string{"-c", "touch ran"}); err == nil {
+		t.Error("unlisted command allowed")
+	}
+}
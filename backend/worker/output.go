@@ -0,0 +1,30 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/qoal/file-processor/storage"
+)
+
+// storeOutput moves a job's output file from the scratch directory into storage and returns its key and size
+func storeOutput(backend storage.Backend, jobID, outputPath, targetFormat string) (string, int64, error) {
+	outputFile, err := os.Open(outputPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer outputFile.Close()
+	defer os.Remove(outputPath)
+
+	info, err := outputFile.Stat()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to stat output file: %w", err)
+	}
+
+	// Streamed from disk, so the output is never held in memory whole
+	key := storage.OutputKey(jobID, targetFormat)
+	if err := backend.Save(key, outputFile, info.Size(), storage.GetMimeType("."+targetFormat)); err != nil {
+		return "", 0, fmt.Errorf("failed to store output file: %w", err)
+	}
+	return key, info.Size(), nil
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log"
 	"strings"
-	"time"
 
 	"github.com/qoal/file-processor/config"
 	"github.com/qoal/file-processor/models"
@@ -19,7 +18,7 @@ type Processor struct {
 	redisClient       *redis.Client
 	jobService        *services.JobService
 	config            *config.Config
-	localStorage      *storage.LocalStorage
+	storage           storage.Backend
 	documentProcessor *services.EnhancedDocumentProcessor
 	imageProcessor    *services.EnhancedImageProcessor
 	videoProcessor    *services.EnhancedVideoProcessor
@@ -27,12 +26,13 @@ type Processor struct {
 	archiveProcessor  *services.ArchiveProcessor
 }
 
-func NewProcessor(jobService *services.JobService, cfg *config.Config, redisClient *redis.Client, localStorage *storage.LocalStorage) *Processor {
+// NewProcessor returns a worker that processes inputs in place, for backends whose objects are local files
+func NewProcessor(jobService *services.JobService, cfg *config.Config, redisClient *redis.Client, backend storage.Backend) *Processor {
 	return &Processor{
 		redisClient:       redisClient,
 		jobService:        jobService,
 		config:            cfg,
-		localStorage:      localStorage,
+		storage:           backend,
 		documentProcessor: services.NewEnhancedDocumentProcessor(cfg),
 		imageProcessor:    services.NewEnhancedImageProcessor(cfg),
 		videoProcessor:    services.NewEnhancedVideoProcessor(cfg),
@@ -44,44 +44,32 @@ func NewProcessor(jobService *services.JobService, cfg *config.Config, redisClie
 func (p *Processor) Start(ctx context.Context) {
 	log.Println("Starting job processor worker...")
 
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				log.Println("Worker shutting down...")
-				return
-			default:
-				// Get next job from queue
-				task, err := p.jobService.GetNextJobFromQueue(ctx)
-				if err != nil {
-					if err != redis.Nil {
-						log.Printf("Error getting job from queue: %v", err)
-					}
-					// Wait a bit before trying again
-					time.Sleep(1 * time.Second)
-					continue
-				}
-
-				log.Printf("Processing job: %s", task.JobID)
-				if err := p.ProcessJob(ctx, task); err != nil {
-					log.Printf("Job processing failed: %v", err)
-				}
-			}
-		}
-	}()
+	go consume(ctx, p.jobService, p.ProcessJob)
 }
 
 func (p *Processor) ProcessJob(ctx context.Context, task *services.JobTask) error {
-	// Update job status to processing
-	if err := p.jobService.UpdateJobStatus(ctx, task.JobID, models.StatusProcessing, "", ""); err != nil {
-		return fmt.Errorf("failed to update job status to processing: %w", err)
+	// Update job status to processing, unless a redelivered job already finished
+	started, err := p.jobService.StartJob(ctx, task)
+	if err != nil {
+		return err
+	}
+	if !started {
+		log.Printf("Skipping job %s: no longer pending", task.JobID)
+		return nil
+	}
+
+	// Resolve the input keys to the files behind them
+	inputPath, inputPaths, err := p.localInputs(task)
+	if err != nil {
+		return failJob(ctx, p.jobService, task, err)
 	}
 
 	// Create processing job model
 	processingJob := &models.ProcessingJob{
 		JobID:        task.JobID,
 		UserID:       task.UserID,
-		InputPath:    task.InputPath,
+		InputPath:    inputPath,
+		InputPaths:   inputPaths,
 		OutputPath:   task.OutputPath,
 		SourceFormat: task.SourceFormat,
 		TargetFormat: task.TargetFormat,
@@ -92,19 +80,26 @@ func (p *Processor) ProcessJob(ctx context.Context, task *services.JobTask) erro
 	log.Printf("Processing file: %s (format: %s -> %s)", task.InputPath, task.SourceFormat, task.TargetFormat)
 
 	// Determine file category and process accordingly
-	var err error
 	fileCategory := p.getFileCategory(task.SourceFormat)
 
-	switch fileCategory {
-	case "document":
+	switch {
+	case task.JobType == models.JobTypeMerge:
+		err = p.documentProcessor.MergeDocuments(processingJob)
+	case task.JobType == models.JobTypeResponsive:
+		err = p.imageProcessor.ProcessResponsiveImages(processingJob)
+	case task.JobType == models.JobTypeList:
+		err = p.archiveProcessor.ListArchive(processingJob)
+	case task.JobType == models.JobTypeExtract:
+		err = p.archiveProcessor.ExtractEntries(processingJob)
+	case fileCategory == "document":
 		err = p.documentProcessor.ProcessDocument(processingJob)
-	case "image":
+	case fileCategory == "image":
 		err = p.imageProcessor.ProcessImage(processingJob)
-	case "video":
+	case fileCategory == "video":
 		err = p.videoProcessor.ProcessVideo(processingJob)
-	case "audio":
+	case fileCategory == "audio":
 		err = p.audioProcessor.ProcessAudio(processingJob)
-	case "archive":
+	case fileCategory == "archive":
 		err = p.archiveProcessor.ProcessArchive(processingJob)
 	default:
 		err = fmt.Errorf("unsupported file category: %s", fileCategory)
@@ -112,27 +107,49 @@ func (p *Processor) ProcessJob(ctx context.Context, task *services.JobTask) erro
 
 	if err != nil {
 		// Update job status to failed
-		if updateErr := p.jobService.UpdateJobStatus(ctx, task.JobID, models.StatusFailed, "", err.Error()); updateErr != nil {
-			log.Printf("Failed to update job status to failed: %v", updateErr)
-		}
-		return fmt.Errorf("job processing failed: %w", err)
+		return failJob(ctx, p.jobService, task, err)
 	}
 
-	// Update job status to completed
-	if err := p.jobService.UpdateJobStatus(ctx, task.JobID, models.StatusCompleted, processingJob.OutputPath, ""); err != nil {
-		return fmt.Errorf("failed to update job status to completed: %w", err)
+	outputKey, outputSize, err := storeOutput(storage.ForUser(p.storage, task.UserID), task.JobID, processingJob.OutputPath, processingJob.TargetFormat)
+	if err != nil {
+		return failJob(ctx, p.jobService, task, err)
+	}
+	if err := p.jobService.CompleteJob(ctx, task, outputKey, outputSize, processingJob); err != nil {
+		return failJob(ctx, p.jobService, task, err)
 	}
 
 	log.Printf("Job %s completed successfully", task.JobID)
 	return nil
 }
 
+// localInputs maps a task's input keys to local file paths
+func (p *Processor) localInputs(task *services.JobTask) (string, []string, error) {
+	files, ok := p.storage.(storage.LocalFiles)
+	if !ok {
+		return "", nil, fmt.Errorf("storage backend does not keep local files")
+	}
+
+	inputPath, err := files.LocalPath(task.InputPath)
+	if err != nil {
+		return "", nil, err
+	}
+	var inputPaths []string
+	for _, key := range task.InputPaths {
+		path, err := files.LocalPath(key)
+		if err != nil {
+			return "", nil, err
+		}
+		inputPaths = append(inputPaths, path)
+	}
+	return inputPath, inputPaths, nil
+}
+
 // getFileCategory determines the file category based on format
 func (p *Processor) getFileCategory(format string) string {
 	format = strings.ToLower(format)
 
 	// Document formats
-	documentFormats := []string{"pdf", "doc", "docx", "xls", "xlsx", "ppt", "pptx", "txt", "rtf", "csv"}
+	documentFormats := []string{"pdf", "doc", "docx", "xls", "xlsx", "ppt", "pptx", "txt", "rtf", "csv", "odt", "ods", "odp", "md"}
 	for _, f := range documentFormats {
 		if format == f {
 			return "document"
@@ -140,7 +157,7 @@ func (p *Processor) getFileCategory(format string) string {
 	}
 
 	// Image formats
-	imageFormats := []string{"jpg", "jpeg", "png", "gif", "bmp", "webp", "tiff", "svg"}
+	imageFormats := []string{"jpg", "jpeg", "png", "gif", "bmp", "webp", "tiff", "svg", "heic", "heif"}
 	for _, f := range imageFormats {
 		if format == f {
 			return "image"
@@ -164,7 +181,7 @@ func (p *Processor) getFileCategory(format string) string {
 	}
 
 	// Archive formats
-	archiveFormats := []string{"zip", "rar", "7z", "tar", "gz", "bz2", "xz"}
+	archiveFormats := []string{"zip", "rar", "7z", "tar", "tar.gz", "tgz", "tar_gz", "tar.bz2", "tbz2", "tar_bz2", "tar.xz", "txz", "tar_xz", "gz", "bz2", "xz"}
 	for _, f := range archiveFormats {
 		if format == f {
 			return "archive"
@@ -1,7 +1,6 @@
 package worker
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -9,7 +8,6 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/qoal/file-processor/config"
@@ -22,7 +20,7 @@ type ProcessorS3 struct {
 	redisClient       *redis.Client
 	jobService        *services.JobService
 	config            *config.Config
-	s3Storage         *storage.S3Storage
+	storage           storage.Backend
 	documentProcessor *services.EnhancedDocumentProcessor
 	imageProcessor    *services.EnhancedImageProcessor
 	videoProcessor    *services.EnhancedVideoProcessor
@@ -30,12 +28,14 @@ type ProcessorS3 struct {
 	archiveProcessor  *services.ArchiveProcessor
 }
 
-func NewProcessorS3(jobService *services.JobService, cfg *config.Config, redisClient *redis.Client, s3Storage *storage.S3Storage) *ProcessorS3 {
+// NewProcessorS3 returns a worker that copies each job's inputs out of storage before processing them,
+// for backends such as S3 whose objects aren't local files
+func NewProcessorS3(jobService *services.JobService, cfg *config.Config, redisClient *redis.Client, backend storage.Backend) *ProcessorS3 {
 	return &ProcessorS3{
 		redisClient:       redisClient,
 		jobService:        jobService,
 		config:            cfg,
-		s3Storage:         s3Storage,
+		storage:           backend,
 		documentProcessor: services.NewEnhancedDocumentProcessor(cfg),
 		imageProcessor:    services.NewEnhancedImageProcessor(cfg),
 		videoProcessor:    services.NewEnhancedVideoProcessor(cfg),
@@ -47,62 +47,47 @@ func NewProcessorS3(jobService *services.JobService, cfg *config.Config, redisCl
 func (p *ProcessorS3) Start(ctx context.Context) {
 	log.Println("Starting S3 job processor worker...")
 
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				log.Println("S3 Worker shutting down...")
-				return
-			default:
-				task, err := p.jobService.GetNextJobFromQueue(ctx)
-				if err != nil {
-					if err != redis.Nil {
-						log.Printf("Error getting job from queue: %v", err)
-					}
-					time.Sleep(1 * time.Second)
-					continue
-				}
-
-				log.Printf("Processing job: %s", task.JobID)
-				if err := p.ProcessJob(ctx, task); err != nil {
-					log.Printf("Job processing failed: %v", err)
-				}
-			}
-		}
-	}()
+	go consume(ctx, p.jobService, p.ProcessJob)
 }
 
 func (p *ProcessorS3) ProcessJob(ctx context.Context, task *services.JobTask) error {
-	if err := p.jobService.UpdateJobStatus(ctx, task.JobID, models.StatusProcessing, "", ""); err != nil {
-		return fmt.Errorf("failed to update job status to processing: %w", err)
-	}
-
-	// Download from S3 to temp
-	tempInput := filepath.Join(p.config.TempDir, task.JobID+"_input"+filepath.Ext(task.InputPath))
-	inputFile, err := os.Create(tempInput)
-	if err != nil {
-		return fmt.Errorf("failed to create temp input file: %w", err)
-	}
-
-	s3File, err := p.s3Storage.GetFile(task.InputPath)
+	// Update job status to processing, unless a redelivered job already finished
+	started, err := p.jobService.StartJob(ctx, task)
 	if err != nil {
-		inputFile.Close()
-		os.Remove(tempInput)
-		return fmt.Errorf("failed to download from S3: %w", err)
-	}
-
-	_, err = io.Copy(inputFile, s3File)
-	s3File.Close()
-	inputFile.Close()
-	if err != nil {
-		os.Remove(tempInput)
-		return fmt.Errorf("failed to copy file: %w", err)
+		return err
+	}
+	if !started {
+		log.Printf("Skipping job %s: no longer pending", task.JobID)
+		return nil
+	}
+
+	// Download from storage to temp; merge jobs carry every source in order
+	tempInput := filepath.Join(p.config.TempDir, task.JobID+"_input"+storage.FileExtension(task.InputPath))
+	var tempInputs []string
+	if task.JobType == models.JobTypeMerge {
+		tempInputs = make([]string, len(task.InputPaths))
+		for i, inputPath := range task.InputPaths {
+			tempInputs[i] = filepath.Join(p.config.TempDir, fmt.Sprintf("%s_input_%03d%s", task.JobID, i, storage.FileExtension(inputPath)))
+			if err := p.downloadToTemp(ctx, task.UserID, inputPath, tempInputs[i]); err != nil {
+				return failJob(ctx, p.jobService, task, err)
+			}
+			defer os.Remove(tempInputs[i])
+		}
+		if len(tempInputs) > 0 {
+			tempInput = tempInputs[0]
+		}
+	} else {
+		if err := p.downloadToTemp(ctx, task.UserID, task.InputPath, tempInput); err != nil {
+			return failJob(ctx, p.jobService, task, err)
+		}
+		defer os.Remove(tempInput)
 	}
 
 	processingJob := &models.ProcessingJob{
 		JobID:        task.JobID,
 		UserID:       task.UserID,
 		InputPath:    tempInput,
+		InputPaths:   tempInputs,
 		OutputPath:   "",
 		SourceFormat: task.SourceFormat,
 		TargetFormat: task.TargetFormat,
@@ -113,68 +98,86 @@ func (p *ProcessorS3) ProcessJob(ctx context.Context, task *services.JobTask) er
 	log.Printf("Processing file: %s (format: %s -> %s)", task.InputPath, task.SourceFormat, task.TargetFormat)
 
 	fileCategory := p.getFileCategory(task.SourceFormat)
-	switch fileCategory {
-	case "document":
+	switch {
+	case task.JobType == models.JobTypeMerge:
+		err = p.documentProcessor.MergeDocuments(processingJob)
+	case task.JobType == models.JobTypeResponsive:
+		err = p.imageProcessor.ProcessResponsiveImages(processingJob)
+	case task.JobType == models.JobTypeList:
+		err = p.archiveProcessor.ListArchive(processingJob)
+	case task.JobType == models.JobTypeExtract:
+		err = p.archiveProcessor.ExtractEntries(processingJob)
+	case fileCategory == "document":
 		err = p.documentProcessor.ProcessDocument(processingJob)
-	case "image":
+	case fileCategory == "image":
 		err = p.imageProcessor.ProcessImage(processingJob)
-	case "video":
+	case fileCategory == "video":
 		err = p.videoProcessor.ProcessVideo(processingJob)
-	case "audio":
+	case fileCategory == "audio":
 		err = p.audioProcessor.ProcessAudio(processingJob)
-	case "archive":
+	case fileCategory == "archive":
 		err = p.archiveProcessor.ProcessArchive(processingJob)
 	default:
 		err = fmt.Errorf("unsupported file category: %s", fileCategory)
 	}
 
-	os.Remove(tempInput)
-
 	if err != nil {
-		if updateErr := p.jobService.UpdateJobStatus(ctx, task.JobID, models.StatusFailed, "", err.Error()); updateErr != nil {
-			log.Printf("Failed to update job status to failed: %v", updateErr)
-		}
-		return fmt.Errorf("job processing failed: %w", err)
+		return failJob(ctx, p.jobService, task, err)
 	}
 
-	// Upload result to S3
-	outputFile, err := os.Open(processingJob.OutputPath)
+	outputKey, outputSize, err := storeOutput(storage.ForUser(p.storage, task.UserID), task.JobID, processingJob.OutputPath, processingJob.TargetFormat)
 	if err != nil {
-		return fmt.Errorf("failed to open output file: %w", err)
+		return failJob(ctx, p.jobService, task, err)
 	}
-	defer outputFile.Close()
-	defer os.Remove(processingJob.OutputPath)
-
-	buf := new(bytes.Buffer)
-	_, err = io.Copy(buf, outputFile)
-	if err != nil {
-		return fmt.Errorf("failed to read output file: %w", err)
+	if err := p.jobService.CompleteJob(ctx, task, outputKey, outputSize, processingJob); err != nil {
+		return failJob(ctx, p.jobService, task, err)
 	}
 
-	s3OutputPath, err := p.s3Storage.SaveProcessedFile(bytes.NewReader(buf.Bytes()), task.JobID, task.TargetFormat)
+	log.Printf("Job %s completed successfully", task.JobID)
+	return nil
+}
+
+// downloadToTemp copies a user's object to a local path for processing, decrypting it if it was stored
+// encrypted, and in parallel ranged parts where the backend can
+func (p *ProcessorS3) downloadToTemp(ctx context.Context, userID, key, dest string) error {
+	backend := storage.ForUser(p.storage, userID)
+
+	inputFile, err := os.Create(dest)
 	if err != nil {
-		return fmt.Errorf("failed to upload to S3: %w", err)
+		return fmt.Errorf("failed to create temp input file: %w", err)
 	}
 
-	if err := p.jobService.UpdateJobStatus(ctx, task.JobID, models.StatusCompleted, s3OutputPath, ""); err != nil {
-		return fmt.Errorf("failed to update job status to completed: %w", err)
+	if downloader, ok := backend.(storage.RangeDownloader); ok {
+		err = downloader.DownloadToFile(ctx, key, inputFile)
+	} else {
+		var src io.ReadCloser
+		if src, err = backend.Open(key); err == nil {
+			_, err = io.Copy(inputFile, src)
+			src.Close()
+		}
+	}
+	if closeErr := inputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("failed to download input file: %w", err)
 	}
 
-	log.Printf("Job %s completed successfully", task.JobID)
 	return nil
 }
 
 func (p *ProcessorS3) getFileCategory(format string) string {
 	format = strings.ToLower(format)
 
-	documentFormats := []string{"pdf", "doc", "docx", "xls", "xlsx", "ppt", "pptx", "txt", "rtf", "csv"}
+	documentFormats := []string{"pdf", "doc", "docx", "xls", "xlsx", "ppt", "pptx", "txt", "rtf", "csv", "odt", "ods", "odp", "md"}
 	for _, f := range documentFormats {
 		if format == f {
 			return "document"
 		}
 	}
 
-	imageFormats := []string{"jpg", "jpeg", "png", "gif", "bmp", "webp", "tiff", "svg"}
+	imageFormats := []string{"jpg", "jpeg", "png", "gif", "bmp", "webp", "tiff", "svg", "heic", "heif"}
 	for _, f := range imageFormats {
 		if format == f {
 			return "image"
@@ -195,7 +198,7 @@ func (p *ProcessorS3) getFileCategory(format string) string {
 		}
 	}
 
-	archiveFormats := []string{"zip", "rar", "7z", "tar", "gz", "bz2", "xz"}
+	archiveFormats := []string{"zip", "rar", "7z", "tar", "tar.gz", "tgz", "tar_gz", "tar.bz2", "tbz2", "tar_bz2", "tar.xz", "txz", "tar_xz", "gz", "bz2", "xz"}
 	for _, f := range archiveFormats {
 		if format == f {
 			return "archive"
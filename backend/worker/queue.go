@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/qoal/file-processor/services"
+)
+
+// consume runs process on each task taken from the queue until ctx is done, acknowledging each task once
+// process returns; a failed job is retried through failJob, not by redelivery. While it runs, it keeps
+// the worker's heartbeat alive and requeues the tasks of workers that died mid-job.
+func consume(ctx context.Context, jobService *services.JobService, process func(context.Context, *services.JobTask) error) {
+	workerID := services.NewWorkerID()
+	if err := jobService.Heartbeat(ctx, workerID); err != nil {
+		log.Printf("Error sending worker heartbeat: %v", err)
+	}
+	go keepAlive(ctx, jobService, workerID)
+	log.Printf("Worker %s taking jobs from the queue", workerID)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Worker shutting down...")
+			return
+		default:
+		}
+
+		task, err := jobService.GetNextJobFromQueue(ctx, workerID)
+		if err == redis.Nil {
+			// Nothing queued within the poll wait
+			continue
+		}
+		if err != nil {
+			log.Printf("Error getting job from queue: %v", err)
+			// Wait a bit before trying again
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		log.Printf("Processing job: %s", task.JobID)
+		if err := process(ctx, task); err != nil {
+			log.Printf("Job processing failed: %v", err)
+		}
+		if err := jobService.AckJob(ctx, workerID, task); err != nil {
+			log.Printf("Error acknowledging job %s: %v", task.JobID, err)
+		}
+	}
+}
+
+// keepAlive sends the worker's heartbeat, looks for stalled workers and cleans up the uploads of expired
+// upload sessions every HeartbeatInterval; in between, it queues retries as their backoff runs out
+func keepAlive(ctx context.Context, jobService *services.JobService, workerID string) {
+	ticker := time.NewTicker(services.HeartbeatInterval)
+	defer ticker.Stop()
+	retryTicker := time.NewTicker(time.Second)
+	defer retryTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-retryTicker.C:
+			if _, err := jobService.PromoteDueRetries(ctx); err != nil {
+				log.Printf("Error requeuing due retries: %v", err)
+			}
+		case <-ticker.C:
+			if err := jobService.Heartbeat(ctx, workerID); err != nil {
+				log.Printf("Error sending worker heartbeat: %v", err)
+			}
+			if _, err := jobService.RequeueStalledJobs(ctx); err != nil {
+				log.Printf("Error requeuing stalled jobs: %v", err)
+			}
+			if _, err := jobService.CleanupExpiredUploads(ctx); err != nil {
+				log.Printf("Error cleaning up expired uploads: %v", err)
+			}
+		}
+	}
+}
+
+// failJob records a failed attempt, which the job service retries or fails for good depending on err
+func failJob(ctx context.Context, jobService *services.JobService, task *services.JobTask, err error) error {
+	if recordErr := jobService.FailJob(ctx, task, err); recordErr != nil {
+		log.Printf("Failed to record job failure: %v", recordErr)
+	}
+	return fmt.Errorf("job processing failed: %w", err)
+}